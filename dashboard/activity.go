@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// requests started within the lookback are tracked on the first refresh
+	activeLookback = 24 * time.Hour
+	// requests are listed again from before the latest one as traces are
+	// reported with a delay
+	activeOverlap = time.Minute
+	// minimum interval between two refreshes of the tracked states
+	activeRefreshInterval = 5 * time.Second
+	// state lookups replied without a state after which a request is
+	// considered ended, the flow removes the state of the requests which
+	// ended. Lookups failing for another reason are not counted
+	maxStateFailures = 3
+
+	activeRequests = newRequestTracker()
+)
+
+// trackedFlow the requests of a flow whose state may still change, and the
+// requests seen in a final state within the overlap
+type trackedFlow struct {
+	// start time of the latest listed request in microseconds
+	lastStart int
+	pending   map[string]*trackedRequest
+	final     map[string]int
+}
+
+// trackedRequest the last known state of a request and the number of
+// lookups of its state since the last one read which found no state
+type trackedRequest struct {
+	traceID   string
+	startTime int
+	state     string
+	failures  int
+}
+
+// requestTracker track the state of the requests of every flow incrementally,
+// each refresh lists the requests started since the previous one and only
+// queries the state of the requests which are not final yet
+type requestTracker struct {
+	// refreshLock serializes the refreshes, lock guards the flows
+	refreshLock sync.Mutex
+	lock        sync.Mutex
+	flows       map[string]*trackedFlow
+	refreshed   time.Time
+}
+
+// newRequestTracker create an empty request tracker
+func newRequestTracker() *requestTracker {
+	return &requestTracker{flows: make(map[string]*trackedFlow)}
+}
+
+// isActiveState check if a request is being executed or waits to be resumed
+func isActiveState(state string) bool {
+	return state == "RUNNING" || state == "PAUSED"
+}
+
+// observe record a state of a request read elsewhere, such as by a stream
+func (tracker *requestTracker) observe(flowName, requestID, state string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	flow, found := tracker.flows[flowName]
+	if !found {
+		return
+	}
+	request, found := flow.pending[requestID]
+	if !found || state == "" || state == "UNKNOWN" {
+		return
+	}
+	if isFinalState(state) {
+		flow.final[requestID] = request.startTime
+		delete(flow.pending, requestID)
+		return
+	}
+	request.state = state
+}
+
+// refreshStale refresh the states when older than activeRefreshInterval
+func (tracker *requestTracker) refreshStale(ctx context.Context, functions []*Function) {
+	tracker.refreshLock.Lock()
+	defer tracker.refreshLock.Unlock()
+	if time.Since(tracker.refreshed) >= activeRefreshInterval {
+		tracker.refresh(ctx, functions)
+	}
+}
+
+// count get the number of active requests of the flows, the states are
+// refreshed when older than activeRefreshInterval
+func (tracker *requestTracker) count(ctx context.Context, functions []*Function) int {
+	tracker.refreshStale(ctx, functions)
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	active := 0
+	for _, function := range functions {
+		flow, found := tracker.flows[function.Name]
+		if !found {
+			continue
+		}
+		for _, request := range flow.pending {
+			if isActiveState(request.state) {
+				active++
+			}
+		}
+	}
+	return active
+}
+
+// running get the running requests of a flow, the states are refreshed when
+// older than activeRefreshInterval
+func (tracker *requestTracker) running(ctx context.Context, functions []*Function, flowName string) []*RequestSummary {
+	tracker.refreshStale(ctx, functions)
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	requests := make([]*RequestSummary, 0)
+	flow, found := tracker.flows[flowName]
+	if !found {
+		return requests
+	}
+	for requestID, request := range flow.pending {
+		if request.state == "RUNNING" {
+			requests = append(requests, &RequestSummary{
+				RequestID: requestID,
+				TraceID:   request.traceID,
+				StartTime: request.startTime,
+			})
+		}
+	}
+	return requests
+}
+
+// refresh list the new requests of the flows and update the state of the
+// requests which are not final, lookups not completed within the context are
+// retried on the next refresh
+func (tracker *requestTracker) refresh(ctx context.Context, functions []*Function) {
+	now := int(time.Now().UnixNano() / 1000)
+	lookback := int(activeLookback / time.Microsecond)
+	overlap := int(activeOverlap / time.Microsecond)
+
+	tracker.lock.Lock()
+	flows := make(map[string]*trackedFlow, len(functions))
+	starts := make([]int, len(functions))
+	for index, function := range functions {
+		flow, found := tracker.flows[function.Name]
+		if !found {
+			flow = &trackedFlow{
+				pending: make(map[string]*trackedRequest),
+				final:   make(map[string]int),
+			}
+		}
+		flows[function.Name] = flow
+		if flow.lastStart > 0 {
+			starts[index] = flow.lastStart - overlap
+		} else {
+			starts[index] = now - lookback
+		}
+	}
+	// removed flows are not tracked anymore
+	tracker.flows = flows
+	tracker.lock.Unlock()
+
+	listed := make([][]*RequestSummary, len(functions))
+	listCompleted := fanOut(ctx, len(functions), func(ctx context.Context, index int) error {
+		requests, err := listFlowRequests(ctx, functions[index].Name, &RequestQuery{Start: starts[index]})
+		if err != nil {
+			log.Printf("failed to list requests of %s, error: %v", functions[index].Name, err)
+			return err
+		}
+		listed[index] = requests.Requests
+		return nil
+	})
+
+	type lookup struct {
+		flow      string
+		requestID string
+	}
+	lookups := make([]lookup, 0)
+
+	tracker.lock.Lock()
+	for index, function := range functions {
+		flow := flows[function.Name]
+		if listCompleted[index] {
+			for _, summary := range listed[index] {
+				if _, final := flow.final[summary.RequestID]; final {
+					continue
+				}
+				if _, found := flow.pending[summary.RequestID]; !found {
+					flow.pending[summary.RequestID] = &trackedRequest{
+						traceID:   summary.TraceID,
+						startTime: summary.StartTime,
+					}
+				}
+				if summary.StartTime > flow.lastStart {
+					flow.lastStart = summary.StartTime
+				}
+			}
+		}
+		for requestID := range flow.pending {
+			lookups = append(lookups, lookup{flow: function.Name, requestID: requestID})
+		}
+		// final requests older than the overlap are not listed again
+		for requestID, startTime := range flow.final {
+			if startTime < flow.lastStart-overlap {
+				delete(flow.final, requestID)
+			}
+		}
+	}
+	tracker.lock.Unlock()
+
+	states := make([]string, len(lookups))
+	// stateGone set when the flow replied it has no state for the request,
+	// a lookup which failed otherwise is retried on the next refresh
+	stateGone := make([]bool, len(lookups))
+	stateCompleted := fanOut(ctx, len(lookups), func(ctx context.Context, index int) error {
+		state, err := getRequestStatus(ctx, lookups[index].flow, lookups[index].requestID)
+		switch {
+		case err == nil && state != "":
+			states[index] = state
+			return nil
+		case err == nil || isNotFound(err):
+			stateGone[index] = true
+			return fmt.Errorf("no state for request %s", lookups[index].requestID)
+		case ctx.Err() == nil:
+			log.Printf("failed to get state of %s request %s, retrying on the next refresh, error: %v",
+				lookups[index].flow, lookups[index].requestID, err)
+		}
+		return err
+	})
+	timedOut := ctx.Err() != nil
+
+	tracker.lock.Lock()
+	for index, lookup := range lookups {
+		flow := flows[lookup.flow]
+		request, found := flow.pending[lookup.requestID]
+		if !found {
+			continue
+		}
+		if !stateCompleted[index] {
+			if stateGone[index] {
+				request.failures++
+			}
+			switch {
+			case request.failures >= maxStateFailures:
+				// the state was removed as the request ended, it is not
+				// active anymore whatever its last known state
+				log.Printf("giving up %s request %s after %d failed state lookups",
+					lookup.flow, lookup.requestID, request.failures)
+				flow.final[lookup.requestID] = request.startTime
+				delete(flow.pending, lookup.requestID)
+			case !timedOut && request.startTime < now-lookback:
+				// a request whose state can not be read is given up after
+				// the lookback
+				delete(flow.pending, lookup.requestID)
+			}
+			continue
+		}
+		request.failures = 0
+		if isFinalState(states[index]) {
+			flow.final[lookup.requestID] = request.startTime
+			delete(flow.pending, lookup.requestID)
+			continue
+		}
+		if !isActiveState(states[index]) && request.startTime < now-lookback {
+			delete(flow.pending, lookup.requestID)
+			continue
+		}
+		request.state = states[index]
+	}
+	tracker.refreshed = time.Now()
+	tracker.lock.Unlock()
+}
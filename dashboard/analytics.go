@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAnalyticsWindow = "1h"
+	// throughput intervals charted over the window
+	analyticsBuckets = 30
+)
+
+// parseWindow parse an analytics window, a duration or a number of days
+func parseWindow(window string) (time.Duration, error) {
+	if len(window) > 1 && window[len(window)-1] == 'd' {
+		days, err := strconv.Atoi(window[:len(window)-1])
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid window %s", window)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid window %s", window)
+	}
+	return duration, nil
+}
+
+// analyticsRange get the start and the end of the analytics in microseconds,
+// an explicit start takes precedence over the window which ends now or at end
+func analyticsRange(window string, start, end int) (int, int, error) {
+	if end <= 0 {
+		end = int(time.Now().UnixNano() / 1000)
+	}
+	if start > 0 {
+		if start >= end {
+			return 0, 0, fmt.Errorf("invalid range, start is not before end")
+		}
+		return start, end, nil
+	}
+	duration, err := parseWindow(window)
+	if err != nil {
+		return 0, 0, err
+	}
+	return end - int(duration/time.Microsecond), end, nil
+}
+
+// parseAnalyticsRange parse the start and the end of the analytics from the
+// page url
+func parseAnalyticsRange(values url.Values) (int, int, error) {
+	start, err := parseTimeParam(values.Get("start"))
+	if err != nil {
+		return 0, 0, &FunctionError{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	end, err := parseTimeParam(values.Get("end"))
+	if err != nil {
+		return 0, 0, &FunctionError{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	return start, end, nil
+}
+
+// getFlowAnalytics request to metrics function to aggregate the requests of a
+// flow over a window
+func getFlowAnalytics(ctx context.Context, flow, window string, start, end int) (*FlowAnalytics, error) {
+	if start <= 0 && window == "" {
+		window = defaultAnalyticsWindow
+	}
+	start, end, err := analyticsRange(window, start, end)
+	if err != nil {
+		return nil, &FunctionError{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	params := url.Values{}
+	params.Set("method", "analytics")
+	params.Set("function", flow)
+	params.Set("start", strconv.Itoa(start))
+	params.Set("end", strconv.Itoa(end))
+	params.Set("buckets", strconv.Itoa(analyticsBuckets))
+
+	c := http.Client{}
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"function/metrics?"+params.Encode(), nil)
+	request = request.WithContext(ctx)
+
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flow analytics, %v", err)
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flow analytics, %v", err)
+	}
+	if fErr := functionError("metrics", response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
+
+	analytics := &FlowAnalytics{}
+	if err := json.Unmarshal(bodyBytes, analytics); err != nil {
+		return nil, fmt.Errorf("failed to get flow analytics, %v", err)
+	}
+	analytics.Window = window
+	return analytics, nil
+}
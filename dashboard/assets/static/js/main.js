@@ -83,6 +83,144 @@ function loadTraceContent(flowName, reqId, traceId) {
     xmlHttp.send(data);
 };
 
+// Load the dag of a request colored by the execution of its nodes with its
+// critical path marked
+function loadRequestGraph(flowName, reqId, traceId) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/dot");
+
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["trace-id"] = traceId;
+    reqData["request-id"] = reqId;
+    reqData["critical"] = true;
+    let data = JSON.stringify(reqData);
+
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState == 4 && this.status == 200) {
+            updateGraph(this.responseText);
+        }
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+};
+
+// critical path of the monitored request, highlighted on the traces chart
+let criticalPath = null;
+// latest traces of the monitored request
+let lastTraceObject = null;
+// nodes whose foreach iterations or conditional branches are expanded
+let expandedNodes = {};
+
+// Load the critical path of a request and redraw the traces with it
+function loadCriticalPath(flowName, reqId, traceId) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/critical-path");
+
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["trace-id"] = traceId;
+    reqData["request-id"] = reqId;
+    let data = JSON.stringify(reqData);
+
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState == 4 && this.status == 200) {
+            criticalPath = JSON.parse(this.responseText);
+            updateCriticalPath(criticalPath);
+            if (lastTraceObject !== null) {
+                updateTraceContent(lastTraceObject);
+            }
+        }
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+};
+
+// Update the critical path summary of a request
+function updateCriticalPath(path) {
+    let element = document.getElementById("critical-path");
+    if (element === null) {
+        return;
+    }
+    let summary = "<b>Critical Path:</b> " + path["nodes"].join(" &rarr; ") +
+        " <small class='text-muted'>executing " + formatDuration(path["executing"]) +
+        ", waiting " + formatDuration(path["waiting"]) + "</small>";
+    let bottlenecks = path["bottlenecks"].slice(0, 3).map(function (node) {
+        return node["node"] + " (" + (node["share"] * 100).toFixed(1) + "%)";
+    });
+    if (bottlenecks.length > 0) {
+        summary = summary + "<br><b>Dominated by:</b> " + bottlenecks.join(", ");
+    }
+    element.innerHTML = summary;
+};
+
+// Stream the trace content changes of a request, returns the event source
+function streamTraceContent(flowName, reqId, traceId) {
+    if (typeof(EventSource) === "undefined") {
+        return null;
+    }
+
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/stream?flow-name=" + encodeURIComponent(flowName)
+        + "&request=" + encodeURIComponent(reqId) + "&trace-id=" + encodeURIComponent(traceId));
+
+    let traceObject = {"request-id": reqId, "traces": {}};
+    let pendingUpdate = null;
+
+    // redraw once per burst of events
+    let scheduleUpdate = function () {
+        if (pendingUpdate !== null) {
+            return;
+        }
+        pendingUpdate = setTimeout(function () {
+            pendingUpdate = null;
+            updateTraceContent(traceObject);
+        }, 100);
+    };
+
+    // the graph only changes when a node ends or the status changes
+    let pendingGraph = null;
+    let scheduleGraph = function () {
+        if (pendingGraph !== null) {
+            return;
+        }
+        pendingGraph = setTimeout(function () {
+            pendingGraph = null;
+            loadRequestGraph(flowName, reqId, traceId);
+            loadCriticalPath(flowName, reqId, traceId);
+        }, 1000);
+    };
+
+    let source = new EventSource(url);
+    let applyNodeEvent = function (e) {
+        let event = JSON.parse(e.data);
+        traceObject["traces"][event["node"]] = {
+            "start-time": event["start-time"],
+            "duration": event["duration"],
+        };
+        scheduleUpdate();
+    };
+    source.addEventListener("node-start", applyNodeEvent);
+    source.addEventListener("node-end", applyNodeEvent);
+    source.addEventListener("node-end", scheduleGraph);
+    source.addEventListener("status", function (e) {
+        let event = JSON.parse(e.data);
+        traceObject["status"] = event["status"];
+        traceObject["start-time"] = event["start-time"];
+        traceObject["duration"] = event["duration"];
+        scheduleUpdate();
+        scheduleGraph();
+    });
+    source.addEventListener("end", function () {
+        source.close();
+    });
+    return source;
+};
+
 // execute the flow function
 function executeFlow(flowName) {
     let url = getServer();
@@ -114,112 +252,168 @@ function executeFlow(flowName) {
     xmlHttp.send(data);
 };
 
-// stop the request
-function stopRequest(flowName, request) {
+// request the dashboard to perform an action on the request
+function controlRequest(flowName, request, action, doneText) {
     let url = getServer();
-    url = url.concat("/function/" + flowName + "?stop-flow=" + request);
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/" + action);
 
-    let html = document.getElementById("exec-status").innerHTML;
-    if (!(html.includes("RUNNING") || html.includes("PAUSED"))) {
-        triggerAlert("Can't stop request: <b>" + request + "</b>, must be in <b>ACTIVE</b> states", 'info');
-        return;
-    }
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["request-id"] = request;
+    let data = JSON.stringify(reqData);
 
     let xmlHttp = new XMLHttpRequest();
     xmlHttp.onreadystatechange = function () {
-        if (this.readyState == 4 && this.status != 200) {
-            triggerAlert("Failed to stop the request: <b>" + request + "</b>", 'danger');
+        if (this.readyState != 4) {
             return;
         }
-        if (this.readyState == 4 && this.status == 200) {
-            triggerAlert("Request: <b>" + request + "</b> has been stopped", 'success');
+        let result = {};
+        try {
+            result = JSON.parse(this.responseText);
+        } catch (e) {
+            result["error"] = this.responseText;
+        }
+        if (this.status == 409) {
+            triggerAlert("Can't " + action + " request: <b>" + request + "</b>, " + result["error"], 'info');
             return;
         }
+        if (this.status != 200) {
+            triggerAlert("Failed to " + action + " the request: <b>" + request + "</b>, " + result["error"], 'danger');
+            return;
+        }
+        triggerAlert("Request: <b>" + request + "</b> has been " + doneText, 'success');
     };
 
-    xmlHttp.open("GET", url, true);
+    xmlHttp.open("POST", url, true);
     xmlHttp.setRequestHeader('accept', "application/json");
     xmlHttp.setRequestHeader("Content-Type", "application/json");
-    xmlHttp.send();
+    xmlHttp.send(data);
+};
+
+// stop the request
+function stopRequest(flowName, request) {
+    controlRequest(flowName, request, "stop", "stopped");
 };
 
 // pause the request
 function pauseRequest(flowName, request) {
+    controlRequest(flowName, request, "pause", "paused");
+};
+
+// resume the request
+function resumeRequest(flowName, request) {
+    controlRequest(flowName, request, "resume", "resumed");
+};
+
+// delete the flow function
+function deleteFlow(flowName, force) {
+    $('#deleteModal').modal('hide');
+
     let url = getServer();
-    url = url.concat("/function/" + flowName + "?pause-flow=" + request);
+    url = url.concat("/function/faas-flow-dashboard/api/flow/delete");
 
-    let html = document.getElementById("exec-status").innerHTML;
-    if (!html.includes("RUNNING")) {
-        triggerAlert("Can't pause request: <b>" + request + "</b>, must be <b>RUNNING</b>", 'info');
-        return;
+    let reqData = {};
+    reqData["function"] = flowName;
+    if (force) {
+        reqData["force"] = true;
     }
+    let data = JSON.stringify(reqData);
 
     let xmlHttp = new XMLHttpRequest();
     xmlHttp.onreadystatechange = function () {
+        // other flows call the flow, ask before breaking them
+        if (this.readyState == 4 && this.status == 409) {
+            if (confirm(this.responseText + "\nDelete " + flowName + " anyway?")) {
+                deleteFlow(flowName, true);
+            }
+            return;
+        }
         if (this.readyState == 4 && this.status != 200) {
-            triggerAlert("Failed to pause the request: <b>" + request + "</b>", 'danger');
+            triggerAlert("Failed to delete flow: <b>" + flowName + "</b>", "danger");
             return;
         }
         if (this.readyState == 4 && this.status == 200) {
-            triggerAlert("Request: <b>" + request + "</b> has been paused!", 'success');
+            triggerAlert("Deleted flow: <b>" + flowName + "</b>", "success");
             return;
         }
     };
-
-    xmlHttp.open("GET", url, true);
+    xmlHttp.open("POST", url, true);
     xmlHttp.setRequestHeader('accept', "application/json");
     xmlHttp.setRequestHeader("Content-Type", "application/json");
-    xmlHttp.send();
+    xmlHttp.send(data);
 };
 
-// pause the request
-function resumeRequest(flowName, request) {
+// parse KEY=VALUE lines of a textarea into an object
+function parseKeyValues(id) {
+    let values = {};
+    document.getElementById(id).value.split("\n").forEach(function (line) {
+        let index = line.indexOf("=");
+        if (index > 0) {
+            values[line.substring(0, index).trim()] = line.substring(index + 1).trim();
+        }
+    });
+    return values;
+};
+
+// request the dashboard to deploy a flow
+function deployFlow() {
     let url = getServer();
-    url = url.concat("/function/" + flowName + "?resume-flow=" + request);
+    url = url.concat("/function/faas-flow-dashboard/api/flow/deploy");
 
-    let html = document.getElementById("exec-status").innerHTML;
-    if (!html.includes("PAUSED")) {
-        triggerAlert("Can't resume request: <b>" + request +"</b>, must be <b>PAUSED</b>", 'info');
-        return;
-    }
+    let flowName = document.getElementById("deploy.name").value.trim();
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["image"] = document.getElementById("deploy.image").value.trim();
+    reqData["description"] = document.getElementById("deploy.description").value.trim();
+    reqData["env"] = parseKeyValues("deploy.env");
+    reqData["labels"] = parseKeyValues("deploy.labels");
+    reqData["annotations"] = parseKeyValues("deploy.annotations");
+    reqData["secrets"] = document.getElementById("deploy.secrets").value.split(",")
+        .map(function (secret) { return secret.trim(); })
+        .filter(function (secret) { return secret != ""; });
+    let data = JSON.stringify(reqData);
+
+    $('#deployModal').modal('hide');
 
     let xmlHttp = new XMLHttpRequest();
     xmlHttp.onreadystatechange = function () {
         if (this.readyState == 4 && this.status != 200) {
-            triggerAlert("Failed to resume the request: <b>" + request + "</b>", 'danger');
+            triggerAlert("Failed to deploy flow: <b>" + flowName + "</b>, " + this.responseText, "danger");
             return;
         }
         if (this.readyState == 4 && this.status == 200) {
-            triggerAlert("Request: <b>" + request + "</b> has been resumed", 'success');
+            let result = JSON.parse(this.responseText);
+            triggerAlert("Deployed flow: <b>" + result["function"] + "</b> with image " + result["image"], "success");
             return;
         }
     };
-
-    xmlHttp.open("GET", url, true);
+    xmlHttp.open("POST", url, true);
     xmlHttp.setRequestHeader('accept', "application/json");
     xmlHttp.setRequestHeader("Content-Type", "application/json");
-    xmlHttp.send();
+    xmlHttp.send(data);
 };
 
-// delete the flow function
-function deleteFlow(flowName) {
-    $('#deleteModal').modal('hide');
+// request the dashboard to redeploy a flow with a new image tag
+function redeployFlow(flowName) {
+    $('#redeployModal').modal('hide');
 
     let url = getServer();
-    url = url.concat("/function/faas-flow-dashboard/api/flow/delete");
+    url = url.concat("/function/faas-flow-dashboard/api/flow/redeploy");
 
     let reqData = {};
     reqData["function"] = flowName;
+    reqData["tag"] = document.getElementById("redeploy.tag").value.trim();
     let data = JSON.stringify(reqData);
 
     let xmlHttp = new XMLHttpRequest();
     xmlHttp.onreadystatechange = function () {
         if (this.readyState == 4 && this.status != 200) {
-            triggerAlert("Failed to delete flow: <b>" + flowName + "</b>", "danger");
+            triggerAlert("Failed to redeploy flow: <b>" + flowName + "</b>, " + this.responseText, "danger");
             return;
         }
         if (this.readyState == 4 && this.status == 200) {
-            triggerAlert("Deleted flow: <b>" + flowName + "</b>", "success");
+            let result = JSON.parse(this.responseText);
+            triggerAlert("Redeployed flow: <b>" + flowName + "</b> with image " + result["image"], "success");
             return;
         }
     };
@@ -260,20 +454,62 @@ function drawBarChart(jsonObject) {
 
 
     dataTable.addColumn({ type: 'string', id: 'ID' });
+    dataTable.addColumn({ type: 'string', role: 'style' });
     dataTable.addColumn({ type: 'number', id: 'Start' });
     dataTable.addColumn({ type: 'number', id: 'End' });
     
     let rows = [];
 
+    // nodes on the critical path are highlighted, the time the request
+    // waits between them is shown on its own row
+    let critical = {};
+    if (criticalPath !== null && criticalPath["request-id"] == id) {
+        criticalPath["nodes"].forEach(function (node) { critical[node] = true; });
+    }
+
     let normalizer = 1000;
-    let requestdata = [id, (rstime/normalizer), ((rstime+rduration)/normalizer)];
+    let requestdata = [id, '#5a5c69', (rstime/normalizer), ((rstime+rduration)/normalizer)];
     rows.push(requestdata);
+    // the node of each row, rows of nodes executed more than once expand
+    // to one row per instance
+    let rowNodes = [null];
 
     for (let node in traces) {
         let value = traces[node];
         let nstime = value["start-time"];
         let nduration = value["duration"];
-        rows.push([node, nstime/normalizer, ((nstime+nduration)/normalizer)]);
+        let color = critical[node] ? '#4e73df' : '#d1d3e2';
+        let instances = value["instances"] || [];
+        if (instances.length <= 1) {
+            rows.push([node, color, nstime/normalizer, ((nstime+nduration)/normalizer)]);
+            rowNodes.push(null);
+            continue;
+        }
+        let marker = expandedNodes[node] ? "\u25be " : "\u25b8 ";
+        rows.push([marker + node + " (" + instances.length + " instances)", color,
+            nstime/normalizer, ((nstime+nduration)/normalizer)]);
+        rowNodes.push(node);
+        if (!expandedNodes[node]) {
+            continue;
+        }
+        // the slowest instances are listed first
+        instances.slice().sort(function (a, b) {
+            return b["duration"] - a["duration"];
+        }).forEach(function (instance) {
+            let istime = instance["start-time"];
+            let icolor = instance["status"] == "FAILED" ? '#e74a3b' : '#858796';
+            rows.push(["\u2003" + node + " [" + instance["key"] + "]", icolor,
+                istime/normalizer, (istime+instance["duration"])/normalizer]);
+            rowNodes.push(null);
+        });
+    }
+    if (criticalPath !== null && criticalPath["request-id"] == id) {
+        criticalPath["segments"].forEach(function (segment) {
+            if (segment["waiting"]) {
+                let sstime = segment["start-time"];
+                rows.push(["waiting", '#f6c23e', sstime/normalizer, (sstime+segment["duration"])/normalizer]);
+            }
+        });
     }
     dataTable.addRows(rows)
 
@@ -287,11 +523,24 @@ function drawBarChart(jsonObject) {
 	        maxValue: ((rstime+rduration)/normalizer),
 	    },
     };
+    google.visualization.events.addListener(chart, 'select', function () {
+        let selection = chart.getSelection();
+        if (selection.length == 0 || selection[0].row == null) {
+            return;
+        }
+        let node = rowNodes[selection[0].row];
+        if (node === null || node === undefined) {
+            return;
+        }
+        expandedNodes[node] = !expandedNodes[node];
+        drawBarChart(lastTraceObject);
+    });
     chart.draw(dataTable, options);
 };
 
 // Update the content of content wrapper for request desc
 function updateTraceContent(jsonObject) {
+    lastTraceObject = jsonObject;
 
     let duration = jsonObject["duration"];
     let status = jsonObject["status"];
@@ -313,8 +562,380 @@ function updateTraceContent(jsonObject) {
 
     document.getElementById("exec-duration").innerHTML = "<b>Duration:</b> " + formatDuration(duration);
     document.getElementById("exec-status").innerHTML = "<b>Status:</b> " + status;
+    if (jsonObject["error"]) {
+        let failure = document.createElement("span");
+        failure.className = "text-danger ml-2";
+        failure.textContent = jsonObject["error"];
+        document.getElementById("exec-status").appendChild(failure);
+    }
     document.getElementById("start-time").innerHTML = "<b>Start Time:</b> " + formatTime(start_time);
+
+    // interleave the node timeline with the logs
+    renderRequestLogs();
+};
+
+
+
+
+// latest logs of the monitored request
+let requestLogs = null;
+
+// Load the lines the flow logged for a request
+function loadRequestLogs(flowName, reqId) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/logs");
+
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["request-id"] = reqId;
+    let data = JSON.stringify(reqData);
+
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState == 4 && this.status == 200) {
+            requestLogs = JSON.parse(this.responseText);
+            renderRequestLogs();
+        }
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+    return false;
+};
+
+// Render the logs of the request filtered by the search, the start and the
+// end of the nodes are interleaved when the traces are loaded
+function renderRequestLogs() {
+    let table = document.getElementById("logs.lines");
+    if (table === null || requestLogs === null) {
+        return;
+    }
+    let search = document.getElementById("logs.search").value.toLowerCase();
+    let showNodes = document.getElementById("logs.nodes").checked;
+
+    let entries = requestLogs["lines"].filter(function (line) {
+        return search == "" || line["message"].toLowerCase().indexOf(search) >= 0;
+    }).map(function (line) {
+        return {"time": line["time"], "source": "log", "message": line["message"]};
+    });
+    if (showNodes && lastTraceObject !== null) {
+        let traces = lastTraceObject["traces"] || {};
+        for (let node in traces) {
+            if (search != "" && node.toLowerCase().indexOf(search) < 0) {
+                continue;
+            }
+            let trace = traces[node];
+            entries.push({"time": trace["start-time"], "source": node, "message": "node started"});
+            if (trace["duration"] > 0) {
+                entries.push({
+                    "time": trace["start-time"] + trace["duration"], "source": node,
+                    "message": "node finished after " + formatDuration(trace["duration"])
+                });
+            }
+            (trace["instances"] || []).forEach(function (instance) {
+                if (instance["error"]) {
+                    entries.push({
+                        "time": instance["start-time"] + instance["duration"], "source": node,
+                        "message": instance["key"] + " failed, " + instance["error"], "failed": true
+                    });
+                }
+            });
+        }
+    }
+    entries.sort(function (a, b) {
+        return a["time"] - b["time"];
+    });
+
+    let start = null;
+    if (lastTraceObject !== null && lastTraceObject["start-time"] > 0) {
+        start = lastTraceObject["start-time"];
+    } else if (requestLogs["lines"].length > 0) {
+        start = requestLogs["lines"][0]["time"];
+    }
+
+    table.innerHTML = "";
+    entries.forEach(function (entry) {
+        let row = table.insertRow();
+        let offset = start === null ? 0 : entry["time"] - start;
+        row.insertCell().textContent = "+" + formatDuration(offset);
+        let source = document.createElement("span");
+        source.className = entry["source"] == "log" ? "badge badge-secondary" : "badge badge-info";
+        source.textContent = entry["source"];
+        row.insertCell().appendChild(source);
+        let message = row.insertCell();
+        message.textContent = entry["message"];
+        message.style.whiteSpace = "pre-wrap";
+        if (entry["failed"]) {
+            message.className = "text-danger";
+        } else if (entry["source"] != "log") {
+            message.className = "text-muted";
+        }
+    });
+    if (entries.length == 0) {
+        let cell = table.insertRow().insertCell();
+        cell.colSpan = 3;
+        cell.textContent = requestLogs["total"] > 0 ? "No line matches the search" : "No log received for the request";
+    }
+
+    let info = requestLogs["total"] + " lines";
+    if (requestLogs["dropped"] > 0) {
+        info = info + ", " + requestLogs["dropped"] + " oldest lines dropped";
+    }
+    document.getElementById("logs.info").textContent = info;
+};
+
+// format a size in bytes
+function formatSize(bytes) {
+    if (bytes < 1024) {
+        return bytes + " B";
+    }
+    if (bytes < 1024 * 1024) {
+        return (bytes / 1024).toFixed(1) + " KB";
+    }
+    return (bytes / (1024 * 1024)).toFixed(1) + " MB";
 };
 
+// Load the values a request stored in the datastore grouped by node
+function loadRequestData(flowName, reqId) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/data");
 
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["request-id"] = reqId;
+    let data = JSON.stringify(reqData);
 
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState != 4) {
+            return;
+        }
+        let table = document.getElementById("request-data");
+        table.innerHTML = "";
+        if (this.status != 200) {
+            let cell = table.insertRow().insertCell();
+            cell.colSpan = 5;
+            cell.textContent = "Failed to load the data, " + this.responseText;
+            return;
+        }
+        let result = JSON.parse(this.responseText);
+        result["nodes"].forEach(function (node) {
+            node["items"].forEach(function (item, index) {
+                let row = table.insertRow();
+                let nodeCell = row.insertCell();
+                if (index == 0) {
+                    let name = document.createElement("strong");
+                    name.textContent = node["node"];
+                    nodeCell.appendChild(name);
+                }
+                row.insertCell().textContent = item["option"] || "";
+                row.insertCell().textContent = item["target"] || item["key"];
+                row.insertCell().textContent = formatSize(item["size"]);
+                let preview = document.createElement("a");
+                preview.href = "#";
+                preview.className = "btn btn-sm btn-info";
+                preview.textContent = "Preview";
+                preview.onclick = function () {
+                    return previewRequestData(flowName, reqId, item["key"]);
+                };
+                row.insertCell().appendChild(preview);
+            });
+        });
+        if (result["nodes"].length == 0) {
+            let cell = table.insertRow().insertCell();
+            cell.colSpan = 5;
+            cell.textContent = "No data stored for the request";
+        }
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+    return false;
+};
+
+// Show the preview of a value a request stored in the datastore
+function previewRequestData(flowName, reqId, key) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/data");
+
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["request-id"] = reqId;
+    reqData["key"] = key;
+    let data = JSON.stringify(reqData);
+
+    let download = getServer().concat("/function/faas-flow-dashboard/api/flow/request/data/download?function=" +
+        encodeURIComponent(flowName) + "&request-id=" + encodeURIComponent(reqId) + "&key=" + encodeURIComponent(key));
+
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState == 4 && this.status != 200) {
+            triggerAlert("Failed to load data: <b>" + key + "</b>, " + this.responseText, "danger");
+            return;
+        }
+        if (this.readyState == 4 && this.status == 200) {
+            let preview = JSON.parse(this.responseText);
+            document.getElementById("dataModalLabel").textContent = preview["key"];
+            let info = formatSize(preview["size"]) + ", " + preview["format"];
+            if (preview["truncated"]) {
+                info = info + ", truncated, download to see the complete value";
+            }
+            document.getElementById("data.info").textContent = info;
+            document.getElementById("data.preview").textContent = preview["preview"];
+            document.getElementById("data.download").href = download;
+            $('#dataModal').modal('show');
+        }
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+    return false;
+};
+
+// fill a table with rows of cells, or a single row when there is none
+function fillTable(id, rows, columns, empty) {
+    let table = document.getElementById(id);
+    table.innerHTML = "";
+    rows.forEach(function (cells) {
+        let row = table.insertRow();
+        cells.forEach(function (cell) {
+            row.insertCell().textContent = cell;
+        });
+    });
+    if (rows.length == 0) {
+        let cell = table.insertRow().insertCell();
+        cell.colSpan = columns;
+        cell.textContent = empty;
+    }
+};
+
+// Load the execution state a request keeps in the statestore
+function loadRequestState(flowName, reqId) {
+    let url = getServer();
+    url = url.concat("/function/faas-flow-dashboard/api/flow/request/state");
+
+    let reqData = {};
+    reqData["function"] = flowName;
+    reqData["request-id"] = reqId;
+    let data = JSON.stringify(reqData);
+
+    let xmlHttp = new XMLHttpRequest();
+    xmlHttp.onreadystatechange = function () {
+        if (this.readyState != 4) {
+            return;
+        }
+        let diagnosis = document.getElementById("state.diagnosis");
+        diagnosis.innerHTML = "";
+        if (this.status != 200) {
+            let item = document.createElement("li");
+            item.className = "text-danger";
+            item.textContent = "Failed to load the state, " + this.responseText;
+            diagnosis.appendChild(item);
+            return;
+        }
+        let state = JSON.parse(this.responseText);
+        state["diagnosis"].forEach(function (line, index) {
+            let item = document.createElement("li");
+            item.className = index == 0 ? "font-weight-bold" : "text-warning";
+            item.textContent = line;
+            diagnosis.appendChild(item);
+        });
+
+        fillTable("state.branches", state["branches"].map(function (branch) {
+            return [branch["node"], branch["kind"], branch["option"] || "",
+                branch["completed"] + " / " + branch["options"].length, branch["options"].join(", ")];
+        }), 5, "No dynamic node is executing");
+        fillTable("state.inputs", state["inputs"].map(function (input) {
+            let received = "" + input["received"];
+            if (input["in-degree"]) {
+                received = received + " / " + input["in-degree"];
+            }
+            return [input["node"], input["option"] || "", received];
+        }), 3, "No node waits for its inputs");
+        fillTable("state.partials", state["partial-states"].map(function (partial) {
+            let options = [];
+            for (let node in (partial["options"] || {})) {
+                options.push(node + ": " + partial["options"][node]);
+            }
+            return [partial["position"].join(" / "), options.join(", "), formatSize(partial["data-size"])];
+        }), 3, "No partial request is stored");
+        fillTable("state.keys", state["keys"].map(function (entry) {
+            return [entry["key"], entry["value"]];
+        }), 2, "No key is stored");
+    };
+    xmlHttp.open("POST", url, true);
+    xmlHttp.setRequestHeader("Content-Type", "application/json");
+    xmlHttp.send(data);
+    return false;
+};
+
+// draw the throughput and the slowest nodes charts of the flow analytics
+function drawAnalytics(analytics) {
+    let rate = (analytics["failure-rate"] * 100).toFixed(1);
+    document.getElementById("analytics.failure-rate").textContent = rate + "%";
+
+    let latency = analytics["latency"];
+    document.getElementById("analytics.latency").textContent = formatDuration(latency["p50"]) +
+        " / " + formatDuration(latency["p95"]) + " / " + formatDuration(latency["p99"]);
+
+    let buckets = analytics["throughput"] || [];
+    new Chart(document.getElementById("analytics.throughput"), {
+        type: 'line',
+        data: {
+            labels: buckets.map(function(bucket) { return formatTime(bucket["time"]); }),
+            datasets: [{
+                label: "Requests",
+                data: buckets.map(function(bucket) { return bucket["requests"]; }),
+                borderColor: "#4e73df",
+                backgroundColor: "rgba(78, 115, 223, 0.05)",
+                lineTension: 0.3,
+            }, {
+                label: "Failed",
+                data: buckets.map(function(bucket) { return bucket["failed"]; }),
+                borderColor: "#e74a3b",
+                backgroundColor: "rgba(231, 74, 59, 0.05)",
+                lineTension: 0.3,
+            }],
+        },
+        options: {
+            scales: {
+                yAxes: [{ ticks: { beginAtZero: true, precision: 0 } }],
+            },
+        },
+    });
+
+    // the ten slowest nodes are charted, all are listed
+    let nodes = analytics["slowest-nodes"] || [];
+    let charted = nodes.slice(0, 10);
+    new Chart(document.getElementById("analytics.nodes"), {
+        type: 'horizontalBar',
+        data: {
+            labels: charted.map(function(node) { return node["node"]; }),
+            datasets: [{
+                label: "Average duration (s)",
+                data: charted.map(function(node) { return node["average"] / 1000000; }),
+                backgroundColor: "#36b9cc",
+            }],
+        },
+        options: {
+            legend: { display: false },
+            scales: {
+                xAxes: [{ ticks: { beginAtZero: true } }],
+            },
+        },
+    });
+
+    let table = document.getElementById("analytics.nodes.table");
+    nodes.forEach(function(node) {
+        let row = table.insertRow();
+        row.insertCell().textContent = node["node"];
+        row.insertCell().textContent = node["count"];
+        row.insertCell().textContent = formatDuration(node["average"]);
+        row.insertCell().textContent = formatDuration(node["max"]);
+    });
+    if (nodes.length == 0) {
+        let cell = table.insertRow().insertCell();
+        cell.colSpan = 4;
+        cell.textContent = "No request in the window";
+    }
+};
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum audit entries kept in memory
+	auditMemoryLimit = 10000
+)
+
+// AuditEntry records an operation performed through the dashboard
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	User          string    `json:"user"`
+	Action        string    `json:"action"`
+	Flow          string    `json:"flow"`
+	RequestID     string    `json:"request-id,omitempty"`
+	PreviousState string    `json:"previous-state,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// AuditQuery filters the audit entries, empty fields match everything
+type AuditQuery struct {
+	User      string
+	Action    string
+	Flow      string
+	RequestID string
+	Since     time.Time
+	Limit     int
+}
+
+// auditLog keeps the audit entries in memory and appends them to a file
+type auditLog struct {
+	lock    sync.Mutex
+	path    string
+	entries []*AuditEntry
+}
+
+var (
+	audit = &auditLog{}
+)
+
+// initAuditLog load the existing audit entries from the audit file
+func initAuditLog(path string) error {
+	audit.path = path
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open audit log, %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := &AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			log.Printf("skipping invalid audit entry, error: %v", err)
+			continue
+		}
+		audit.entries = append(audit.entries, entry)
+	}
+	if len(audit.entries) > auditMemoryLimit {
+		audit.entries = audit.entries[len(audit.entries)-auditMemoryLimit:]
+	}
+	return scanner.Err()
+}
+
+// record add an entry to the audit log
+func (al *auditLog) record(entry *AuditEntry) {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	al.entries = append(al.entries, entry)
+	if len(al.entries) > auditMemoryLimit {
+		al.entries = al.entries[len(al.entries)-auditMemoryLimit:]
+	}
+
+	log.Printf("audit: user %s %s flow %s request %s, success: %v",
+		entry.User, entry.Action, entry.Flow, entry.RequestID, entry.Success)
+
+	if al.path == "" {
+		return
+	}
+	file, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("failed to write audit log, error: %v", err)
+		return
+	}
+	defer file.Close()
+	data, _ := json.Marshal(entry)
+	file.Write(append(data, '\n'))
+}
+
+// query return the matching entries, latest first
+func (al *auditLog) query(query *AuditQuery) []*AuditEntry {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	result := make([]*AuditEntry, 0)
+	for i := len(al.entries) - 1; i >= 0; i-- {
+		entry := al.entries[i]
+		switch {
+		case query.User != "" && query.User != entry.User:
+			continue
+		case query.Action != "" && query.Action != entry.Action:
+			continue
+		case query.Flow != "" && query.Flow != entry.Flow:
+			continue
+		case query.RequestID != "" && query.RequestID != entry.RequestID:
+			continue
+		case !query.Since.IsZero() && entry.Time.Before(query.Since):
+			continue
+		}
+		result = append(result, entry)
+		if query.Limit > 0 && len(result) >= query.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// auditHandler handle api request to query the audit log
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+
+	values := r.URL.Query()
+	query := &AuditQuery{
+		User:      values.Get("user"),
+		Action:    values.Get("action"),
+		Flow:      values.Get("flow-name"),
+		RequestID: values.Get("request"),
+		Limit:     100,
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid limit "+limit, http.StatusBadRequest)
+			return
+		}
+		query.Limit = parsed
+	}
+
+	if since := values.Get("since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "invalid since, must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		query.Since = parsed
+	}
+
+	data, _ := json.MarshalIndent(audit.query(query), "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
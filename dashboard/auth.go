@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+
+	authNone  = "none"
+	authBasic = "basic"
+	authOidc  = "oidc"
+
+	sessionCookie = "faas-flow-session"
+	loginCookie   = "faas-flow-login"
+)
+
+// roleRanks the roles ordered by their permissions, a role is granted every
+// permission of the roles below it
+var roleRanks = map[string]int{
+	roleViewer:   1,
+	roleOperator: 2,
+	roleAdmin:    3,
+}
+
+// User an authenticated dashboard user
+type User struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// authConfig the dashboard authentication settings
+type authConfig struct {
+	mode        string
+	roles       map[string]string
+	defaultRole string
+	sessionKey  []byte
+	sessionTTL  time.Duration
+
+	// basic auth credentials, the gateway admin
+	basicUser     string
+	basicPassword string
+
+	oidc *oidcClient
+}
+
+// session a signed login state stored in a cookie
+type session struct {
+	User   string `json:"user"`
+	Role   string `json:"role,omitempty"`
+	Expiry int64  `json:"exp"`
+
+	// pending login
+	State string `json:"state,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+type userContextKey struct{}
+
+var (
+	authConf = &authConfig{mode: authNone, roles: map[string]string{}}
+)
+
+// Can check if the user has the permissions of a role
+func (user *User) Can(role string) bool {
+	return user != nil && roleRanks[user.Role] >= roleRanks[role]
+}
+
+// CanOperate check if the user can control requests
+func (user *User) CanOperate() bool {
+	return user.Can(roleOperator)
+}
+
+// CanAdmin check if the user can manage flows
+func (user *User) CanAdmin() bool {
+	return user.Can(roleAdmin)
+}
+
+// parseRoles parse a comma separated list of user=role
+func parseRoles(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || roleRanks[strings.TrimSpace(parts[1])] == 0 {
+			return nil, fmt.Errorf("invalid role %s, expected user=viewer|operator|admin", entry)
+		}
+		roles[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return roles, nil
+}
+
+// randomString generate a random url safe string
+func randomString(size int) string {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		log.Fatalf("failed to generate random data, %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// initAuth initialize the authentication from the environment
+func initAuth() error {
+	authConf.mode = os.Getenv("auth_mode")
+	if authConf.mode == "" {
+		authConf.mode = authBasic
+	}
+
+	roles, err := parseRoles(os.Getenv("auth_roles"))
+	if err != nil {
+		return err
+	}
+	authConf.roles = roles
+
+	authConf.defaultRole = os.Getenv("auth_default_role")
+	if authConf.defaultRole == "" {
+		authConf.defaultRole = roleViewer
+	}
+	if roleRanks[authConf.defaultRole] == 0 {
+		return fmt.Errorf("invalid default role %s", authConf.defaultRole)
+	}
+
+	authConf.sessionTTL = parseIntOrDurationValue(os.Getenv("session_ttl"), 12*time.Hour)
+	if key := os.Getenv("session_key"); key != "" {
+		authConf.sessionKey = []byte(key)
+	} else {
+		// sessions do not survive a restart without a configured key
+		authConf.sessionKey = []byte(randomString(32))
+	}
+
+	switch authConf.mode {
+	case authNone:
+		log.Printf("authentication disabled, every user is admin")
+
+	case authBasic:
+		authConf.basicUser, err = sdk.ReadSecret("basic-auth-user")
+		if err != nil {
+			return fmt.Errorf("failed to read basic auth user, %v", err)
+		}
+		authConf.basicPassword, err = sdk.ReadSecret("basic-auth-password")
+		if err != nil {
+			return fmt.Errorf("failed to read basic auth password, %v", err)
+		}
+		authConf.basicUser = strings.TrimSpace(authConf.basicUser)
+		authConf.basicPassword = strings.TrimSpace(authConf.basicPassword)
+
+	case authOidc:
+		if users := os.Getenv("oidc_mock_users"); users != "" {
+			if err := initMockIdp(users, os.Getenv("oidc_redirect_url")); err != nil {
+				return err
+			}
+		}
+		authConf.oidc, err = newOidcClient()
+		if err != nil {
+			return fmt.Errorf("failed to initialize oidc, %v", err)
+		}
+
+	default:
+		return fmt.Errorf("invalid auth mode %s, expected none, basic or oidc", authConf.mode)
+	}
+	return nil
+}
+
+// userRole get the role of a user, configured roles take precedence over the
+// role given by the identity provider
+func userRole(name, role string) string {
+	if configured, found := authConf.roles[name]; found {
+		return configured
+	}
+	if roleRanks[role] > 0 {
+		return role
+	}
+	return authConf.defaultRole
+}
+
+// signSession encode and sign a session
+func signSession(value *session) string {
+	data, _ := json.Marshal(value)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, authConf.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySession decode a signed session, an invalid or expired session is nil
+func verifySession(value string) *session {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return nil
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, authConf.sessionKey)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil
+	}
+	decoded := &session{}
+	if json.Unmarshal(data, decoded) != nil || time.Now().Unix() > decoded.Expiry {
+		return nil
+	}
+	return decoded
+}
+
+// setCookie set a dashboard cookie, a zero ttl removes it
+func setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+	}
+	if ttl > 0 {
+		cookie.Expires = time.Now().Add(ttl)
+	} else {
+		cookie.MaxAge = -1
+	}
+	// cross site requests must not carry the session
+	w.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Lax")
+}
+
+// authenticate get the user of a request, nil when the request is not
+// authenticated
+func authenticate(r *http.Request) *User {
+	switch authConf.mode {
+	case authNone:
+		return &User{Name: clientAddress(r), Role: roleAdmin}
+
+	case authBasic:
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return nil
+		}
+		if subtle.ConstantTimeCompare([]byte(user), []byte(authConf.basicUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(authConf.basicPassword)) != 1 {
+			return nil
+		}
+		// the gateway admin manages the flows unless configured otherwise
+		return &User{Name: user, Role: userRole(user, roleAdmin)}
+
+	case authOidc:
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			return nil
+		}
+		value := verifySession(cookie.Value)
+		if value == nil || value.User == "" {
+			return nil
+		}
+		return &User{Name: value.User, Role: value.Role}
+	}
+	return nil
+}
+
+// currentUser get the authenticated user of a request
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey{}).(*User)
+	return user
+}
+
+// isApiRequest check if a request is an api call rather than a page view
+func isApiRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// challenge ask the client to authenticate
+func challenge(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case authConf.mode == authBasic:
+		w.Header().Set("WWW-Authenticate", `Basic realm="faas-flow-dashboard"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	case isApiRequest(r) || r.Method != http.MethodGet:
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	default:
+		next := publicUri + r.URL.RequestURI()
+		http.Redirect(w, r, publicUri+"/auth/login?next="+url.QueryEscape(next), http.StatusFound)
+	}
+}
+
+// requestHost get the host requested by the client, as forwarded by the gateway
+func requestHost(r *http.Request) string {
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// isCrossSite check if a request is sent from another site, by the fetch site
+// or the origin the browsers send along the requests other than GET
+func isCrossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return true
+	}
+	return !strings.EqualFold(parsed.Host, requestHost(r))
+}
+
+// hasContentType check the media type of the body of a request
+func hasContentType(r *http.Request, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == contentType
+}
+
+// authorize wrap a handler to require an authenticated user with the
+// permissions of a role, the requests other than GET must post json
+func authorize(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return authorizeContent(role, jsonType, handler)
+}
+
+// authorizeContent wrap a handler to require an authenticated user with the
+// permissions of a role. The requests other than GET must be sent by the
+// dashboard with a body of the content type, a form can't be posted with
+// such a type from another site without the consent of the dashboard
+func authorizeContent(role, contentType string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if isCrossSite(r) {
+				log.Printf("denied cross-site %s request to %s from %s", r.Method, r.URL.Path, r.Header.Get("Origin"))
+				http.Error(w, "forbidden, cross-site request", http.StatusForbidden)
+				return
+			}
+			if !hasContentType(r, contentType) {
+				http.Error(w, fmt.Sprintf("invalid request, content type must be %s", contentType),
+					http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		user := authenticate(r)
+		if user == nil {
+			challenge(w, r)
+			return
+		}
+		if !user.Can(role) {
+			log.Printf("user %s with role %s denied access to %s", user.Name, user.Role, r.URL.Path)
+			http.Error(w, fmt.Sprintf("forbidden, %s role required", role), http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// safeNext get the page to return to after a login, only dashboard pages are
+// allowed
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, publicUri+"/") || strings.HasPrefix(next, "//") {
+		return publicUri + "/"
+	}
+	return next
+}
+
+// redirectURL get the oidc callback url of the dashboard
+func redirectURL(r *http.Request) string {
+	if authConf.oidc.redirectURL != "" {
+		return authConf.oidc.redirectURL
+	}
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + requestHost(r) + publicUri + "/auth/callback"
+}
+
+// loginHandler start an oidc login
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if authConf.mode != authOidc {
+		http.Redirect(w, r, safeNext(r.URL.Query().Get("next")), http.StatusFound)
+		return
+	}
+
+	authURL, err := authConf.oidc.authCodeURL()
+	if err != nil {
+		log.Printf("failed to start login, error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to start login, %v", err), http.StatusBadGateway)
+		return
+	}
+
+	pending := &session{
+		State:  randomString(16),
+		Nonce:  randomString(16),
+		Next:   safeNext(r.URL.Query().Get("next")),
+		Expiry: time.Now().Add(10 * time.Minute).Unix(),
+	}
+	setCookie(w, loginCookie, signSession(pending), 10*time.Minute)
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", authConf.oidc.clientID)
+	params.Set("redirect_uri", redirectURL(r))
+	params.Set("scope", authConf.oidc.scopes)
+	params.Set("state", pending.State)
+	params.Set("nonce", pending.Nonce)
+	separator := "?"
+	if strings.Contains(authURL, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, authURL+separator+params.Encode(), http.StatusFound)
+}
+
+// callbackHandler complete an oidc login
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if authConf.mode != authOidc {
+		http.Error(w, "oidc login is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var pending *session
+	if cookie, err := r.Cookie(loginCookie); err == nil {
+		pending = verifySession(cookie.Value)
+	}
+	query := r.URL.Query()
+	if pending == nil || query.Get("state") == "" || query.Get("state") != pending.State {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	setCookie(w, loginCookie, "", 0)
+
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, fmt.Sprintf("login failed, %s", reason), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := authConf.oidc.exchange(r.Context(), query.Get("code"), redirectURL(r), pending.Nonce)
+	if err != nil {
+		log.Printf("failed to complete login, error: %v", err)
+		http.Error(w, fmt.Sprintf("login failed, %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	name := claims.userName()
+	value := &session{
+		User:   name,
+		Role:   userRole(name, claims.role(authConf.oidc.roleClaim)),
+		Expiry: time.Now().Add(authConf.sessionTTL).Unix(),
+	}
+	log.Printf("user %s logged in with role %s", value.User, value.Role)
+	setCookie(w, sessionCookie, signSession(value), authConf.sessionTTL)
+	http.Redirect(w, r, pending.Next, http.StatusFound)
+}
+
+// logoutHandler end the session of a user
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if authConf.mode == authBasic {
+		// browsers forget the basic auth credentials on a new challenge
+		challenge(w, r)
+		return
+	}
+	setCookie(w, sessionCookie, "", 0)
+	http.Redirect(w, r, publicUri+"/", http.StatusFound)
+}
+
+// userHandler handle api request to get the current user
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	data, _ := json.MarshalIndent(currentUser(r), "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
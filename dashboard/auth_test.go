@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAuthConfig run a test with an authentication config, the globals are
+// restored afterwards
+func withAuthConfig(t *testing.T, conf *authConfig, test func()) {
+	saved, savedUri := authConf, publicUri
+	defer func() {
+		authConf, publicUri = saved, savedUri
+	}()
+	authConf, publicUri = conf, "/function/faas-flow-dashboard"
+	test()
+}
+
+func TestUserCan(t *testing.T) {
+	tests := []struct {
+		role    string
+		viewer  bool
+		operate bool
+		admin   bool
+	}{
+		{roleViewer, true, false, false},
+		{roleOperator, true, true, false},
+		{roleAdmin, true, true, true},
+		{"unknown", false, false, false},
+	}
+	for _, test := range tests {
+		user := &User{Name: "user", Role: test.role}
+		if user.Can(roleViewer) != test.viewer || user.CanOperate() != test.operate || user.CanAdmin() != test.admin {
+			t.Errorf("%s: got %v %v %v, want %v %v %v", test.role, user.Can(roleViewer), user.CanOperate(),
+				user.CanAdmin(), test.viewer, test.operate, test.admin)
+		}
+	}
+
+	var user *User
+	if user.Can(roleViewer) {
+		t.Errorf("a missing user must not have a role")
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	conf := &authConfig{
+		mode:        authOidc,
+		roles:       map[string]string{"alice": roleAdmin, "bob": roleViewer},
+		defaultRole: roleViewer,
+	}
+	withAuthConfig(t, conf, func() {
+		tests := []struct {
+			name     string
+			role     string
+			expected string
+		}{
+			{"alice", roleViewer, roleAdmin},
+			{"bob", roleAdmin, roleViewer},
+			{"carol", roleOperator, roleOperator},
+			{"dave", "unknown", roleViewer},
+		}
+		for _, test := range tests {
+			if role := userRole(test.name, test.role); role != test.expected {
+				t.Errorf("%s: got role %s, want %s", test.name, role, test.expected)
+			}
+		}
+	})
+}
+
+func TestSafeNext(t *testing.T) {
+	withAuthConfig(t, &authConfig{mode: authOidc}, func() {
+		tests := []struct {
+			next     string
+			expected string
+		}{
+			{"/function/faas-flow-dashboard/flow/info?flow-name=flow", "/function/faas-flow-dashboard/flow/info?flow-name=flow"},
+			{"/function/faas-flow-dashboard/", "/function/faas-flow-dashboard/"},
+			{"", "/function/faas-flow-dashboard/"},
+			{"/function/faas-flow-dashboard", "/function/faas-flow-dashboard/"},
+			{"/function/other/", "/function/faas-flow-dashboard/"},
+			{"http://attacker/function/faas-flow-dashboard/", "/function/faas-flow-dashboard/"},
+			{"//attacker/function/faas-flow-dashboard/", "/function/faas-flow-dashboard/"},
+		}
+		for _, test := range tests {
+			if next := safeNext(test.next); next != test.expected {
+				t.Errorf("%q: got %s, want %s", test.next, next, test.expected)
+			}
+		}
+	})
+
+	withAuthConfig(t, &authConfig{mode: authOidc}, func() {
+		// the dashboard is served at the root of a site
+		publicUri = ""
+		if next := safeNext("//attacker/"); next != "/" {
+			t.Errorf("got %s, want /", next)
+		}
+		if next := safeNext("/flow/info"); next != "/flow/info" {
+			t.Errorf("got %s, want /flow/info", next)
+		}
+	})
+}
+
+func TestIsCrossSite(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		crossSite bool
+	}{
+		{"no header", nil, false},
+		{"same origin", map[string]string{"Sec-Fetch-Site": "same-origin"}, false},
+		{"typed url", map[string]string{"Sec-Fetch-Site": "none"}, false},
+		{"same site", map[string]string{"Sec-Fetch-Site": "same-site"}, true},
+		{"cross site", map[string]string{"Sec-Fetch-Site": "cross-site"}, true},
+		{"origin", map[string]string{"Origin": "http://dashboard"}, false},
+		{"origin case", map[string]string{"Origin": "http://DASHBOARD"}, false},
+		{"forwarded host", map[string]string{"Origin": "https://gateway", "X-Forwarded-Host": "gateway"}, false},
+		{"other origin", map[string]string{"Origin": "http://attacker"}, true},
+		{"null origin", map[string]string{"Origin": "null"}, true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://dashboard/api/flow/delete", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		if crossSite := isCrossSite(r); crossSite != test.crossSite {
+			t.Errorf("%s: got %v, want %v", test.name, crossSite, test.crossSite)
+		}
+	}
+}
+
+func TestHasContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"text/plain", false},
+		{"application/x-www-form-urlencoded", false},
+		{"", false},
+		{"application/json;;", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/flow/delete", nil)
+		r.Header.Set("Content-Type", test.contentType)
+		if found := hasContentType(r, jsonType); found != test.expected {
+			t.Errorf("%q: got %v, want %v", test.contentType, found, test.expected)
+		}
+	}
+}
+
+func TestAuthorizeContent(t *testing.T) {
+	conf := &authConfig{mode: authOidc, sessionKey: []byte("key"), roles: map[string]string{}}
+	withAuthConfig(t, conf, func() {
+		sessionOf := func(role string) string {
+			return signSession(&session{User: "alice", Role: role, Expiry: time.Now().Add(time.Hour).Unix()})
+		}
+		expired := signSession(&session{User: "alice", Role: roleAdmin, Expiry: time.Now().Add(-time.Hour).Unix()})
+
+		tests := []struct {
+			name        string
+			method      string
+			path        string
+			role        string
+			session     string
+			contentType string
+			origin      string
+			status      int
+		}{
+			{"viewer page", http.MethodGet, "/flow/info", roleViewer, sessionOf(roleViewer), "", "", http.StatusOK},
+			{"operator action", http.MethodPost, "/api/flow/request/pause", roleOperator, sessionOf(roleOperator), jsonType, "", http.StatusOK},
+			{"viewer action", http.MethodPost, "/api/flow/request/pause", roleOperator, sessionOf(roleViewer), jsonType, "", http.StatusForbidden},
+			{"operator delete", http.MethodPost, "/api/flow/delete", roleAdmin, sessionOf(roleOperator), jsonType, "", http.StatusForbidden},
+			{"admin delete", http.MethodPost, "/api/flow/delete", roleAdmin, sessionOf(roleAdmin), jsonType, "", http.StatusOK},
+			{"no session api", http.MethodGet, "/api/flow/list", roleViewer, "", "", "", http.StatusUnauthorized},
+			{"no session page", http.MethodGet, "/flow/info", roleViewer, "", "", "", http.StatusFound},
+			{"expired session", http.MethodGet, "/api/flow/list", roleViewer, expired, "", "", http.StatusUnauthorized},
+			{"forged session", http.MethodGet, "/api/flow/list", roleViewer, sessionOf(roleAdmin) + "x", "", "", http.StatusUnauthorized},
+			{"form post", http.MethodPost, "/api/flow/delete", roleAdmin, sessionOf(roleAdmin), "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+			{"cross site", http.MethodPost, "/api/flow/delete", roleAdmin, sessionOf(roleAdmin), jsonType, "http://attacker", http.StatusForbidden},
+			{"same site", http.MethodPost, "/api/flow/delete", roleAdmin, sessionOf(roleAdmin), jsonType, "http://dashboard", http.StatusOK},
+		}
+		for _, test := range tests {
+			var user *User
+			handler := authorizeContent(test.role, jsonType, func(w http.ResponseWriter, r *http.Request) {
+				user = currentUser(r)
+			})
+
+			r := httptest.NewRequest(test.method, "http://dashboard"+test.path, strings.NewReader("{}"))
+			if test.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: test.session})
+			}
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+			}
+			if (user != nil) != (test.status == http.StatusOK) {
+				t.Errorf("%s: got user %v for status %d", test.name, user, w.Code)
+			}
+		}
+	})
+}
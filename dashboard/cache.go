@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// time the flow function list is served from cache
+	functionListTTL = 5 * time.Second
+	// time the dot of a flow image is served from cache
+	dotTTL = 30 * time.Minute
+	// time the trace of a completed request is served from cache
+	requestTraceTTL = 30 * time.Minute
+	// maximum entries kept in a cache
+	cacheSize = 5000
+)
+
+// cacheEntry a cached value and its expiry
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// cacheCall an in flight load of a key shared by the concurrent callers
+type cacheCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// ttlCache caches values for a time to live, concurrent loads of the same
+// key are de-duplicated into a single load
+type ttlCache struct {
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+	calls   map[string]*cacheCall
+}
+
+// newTtlCache create an empty cache
+func newTtlCache() *ttlCache {
+	return &ttlCache{
+		entries: make(map[string]*cacheEntry),
+		calls:   make(map[string]*cacheCall),
+	}
+}
+
+// get return the cached value of a key or load it, load returns the time to
+// live of the value, a value with a time to live <= 0 or loaded with an
+// error is not cached but still returned to the callers along with the error
+func (cache *ttlCache) get(key string, load func() (interface{}, time.Duration, error)) (interface{}, error) {
+	cache.mutex.Lock()
+	if entry, ok := cache.entries[key]; ok {
+		if time.Now().Before(entry.expires) {
+			cache.mutex.Unlock()
+			return entry.value, nil
+		}
+		delete(cache.entries, key)
+	}
+	if call, ok := cache.calls[key]; ok {
+		cache.mutex.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	cache.calls[key] = call
+	cache.mutex.Unlock()
+
+	// the waiters are released even if load panics, they get an error and
+	// the panic goes on in the loading caller
+	call.err = fmt.Errorf("failed to load %s, loader panicked", key)
+	defer func() {
+		cache.mutex.Lock()
+		delete(cache.calls, key)
+		cache.mutex.Unlock()
+		call.wg.Done()
+	}()
+
+	value, ttl, err := load()
+	call.value, call.err = value, err
+
+	if err == nil && ttl > 0 {
+		cache.mutex.Lock()
+		cache.store(key, value, ttl)
+		cache.mutex.Unlock()
+	}
+
+	return value, err
+}
+
+// store add an entry, evicting the expired entries and then the ones
+// closest to expiry once the cache is full, must be called with the lock held
+func (cache *ttlCache) store(key string, value interface{}, ttl time.Duration) {
+	now := time.Now()
+	if len(cache.entries) >= cacheSize {
+		for entryKey, entry := range cache.entries {
+			if !now.Before(entry.expires) {
+				delete(cache.entries, entryKey)
+			}
+		}
+	}
+	for len(cache.entries) >= cacheSize {
+		evictKey := ""
+		var evictExpires time.Time
+		for entryKey, entry := range cache.entries {
+			if evictKey == "" || entry.expires.Before(evictExpires) {
+				evictKey, evictExpires = entryKey, entry.expires
+			}
+		}
+		delete(cache.entries, evictKey)
+	}
+	cache.entries[key] = &cacheEntry{value: value, expires: now.Add(ttl)}
+}
+
+// invalidate remove a key from the cache
+func (cache *ttlCache) invalidate(key string) {
+	cache.mutex.Lock()
+	delete(cache.entries, key)
+	cache.mutex.Unlock()
+}
+
+// serviceCache caches the lookups of the service layer
+var serviceCache = newTtlCache()
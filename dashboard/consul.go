@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultConsulPrefix = "faasflow/{flow}/{request}/"
+)
+
+// consulReader reads the DataStore or the StateStore of the flows from the
+// consul kv store, the values of a request are stored under a common key prefix
+type consulReader struct {
+	url    string
+	prefix string
+	token  string
+	client *http.Client
+}
+
+// consulPair a key and its base64 encoded value in the consul kv store
+type consulPair struct {
+	Key   string  `json:"Key"`
+	Value *string `json:"Value"`
+}
+
+// newConsulReader create a consul reader for the url of the consul agent
+func newConsulReader(client *http.Client, storeURL, prefix, token string) (*consulReader, error) {
+	if storeURL == "" {
+		storeURL = "http://consul:8500/"
+	}
+	if !strings.HasSuffix(storeURL, "/") {
+		storeURL = storeURL + "/"
+	}
+	if prefix == "" {
+		prefix = defaultConsulPrefix
+	}
+	return &consulReader{url: storeURL, prefix: prefix, token: token, client: client}, nil
+}
+
+// query request the kv api for a key, a missing key is replied as nil
+func (reader *consulReader) query(ctx context.Context, key, query string) ([]byte, error) {
+	request, _ := http.NewRequest(http.MethodGet, reader.url+"v1/kv/"+escapeKeyPath(key)+"?"+query, nil)
+	request = request.WithContext(ctx)
+	if reader.token != "" {
+		request.Header.Set("X-Consul-Token", reader.token)
+	}
+
+	response, err := reader.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", http.StatusText(response.StatusCode), strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// values get the values stored under the prefix of a request by key
+func (reader *consulReader) values(ctx context.Context, flow, requestID string) (map[string][]byte, error) {
+	prefix := dataLocation(reader.prefix, flow, requestID)
+	body, err := reader.query(ctx, prefix, "recurse=true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys of %s, %v", prefix, err)
+	}
+
+	values := make(map[string][]byte)
+	if body == nil {
+		return values, nil
+	}
+	pairs := make([]*consulPair, 0)
+	if err := json.Unmarshal(body, &pairs); err != nil {
+		return nil, fmt.Errorf("failed to list keys of %s, %v", prefix, err)
+	}
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		// folders have no value
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		value := []byte{}
+		if pair.Value != nil {
+			value, err = base64.StdEncoding.DecodeString(*pair.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode value of %s, %v", pair.Key, err)
+			}
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// List list the keys stored for a request with the size of their value
+func (reader *consulReader) List(ctx context.Context, flow, requestID string) ([]*DataEntry, error) {
+	values, err := reader.values(ctx, flow, requestID)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*DataEntry, 0, len(values))
+	for key, value := range values {
+		entries = append(entries, &DataEntry{Key: key, Size: len(value)})
+	}
+	return entries, nil
+}
+
+// Read read the execution state stored for a request
+func (reader *consulReader) Read(ctx context.Context, flow, requestID string) (map[string]string, error) {
+	values, err := reader.values(ctx, flow, requestID)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]string, len(values))
+	for key, value := range values {
+		state[key] = string(value)
+	}
+	return state, nil
+}
+
+// Get get the raw value of a key stored for a request
+func (reader *consulReader) Get(ctx context.Context, flow, requestID, key string) ([]byte, error) {
+	path := dataLocation(reader.prefix, flow, requestID) + key
+	body, err := reader.query(ctx, path, "raw=true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s, %v", path, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeConsul a consul agent serving the kv store from a map, a nil value is
+// a folder
+type fakeConsul struct {
+	token  string
+	values map[string]*string
+}
+
+func (agent *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if agent.token != "" && r.Header.Get("X-Consul-Token") != agent.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+	if r.URL.Query().Get("recurse") == "true" {
+		pairs := make([]*consulPair, 0)
+		for name, value := range agent.values {
+			if !strings.HasPrefix(name, key) {
+				continue
+			}
+			pair := &consulPair{Key: name}
+			if value != nil {
+				encoded := base64.StdEncoding.EncodeToString([]byte(*value))
+				pair.Value = &encoded
+			}
+			pairs = append(pairs, pair)
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+		return
+	}
+
+	value, found := agent.values[key]
+	if !found || r.URL.Query().Get("raw") != "true" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if value != nil {
+		w.Write([]byte(*value))
+	}
+}
+
+func consulValue(value string) *string {
+	return &value
+}
+
+func newFakeConsulReader(t *testing.T, server *httptest.Server, token string) *consulReader {
+	reader, err := newConsulReader(server.Client(), server.URL, "", token)
+	if err != nil {
+		t.Fatalf("failed to create consul reader, %v", err)
+	}
+	return reader
+}
+
+func TestConsulReaderList(t *testing.T) {
+	agent := &fakeConsul{
+		token: "token",
+		values: map[string]*string{
+			"faasflow/flow/req1/":                nil,
+			"faasflow/flow/req1/key":             consulValue("value"),
+			"faasflow/flow/req1/0--node--next":   consulValue("forwarded"),
+			"faasflow/flow/req1/folder/":         nil,
+			"faasflow/flow/req1/folder/key":      consulValue("nested"),
+			"faasflow/flow/req1/empty":           nil,
+			"faasflow/flow/req10/key":            consulValue("other request"),
+			"faasflow/other/req1/0--node--other": consulValue("other flow"),
+		},
+	}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	entries, err := newFakeConsulReader(t, server, "token").List(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	expected := []*DataEntry{
+		{Key: "0--node--next", Size: 9},
+		{Key: "empty", Size: 0},
+		{Key: "folder/key", Size: 6},
+		{Key: "key", Size: 5},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(expected))
+	}
+	for i, entry := range entries {
+		if *entry != *expected[i] {
+			t.Errorf("got entry %v, want %v", *entry, *expected[i])
+		}
+	}
+
+	entries, err = newFakeConsulReader(t, server, "token").List(context.Background(), "flow", "missing")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("got %v %v, want no entries", entries, err)
+	}
+
+	if _, err := newFakeConsulReader(t, server, "").List(context.Background(), "flow", "req1"); err == nil {
+		t.Errorf("expected an error without token")
+	}
+}
+
+func TestConsulReaderRead(t *testing.T) {
+	agent := &fakeConsul{
+		values: map[string]*string{
+			"faasflow/flow/req1/request-state":          consulValue("STARTED"),
+			"faasflow/flow/req1/node-branch-completion": consulValue("2"),
+		},
+	}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	state, err := newFakeConsulReader(t, server, "").Read(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(state) != 2 || state["request-state"] != "STARTED" || state["node-branch-completion"] != "2" {
+		t.Errorf("got state %v", state)
+	}
+}
+
+func TestConsulReaderGet(t *testing.T) {
+	agent := &fakeConsul{
+		values: map[string]*string{
+			"faasflow/flow/req1/key":       consulValue("value"),
+			"faasflow/flow/req1/a b--node": consulValue("spaced"),
+		},
+	}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+	reader := newFakeConsulReader(t, server, "")
+
+	tests := []struct {
+		key   string
+		value []byte
+	}{
+		{"key", []byte("value")},
+		{"a b--node", []byte("spaced")},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		value, err := reader.Get(context.Background(), "flow", "req1", test.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.key, err)
+			continue
+		}
+		if string(value) != string(test.value) || (value == nil) != (test.value == nil) {
+			t.Errorf("%s: got %q, want %q", test.key, value, test.value)
+		}
+	}
+}
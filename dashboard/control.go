@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ControlResult result of a control action on a request
+type ControlResult struct {
+	RequestID string `json:"request-id"`
+	State     string `json:"state"`
+	Success   bool   `json:"success"`
+	// Skipped the request is not in the state the action is requested for
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// allowedStates states of a request in which an action is allowed
+var allowedStates = map[string][]string{
+	"pause":  {"RUNNING"},
+	"resume": {"PAUSED"},
+	"stop":   {"RUNNING", "PAUSED"},
+}
+
+// requestUser identify the user performing a request
+func requestUser(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return user.Name
+	}
+	return clientAddress(r)
+}
+
+// trustedProxies the proxies whose forwarded user and address are trusted
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies parse a comma separated list of ips and cidrs
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s, %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// isTrustedProxy check if a peer address is a trusted proxy
+func isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress identify the client of a request when no user is authenticated,
+// the forwarded user and address are only used behind a trusted proxy
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	if user := r.Header.Get("X-Forwarded-User"); user != "" {
+		return user
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return host
+}
+
+// isActionAllowed check if an action can be performed on a request in the given state
+func isActionAllowed(action, state string) bool {
+	state = strings.TrimSpace(state)
+	for _, allowed := range allowedStates[action] {
+		if state == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// performControl read the request state, perform the action and audit it
+func performControl(ctx context.Context, user, flowName, requestID, action string) *ControlResult {
+	state, err := getRequestStatus(ctx, flowName, requestID)
+	return controlRequest(user, flowName, requestID, action, state, err)
+}
+
+// controlRequest verify the request state already read, perform the action
+// and audit it
+func controlRequest(user, flowName, requestID, action, state string, err error) *ControlResult {
+	result := &ControlResult{RequestID: requestID}
+
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get request state, %v", err)
+	} else {
+		result.State = state
+		if !isActionAllowed(action, state) {
+			result.Error = fmt.Sprintf("can't %s request in state %s, must be %s",
+				action, state, strings.Join(allowedStates[action], " or "))
+		} else if err = controlFlowRequest(flowName, requestID, action); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+		}
+	}
+
+	audit.record(&AuditEntry{
+		Time:          time.Now(),
+		User:          user,
+		Action:        action,
+		Flow:          flowName,
+		RequestID:     requestID,
+		PreviousState: result.State,
+		Success:       result.Success,
+		Error:         result.Error,
+	})
+
+	return result
+}
+
+// controlRequestHandler build a handler that perform an action on a single request
+func controlRequestHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Body == nil {
+			http.Error(w, "invalid request, no content", http.StatusBadRequest)
+			return
+		}
+
+		var msg Message
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if msg.FlowName == "" || msg.RequestID == "" {
+			http.Error(w, "invalid request, function and request-id must be specified", http.StatusBadRequest)
+			return
+		}
+		if !validFunctionName.MatchString(msg.FlowName) {
+			http.Error(w, fmt.Sprintf("invalid request, invalid flow name %q", msg.FlowName), http.StatusBadRequest)
+			return
+		}
+
+		result := performControl(r.Context(), requestUser(r), msg.FlowName, msg.RequestID, action)
+
+		status := http.StatusOK
+		if !result.Success {
+			if result.State != "" && !isActionAllowed(action, result.State) {
+				status = http.StatusConflict
+			} else {
+				status = http.StatusBadGateway
+			}
+		}
+
+		data, _ := json.MarshalIndent(result, "", "    ")
+		w.Header().Set("Content-Type", jsonType)
+		w.WriteHeader(status)
+		w.Write(data)
+	}
+}
+
+// controlRequestsHandler build a handler that perform an action on all the
+// requests of a flow in a given state, or on the listed requests
+func controlRequestsHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Body == nil {
+			http.Error(w, "invalid request, no content", http.StatusBadRequest)
+			return
+		}
+
+		var msg Message
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if msg.FlowName == "" {
+			http.Error(w, "invalid request, function must be specified", http.StatusBadRequest)
+			return
+		}
+		if !validFunctionName.MatchString(msg.FlowName) {
+			http.Error(w, fmt.Sprintf("invalid request, invalid flow name %q", msg.FlowName), http.StatusBadRequest)
+			return
+		}
+
+		requestIDs := msg.RequestIDs
+		if len(requestIDs) == 0 {
+			requests, err := listFlowRequests(r.Context(), msg.FlowName, &RequestQuery{})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to get requests, error: %v", err), http.StatusBadGateway)
+				return
+			}
+			for _, request := range requests.Requests {
+				requestIDs = append(requestIDs, request.RequestID)
+			}
+		}
+
+		user := requestUser(r)
+		results := make([]*ControlResult, len(requestIDs))
+		fanOut(r.Context(), len(requestIDs), func(ctx context.Context, index int) error {
+			requestID := requestIDs[index]
+			state, err := getRequestStatus(ctx, msg.FlowName, requestID)
+			if err == nil && msg.State != "" && strings.TrimSpace(state) != msg.State {
+				results[index] = &ControlResult{
+					RequestID: requestID,
+					State:     state,
+					Skipped:   true,
+					Error:     fmt.Sprintf("request is in state %s, not %s", state, msg.State),
+				}
+				return nil
+			}
+			results[index] = controlRequest(user, msg.FlowName, requestID, action, state, err)
+			return nil
+		})
+
+		// the requests not reached once the call is cancelled are reported
+		performed, skipped := 0, 0
+		for index, result := range results {
+			switch {
+			case result == nil:
+				results[index] = &ControlResult{RequestID: requestIDs[index], Error: "request was not controlled, the call was cancelled"}
+			case result.Success:
+				performed++
+			case result.Skipped:
+				skipped++
+			}
+		}
+
+		log.Printf("%s performed on %d of %d requests of %s, %d skipped", action, performed, len(results), msg.FlowName, skipped)
+
+		data, _ := json.MarshalIndent(results, "", "    ")
+		w.Header().Set("Content-Type", jsonType)
+		w.Write(data)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // HtmlObject object to render web page
@@ -18,10 +23,21 @@ type HtmlObject struct {
 
 	InnerHtml string
 
+	// Warning shown when the page is partially built
+	Warning string
+
+	// User the authenticated user
+	User *User
+
 	DashBoard *DashboardSpec
 	Flow      *FlowDesc
 	Requests  *FlowRequests
 	Traces    *RequestTrace
+	Versions  *FlowVersions
+	Analytics *FlowAnalytics
+
+	Dependencies *DependencyIndex
+	Stuck        *StuckRequests
 }
 
 // Message API request query
@@ -29,42 +45,193 @@ type Message struct {
 	FlowName  string `json:"function"`
 	RequestID string `json:"request-id"`
 	TraceID   string `json:"trace-id"`
+
+	// bulk request control
+	RequestIDs []string `json:"request-ids,omitempty"`
+	State      string   `json:"state,omitempty"`
+
+	// requests list paging
+	RequestQuery
+
+	// delete a flow other flows call
+	Force bool `json:"force,omitempty"`
+
+	// images of the flow dags to compare
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// analytics window, used when no start is given
+	Window string `json:"window,omitempty"`
+
+	// mark the critical path on the request dag
+	Critical bool `json:"critical,omitempty"`
+
+	// key of a value stored by a request in the datastore
+	Key string `json:"key,omitempty"`
+
+	// text the logs of a request are filtered with
+	Search string `json:"search,omitempty"`
+}
+
+const (
+	// requests listed per page by default
+	defaultRequestsLimit = 20
+	// requests listed in the monitor request choice
+	monitorRequestsLimit = 50
+)
+
+// parseTimeParam parse a time query parameter in microseconds, either as
+// microseconds since epoch, RFC3339 or a html datetime-local value
+func parseTimeParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if micros, err := strconv.Atoi(value); err == nil {
+		return micros, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		parsed, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %s", value)
+		}
+	}
+	return int(parsed.UnixNano() / 1000), nil
+}
+
+// parseRequestQuery parse the requests list query from the page url
+func parseRequestQuery(r *http.Request) (*RequestQuery, error) {
+	values := r.URL.Query()
+
+	offset, limit, err := parsePaging(r, defaultRequestsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &RequestQuery{
+		Offset:     offset,
+		Limit:      limit,
+		Cursor:     values.Get("cursor"),
+		PrevCursor: values.Get("prev-cursor"),
+		Sort:       values.Get("sort"),
+		Order:      values.Get("order"),
+	}
+
+	query.Start, err = parseTimeParam(values.Get("start"))
+	if err != nil {
+		return nil, err
+	}
+	query.End, err = parseTimeParam(values.Get("end"))
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// requestsPageLink build the link to a page of the requests list, the page
+// after the cursor or before the prev cursor when given
+func requestsPageLink(flowName, source string, query *RequestQuery, offset int, cursor, prevCursor string) string {
+	params := url.Values{}
+	params.Set("flow-name", flowName)
+	if source != "" {
+		params.Set("source", source)
+	}
+	params.Set("limit", strconv.Itoa(query.Limit))
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	if prevCursor != "" {
+		params.Set("prev-cursor", prevCursor)
+	}
+	if query.Start > 0 {
+		params.Set("start", strconv.Itoa(query.Start))
+	}
+	if query.End > 0 {
+		params.Set("end", strconv.Itoa(query.End))
+	}
+	if query.Sort != "" {
+		params.Set("sort", query.Sort)
+	}
+	if query.Order != "" {
+		params.Set("order", query.Order)
+	}
+	return "/function/faas-flow-dashboard/flow/requests?" + params.Encode()
+}
+
+// partialWarning build the warning for lookups that failed or timed out
+func partialWarning(incomplete int, of string) string {
+	if incomplete == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Showing partial results, lookup of %d %s failed or timed out", incomplete, of)
 }
 
 // dashboardPageHandler handle dashboard view
 func dashboardPageHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Serving request for dashboard view")
 
+	warning := ""
 	functions, err := listFlowFunctions()
 	if err != nil {
 		log.Printf("failed to get functions, error: %v", err)
+		warning = fmt.Sprintf("Failed to list the flows, %v", err)
 		functions = make([]*Function, 0)
 	}
 
-	totalRequests := 0
-	for _, function := range functions {
-		requests, err := listFlowRequests(function.Name)
+	ctx, cancel := pageContext(r.Context())
+	defer cancel()
+
+	flowRequests := make([]*RequestList, len(functions))
+	completed := fanOut(ctx, len(functions), func(ctx context.Context, index int) error {
+		requests, err := listFlowRequests(ctx, functions[index].Name, &RequestQuery{CountOnly: true})
 		if err != nil {
 			log.Printf("failed to get requests, error: %v", err)
-			continue
+			return err
+		}
+		flowRequests[index] = requests
+		return nil
+	})
+
+	totalRequests := 0
+	totalTruncated := false
+	for _, requests := range flowRequests {
+		if requests != nil {
+			totalRequests = totalRequests + requests.Total
+			totalTruncated = totalTruncated || requests.Truncated
+		}
+	}
+
+	readyFlows := 0
+	for _, function := range functions {
+		if function.isReady() {
+			readyFlows++
 		}
-		totalRequests = totalRequests + len(requests)
 	}
 
 	dashboardSpec := &DashboardSpec{
 		TotalFlows:     len(functions),
-		ReadyFlows:     len(functions),
+		ReadyFlows:     readyFlows,
 		TotalRequests:  totalRequests,
-		ActiveRequests: 0,
+		TotalTruncated: totalTruncated,
+		ActiveRequests: activeRequests.count(ctx, functions),
 	}
 
 	htmlObj := HtmlObject{
 		PublicURL: publicUri,
+		User:      currentUser(r),
 		Functions: functions,
 
 		InnerHtml: "dashboard",
 
 		DashBoard: dashboardSpec,
+
+		Warning: warning,
+	}
+	if htmlObj.Warning == "" {
+		htmlObj.Warning = partialWarning(countIncomplete(completed), "flows")
 	}
 
 	err = gen.ExecuteTemplate(w, "index", htmlObj)
@@ -80,6 +247,8 @@ func flowInfoPageHandler(w http.ResponseWriter, r *http.Request) {
 
 	flowName := r.URL.Query().Get("flow-name")
 
+	warning := ""
+	status := http.StatusOK
 	functions, err := listFlowFunctions()
 	if err != nil {
 		log.Printf("failed to get functions, error: %v", err)
@@ -89,18 +258,32 @@ func flowInfoPageHandler(w http.ResponseWriter, r *http.Request) {
 	flowDesc, err := buildFlowDesc(functions, flowName)
 	if err != nil {
 		log.Printf("failed to get function desc, error: %v", err)
-	}
-
-	requests, err := listFlowRequests(flowName)
-	if err != nil {
-		log.Printf("failed to get requests, error: %v", err)
-		flowDesc.InvocationCount = 0
+		warning = fmt.Sprintf("Failed to get the flow, %v", err)
+		status = errorStatus(err)
+		flowDesc = &FlowDesc{Name: flowName}
 	} else {
-		flowDesc.InvocationCount = float64(len(requests))
+		requests, err := listFlowRequests(r.Context(), flowName, &RequestQuery{CountOnly: true})
+		if err != nil {
+			log.Printf("failed to get requests, error: %v", err)
+			warning = fmt.Sprintf("Failed to get the flow requests, %v", err)
+			flowDesc.InvocationCount = 0
+		} else {
+			flowDesc.InvocationCount = float64(requests.Total)
+			flowDesc.InvocationTruncated = requests.Truncated
+		}
+
+		flowDesc.Lint, err = getLintReport(flowName, flowDesc.Image)
+		if err != nil {
+			log.Printf("failed to get lint report, error: %v", err)
+			if warning == "" {
+				warning = fmt.Sprintf("Failed to lint the flow, %v", err)
+			}
+		}
 	}
 
 	htmlObj := HtmlObject{
 		PublicURL: publicUri,
+		User:      currentUser(r),
 		Functions: functions,
 
 		CurrentLocation: &Location{
@@ -111,6 +294,214 @@ func flowInfoPageHandler(w http.ResponseWriter, r *http.Request) {
 		InnerHtml: "flow-info",
 
 		Flow: flowDesc,
+
+		Warning: warning,
+	}
+
+	w.WriteHeader(status)
+	err = gen.ExecuteTemplate(w, "index", htmlObj)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate requested page, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// flowVersionsPageHandler handle the flow versions view
+func flowVersionsPageHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request for flow versions view")
+
+	flowName := r.URL.Query().Get("flow-name")
+
+	warning := ""
+	status := http.StatusOK
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		functions = make([]*Function, 0)
+	}
+
+	versions, err := buildFlowVersions(flowName, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		log.Printf("failed to get flow versions, error: %v", err)
+		warning = fmt.Sprintf("Failed to compare the flow versions, %v", err)
+		status = errorStatus(err)
+		versions = &FlowVersions{Flow: flowName}
+		if snapshots != nil {
+			versions.Snapshots, _ = snapshots.List(flowName)
+		}
+	}
+
+	htmlObj := HtmlObject{
+		PublicURL: publicUri,
+		User:      currentUser(r),
+		Functions: functions,
+
+		LocationDepths: []*Location{
+			&Location{
+				Name: "Flow : " + flowName + "",
+				Link: "/function/faas-flow-dashboard/flow/info?flow-name=" + flowName,
+			},
+		},
+
+		CurrentLocation: &Location{
+			Name: "Versions",
+			Link: "/function/faas-flow-dashboard/flow/versions?flow-name=" + flowName,
+		},
+
+		InnerHtml: "flow-versions",
+
+		Versions: versions,
+
+		Warning: warning,
+	}
+
+	w.WriteHeader(status)
+	err = gen.ExecuteTemplate(w, "index", htmlObj)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate requested page, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// flowAnalyticsPageHandler handle the flow analytics view
+func flowAnalyticsPageHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request for flow analytics view")
+
+	values := r.URL.Query()
+	flowName := values.Get("flow-name")
+
+	warning := ""
+	status := http.StatusOK
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		functions = make([]*Function, 0)
+	}
+
+	// the analytics read every request of the window, they are not bound
+	// to the page timeout
+	var analytics *FlowAnalytics
+	start, end, err := parseAnalyticsRange(values)
+	if err == nil {
+		analytics, err = getFlowAnalytics(r.Context(), flowName, values.Get("window"), start, end)
+	}
+	if err != nil {
+		log.Printf("failed to get flow analytics, error: %v", err)
+		warning = fmt.Sprintf("Failed to get the flow analytics, %v", err)
+		status = errorStatus(err)
+		analytics = &FlowAnalytics{Flow: flowName, Window: values.Get("window"), Latency: &LatencyPercentiles{}}
+	} else if analytics.Truncated {
+		warning = "Showing partial results, the window holds more requests than analysed"
+	}
+
+	htmlObj := HtmlObject{
+		PublicURL: publicUri,
+		User:      currentUser(r),
+		Functions: functions,
+
+		LocationDepths: []*Location{
+			&Location{
+				Name: "Flow : " + flowName + "",
+				Link: "/function/faas-flow-dashboard/flow/info?flow-name=" + flowName,
+			},
+		},
+
+		CurrentLocation: &Location{
+			Name: "Analytics",
+			Link: "/function/faas-flow-dashboard/flow/analytics?flow-name=" + flowName,
+		},
+
+		InnerHtml: "flow-analytics",
+
+		Analytics: analytics,
+
+		Warning: warning,
+	}
+
+	w.WriteHeader(status)
+	err = gen.ExecuteTemplate(w, "index", htmlObj)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate requested page, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// dependenciesPageHandler handle the flow dependencies view
+func dependenciesPageHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request for dependencies view")
+
+	warning := ""
+	status := http.StatusOK
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		functions = make([]*Function, 0)
+	}
+
+	ctx, cancel := pageContext(r.Context())
+	defer cancel()
+
+	index, err := buildDependencyIndex(ctx)
+	if err != nil {
+		log.Printf("failed to get dependencies, error: %v", err)
+		warning = fmt.Sprintf("Failed to get the flow dependencies, %v", err)
+		status = errorStatus(err)
+		index = &DependencyIndex{}
+	} else if len(index.Incomplete) > 0 {
+		warning = partialWarning(len(index.Incomplete), "flows")
+	}
+
+	htmlObj := HtmlObject{
+		PublicURL: publicUri,
+		User:      currentUser(r),
+		Functions: functions,
+
+		CurrentLocation: &Location{
+			Name: "Dependencies",
+			Link: "/function/faas-flow-dashboard/dependencies",
+		},
+
+		InnerHtml: "dependencies",
+
+		Dependencies: index,
+
+		Warning: warning,
+	}
+
+	w.WriteHeader(status)
+	err = gen.ExecuteTemplate(w, "index", htmlObj)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate requested page, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// stuckRequestsPageHandler handle the stuck requests view
+func stuckRequestsPageHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request for stuck requests view")
+
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		functions = make([]*Function, 0)
+	}
+
+	warning := ""
+	if stuckSweepInterval <= 0 {
+		warning = "The stuck request sweeper is disabled"
+	}
+
+	htmlObj := HtmlObject{
+		PublicURL: publicUri,
+		User:      currentUser(r),
+		Functions: functions,
+
+		CurrentLocation: &Location{
+			Name: "Stuck Requests",
+			Link: "/function/faas-flow-dashboard/requests/stuck",
+		},
+
+		InnerHtml: "stuck-requests",
+
+		Stuck: stuckRequests.list(r.URL.Query().Get("flow-name")),
+
+		Warning: warning,
 	}
 
 	err = gen.ExecuteTemplate(w, "index", htmlObj)
@@ -131,40 +522,87 @@ func flowRequestsPageHandler(w http.ResponseWriter, r *http.Request) {
 		functions = make([]*Function, 0)
 	}
 
-	tracingEnabled := false
-	requests, err := listFlowRequests(flowName)
+	query, err := parseRequestQuery(r)
 	if err != nil {
-		log.Printf("failed to get requests, error: %v", err)
-		requests = make(map[string]string)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	requestsList := make(map[string]*RequestTrace)
+	ctx, cancel := pageContext(r.Context())
+	defer cancel()
+	warning := ""
 
-	for request, traceId := range requests {
-		requestsList[request], err = listRequestTraces(traceId)
+	flowRequests := &FlowRequests{
+		Flow:   flowName,
+		Source: r.URL.Query().Get("source"),
+		Query:  query,
+	}
+
+	if flowRequests.Source == "history" {
+		// requests dropped by the trace server are served from the history
+		records, total, err := history.List(flowName, query.Offset, query.Limit)
 		if err != nil {
-			log.Printf("failed to get request traces for request %s, traceId %s, error: %v",
-				request, traceId, err)
-			requestsList[request] = &RequestTrace{
-				TraceId: traceId,
-			}
+			log.Printf("failed to get request history for %s, error: %v", flowName, err)
 		}
-
-		requestState, err := getRequestStatus(flowName, request)
+		for _, record := range records {
+			flowRequests.Requests = append(flowRequests.Requests, historyRequestTrace(record))
+		}
+		flowRequests.TracingEnabled = total > 0
+		flowRequests.Total = total
+		if query.Offset+len(records) < total {
+			flowRequests.NextPage = requestsPageLink(flowName, flowRequests.Source, query, query.Offset+query.Limit, "", "")
+		}
+		if query.Offset > 0 {
+			flowRequests.PrevPage = requestsPageLink(flowName, flowRequests.Source, query, query.Offset-query.Limit, "", "")
+		}
+	} else {
+		requests, err := listFlowRequests(ctx, flowName, query)
 		if err != nil {
-			log.Printf("failed to get request state for %s, request %s, error: %v",
-				flowName, request, err)
-			requestState = "UNKNOWN"
+			log.Printf("failed to get requests, error: %v", err)
+			warning = fmt.Sprintf("Failed to list the requests, %v", err)
+			requests = &RequestList{}
 		}
-		requestsList[request].Status = requestState
-
-		tracingEnabled = true
-	}
 
-	flowRequests := &FlowRequests{
-		TracingEnabled: tracingEnabled,
-		Flow:           flowName,
-		Requests:       requestsList,
+		flowRequests.Requests = make([]*RequestTrace, len(requests.Requests))
+		completed := fanOut(ctx, len(requests.Requests), func(ctx context.Context, index int) error {
+			var err error
+			flowRequests.Requests[index], err = buildRequestTrace(flowName, requests.Requests[index])
+			return err
+		})
+		for index, request := range requests.Requests {
+			// lookups not started before the deadline are left partial
+			if flowRequests.Requests[index] == nil {
+				flowRequests.Requests[index] = &RequestTrace{
+					RequestID: request.RequestID,
+					TraceId:   request.TraceID,
+					StartTime: request.StartTime,
+					Duration:  request.Duration,
+					Status:    "UNKNOWN",
+				}
+			}
+			flowRequests.TracingEnabled = true
+		}
+		if warning == "" {
+			warning = partialWarning(countIncomplete(completed), "requests")
+		}
+		flowRequests.Total = requests.Total
+		flowRequests.Truncated = requests.Truncated
+		paged := query.Cursor != "" || query.PrevCursor != ""
+		switch {
+		case requests.NextCursor != "":
+			flowRequests.NextPage = requestsPageLink(flowName, flowRequests.Source, query, 0, requests.NextCursor, "")
+		case !paged && query.Limit > 0 && requests.Offset+len(requests.Requests) < requests.Total:
+			flowRequests.NextPage = requestsPageLink(flowName, flowRequests.Source, query, query.Offset+query.Limit, "", "")
+		}
+		switch {
+		case requests.PrevCursor != "":
+			flowRequests.PrevPage = requestsPageLink(flowName, flowRequests.Source, query, 0, "", requests.PrevCursor)
+		case query.Cursor != "":
+			// an empty page after a cursor goes back to the first page
+			flowRequests.PrevPage = requestsPageLink(flowName, flowRequests.Source, query, 0, "", "")
+		case query.Offset > 0:
+			flowRequests.PrevPage = requestsPageLink(flowName, flowRequests.Source, query, query.Offset-query.Limit, "", "")
+		}
 	}
 
 	locationDepths := []*Location{
@@ -176,6 +614,7 @@ func flowRequestsPageHandler(w http.ResponseWriter, r *http.Request) {
 
 	htmlObj := HtmlObject{
 		PublicURL: publicUri,
+		User:      currentUser(r),
 		Functions: functions,
 
 		LocationDepths: locationDepths,
@@ -188,6 +627,8 @@ func flowRequestsPageHandler(w http.ResponseWriter, r *http.Request) {
 		Requests: flowRequests,
 
 		InnerHtml: "requests",
+
+		Warning: warning,
 	}
 
 	err = gen.ExecuteTemplate(w, "index", htmlObj)
@@ -209,44 +650,64 @@ func flowRequestMonitorPageHandler(w http.ResponseWriter, r *http.Request) {
 		functions = make([]*Function, 0)
 	}
 
+	ctx, cancel := pageContext(r.Context())
+	defer cancel()
+
 	tracingEnabled := false
-	requests, err := listFlowRequests(flowName)
+	warning := ""
+	requests, err := listFlowRequests(ctx, flowName, &RequestQuery{Limit: monitorRequestsLimit})
 	if err != nil {
 		log.Printf("failed to get requests, error: %v", err)
-		requests = make(map[string]string)
+		warning = fmt.Sprintf("Failed to list the requests, %v", err)
+		requests = &RequestList{}
 	}
 
-	requestsList := make(map[string]*RequestTrace)
+	requestsList := make([]*RequestTrace, 0)
+	var currentRequest *RequestTrace
 
-	for request, traceId := range requests {
-		requestsList[request], err = listRequestTraces(traceId)
-		if err != nil {
-			log.Printf("failed to get request traces for request %s, traceId %s, error: %v",
-				request, traceId, err)
-			requestsList[request] = &RequestTrace{
-				TraceId: traceId,
+	for _, request := range requests.Requests {
+		if currentRequestID == "" {
+			currentRequestID = request.RequestID
+		}
+		requestTrace := &RequestTrace{
+			RequestID: request.RequestID,
+			TraceId:   request.TraceID,
+			StartTime: request.StartTime,
+			Duration:  request.Duration,
+		}
+		// only the monitored request needs its traces and state
+		if request.RequestID == currentRequestID {
+			requestTrace, err = buildRequestTrace(flowName, request)
+			if err != nil {
+				warning = partialWarning(1, "requests")
 			}
+			currentRequest = requestTrace
 		}
+		requestsList = append(requestsList, requestTrace)
+		tracingEnabled = true
+	}
 
-		requestState, err := getRequestStatus(flowName, request)
+	// a request dropped by the trace server is served from the history
+	if currentRequest == nil && currentRequestID != "" {
+		record, err := history.Get(flowName, currentRequestID)
 		if err != nil {
-			log.Printf("failed to get request state for %s, request %s, error: %v",
-				flowName, request, err)
-			requestState = "UNKNOWN"
+			log.Printf("failed to get request history for %s, request %s, error: %v",
+				flowName, currentRequestID, err)
 		}
-		requestsList[request].Status = requestState
-
-		tracingEnabled = true
-		if currentRequestID == "" {
-			currentRequestID = request
+		if record != nil {
+			currentRequest = historyRequestTrace(record)
+			requestsList = append(requestsList, currentRequest)
+			tracingEnabled = true
 		}
 	}
 
 	flowRequests := &FlowRequests{
-		TracingEnabled:   tracingEnabled,
-		Flow:             flowName,
-		Requests:         requestsList,
-		CurrentRequestID: currentRequestID,
+		TracingEnabled:    tracingEnabled,
+		DataStoreEnabled:  dataStore != nil,
+		StateStoreEnabled: stateStore != nil,
+		Flow:              flowName,
+		Requests:          requestsList,
+		CurrentRequestID:  currentRequestID,
 	}
 
 	locationDepths := []*Location{
@@ -262,6 +723,7 @@ func flowRequestMonitorPageHandler(w http.ResponseWriter, r *http.Request) {
 
 	htmlObj := HtmlObject{
 		PublicURL: publicUri,
+		User:      currentUser(r),
 		Functions: functions,
 
 		LocationDepths: locationDepths,
@@ -272,9 +734,11 @@ func flowRequestMonitorPageHandler(w http.ResponseWriter, r *http.Request) {
 
 		Requests: flowRequests,
 
-		Traces: requestsList[currentRequestID],
+		Traces: currentRequest,
 
 		InnerHtml: "request-monitor",
+
+		Warning: warning,
 	}
 
 	err = gen.ExecuteTemplate(w, "index", htmlObj)
@@ -291,7 +755,7 @@ func listFlowsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", jsonType)
 	functions, err := listFlowFunctions()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to handle list request, error: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to handle list request, error: %v", err), errorStatus(err))
 		return
 	}
 	data, _ := json.MarshalIndent(functions, "", "    ")
@@ -314,9 +778,45 @@ func deleteFlowsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	flowName := msg.FlowName
-	log.Printf("deleting flow %s", flowName)
+
+	// other flows calling the flow break once it is deleted, the flow is only
+	// deleted without force when all the other flows are known not to call it
+	if !msg.Force {
+		index, err := buildDependencyIndex(r.Context())
+		if err != nil {
+			log.Printf("not deleting flow %s, failed to check dependents, error: %v", flowName, err)
+			http.Error(w, fmt.Sprintf("failed to check the flows calling %s, %v, set force to delete it",
+				flowName, err), http.StatusConflict)
+			return
+		}
+		if dependents := index.dependents(flowName); len(dependents) > 0 {
+			log.Printf("not deleting flow %s, called by %v", flowName, dependents)
+			http.Error(w, fmt.Sprintf("flow %s is called by %s, set force to delete it",
+				flowName, strings.Join(dependents, ", ")), http.StatusConflict)
+			return
+		}
+		unknown := make([]string, 0)
+		for _, incomplete := range index.Incomplete {
+			if incomplete != flowName {
+				unknown = append(unknown, incomplete)
+			}
+		}
+		if len(unknown) > 0 {
+			log.Printf("not deleting flow %s, dags of %v unknown", flowName, unknown)
+			http.Error(w, fmt.Sprintf("flow %s may be called by %s whose dag could not be exported, set force to delete it",
+				flowName, strings.Join(unknown, ", ")), http.StatusConflict)
+			return
+		}
+	}
+
+	detail := ""
+	if msg.Force {
+		detail = "force"
+	}
+	log.Printf("deleting flow %s, force: %v", flowName, msg.Force)
 
 	err = deleteFlowFunction(flowName)
+	recordDeploy(r, deployActionDelete, flowName, detail, err)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -345,13 +845,13 @@ func flowDescHandler(w http.ResponseWriter, r *http.Request) {
 
 	functions, err := listFlowFunctions()
 	if err != nil {
-		log.Printf("failed to get functions, error: %v", err)
-		functions = make([]*Function, 0)
+		http.Error(w, "failed to handle request, "+err.Error(), errorStatus(err))
+		return
 	}
 
 	flowDesc, err := buildFlowDesc(functions, flowName)
 	if err != nil {
-		http.Error(w, "failed to handle request, "+err.Error(), 500)
+		http.Error(w, "failed to handle request, "+err.Error(), errorStatus(err))
 		return
 	}
 
@@ -368,7 +868,7 @@ func listFlowRequestsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var msg Message
+	msg := Message{RequestQuery: RequestQuery{Limit: defaultRequestsLimit}}
 	err := json.NewDecoder(r.Body).Decode(&msg)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -378,9 +878,9 @@ func listFlowRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	flowFunction := msg.FlowName
 
 	w.Header().Set("Content-Type", jsonType)
-	requests, err := listFlowRequests(flowFunction)
+	requests, err := listFlowRequests(r.Context(), flowFunction, &msg.RequestQuery)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
 		return
 	}
 	data, _ := json.MarshalIndent(requests, "", "    ")
@@ -408,21 +908,322 @@ func requestTracesHandler(w http.ResponseWriter, r *http.Request) {
 	requestId := msg.RequestID
 
 	w.Header().Set("Content-Type", jsonType)
-	trace, err := listRequestTraces(traceID)
+	trace, err := listRequestTraces(r.Context(), traceID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
 		return
 	}
 
-	state, err := getRequestStatus(flowName, requestId)
+	state, err := getRequestStatus(r.Context(), flowName, requestId)
 	if err != nil {
 		log.Printf("failed to get request state for %s, request %s, error: %v",
 			flowName, requestId, err)
-		state = "UNKNOWN"
 	}
-	trace.Status = state
+	// the trace is not checked to belong to the request, it is recorded in the
+	// history by the request discovery only
+	trace.Status = requestStatus(trace, state, err)
 
 	data, _ := json.MarshalIndent(trace, "", "    ")
 	w.Write(data)
 	return
 }
+
+// criticalPathHandler request handler for the critical path of a request
+func criticalPathHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceID := msg.TraceID
+	if traceID == "" {
+		traceID, err = findRequestTraceID(r.Context(), msg.FlowName, msg.RequestID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	path, err := getCriticalPath(r.Context(), traceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(path, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// requestDotHandler request handler for the dag of a request colored by the
+// execution of its nodes
+func requestDotHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", 500)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	dot, err := getRequestDot(r.Context(), msg.FlowName, msg.RequestID, msg.TraceID, msg.Critical)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(dot))
+}
+
+// flowVersionsHandler request handler for the dag snapshots of a flow and the
+// diff between two images
+func flowVersionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	versions, err := buildFlowVersions(msg.FlowName, msg.From, msg.To)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(versions, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// flowLintHandler request handler for the lint report of a flow
+func flowLintHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	functions, err := listFlowFunctions()
+	if err != nil {
+		http.Error(w, "failed to handle request, "+err.Error(), errorStatus(err))
+		return
+	}
+
+	image := ""
+	for _, function := range functions {
+		if function.Name == msg.FlowName {
+			image = function.Image
+			break
+		}
+	}
+	if image == "" {
+		http.Error(w, fmt.Sprintf("flow %s not found", msg.FlowName), http.StatusNotFound)
+		return
+	}
+
+	report, err := getLintReport(msg.FlowName, image)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(report, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// flowAnalyticsHandler request handler for the aggregated statistics of the
+// requests of a flow
+func flowAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	analytics, err := getFlowAnalytics(r.Context(), msg.FlowName, msg.Window, msg.Start, msg.End)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(analytics, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// dependenciesHandler request handler for the dependency index of the flows
+func dependenciesHandler(w http.ResponseWriter, r *http.Request) {
+
+	index, err := buildDependencyIndex(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(index, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// dependentsHandler request handler for the flows calling a function
+func dependentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index, err := buildDependencyIndex(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	dependents := &Dependents{Function: msg.FlowName, Flows: index.dependents(msg.FlowName)}
+	data, _ := json.MarshalIndent(dependents, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// requestDataHandler request handler for the values a request stored in the
+// datastore grouped by node
+func requestDataHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg.FlowName == "" || msg.RequestID == "" {
+		http.Error(w, "invalid request, function and request-id must be specified", http.StatusBadRequest)
+		return
+	}
+
+	var data interface{}
+	if msg.Key == "" {
+		data, err = listRequestData(r.Context(), msg.FlowName, msg.RequestID)
+	} else {
+		var payload []byte
+		payload, err = getRequestData(r.Context(), msg.FlowName, msg.RequestID, msg.Key)
+		if err == nil {
+			data = previewData(msg.Key, payload)
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	encoded, _ := json.MarshalIndent(data, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(encoded)
+}
+
+// requestStateHandler request handler for the execution state a request
+// keeps in the statestore
+func requestStateHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg.FlowName == "" || msg.RequestID == "" {
+		http.Error(w, "invalid request, function and request-id must be specified", http.StatusBadRequest)
+		return
+	}
+
+	state, err := getExecutionState(r.Context(), msg.FlowName, msg.RequestID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	encoded, _ := json.MarshalIndent(state, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(encoded)
+}
+
+// requestDataDownloadHandler request handler to download a value a request
+// stored in the datastore
+func requestDataDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	flowName := values.Get("function")
+	requestID := values.Get("request-id")
+	key := values.Get("key")
+	if flowName == "" || requestID == "" || key == "" {
+		http.Error(w, "invalid request, function, request-id and key must be specified", http.StatusBadRequest)
+		return
+	}
+
+	payload, err := getRequestData(r.Context(), flowName, requestID, key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+
+	filename := strings.Map(func(c rune) rune {
+		if c == '"' || c == '\\' || c == '/' || c < ' ' {
+			return '_'
+		}
+		return c
+	}, requestID+"-"+key)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Write(payload)
+}
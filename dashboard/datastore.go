@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	sdk "github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const (
+	// bytes of a value shown in a preview
+	dataPreviewLimit = 4096
+)
+
+// DataEntry a value stored by a request in the DataStore of a flow
+type DataEntry struct {
+	Key  string
+	Size int
+}
+
+// DataStoreReader reads the values the requests of a flow store in the
+// DataStore, keys are relative to the location of the request
+type DataStoreReader interface {
+	// List list the keys stored for a request with the size of their value
+	List(ctx context.Context, flow, requestID string) ([]*DataEntry, error)
+	// Get get the value of a key stored for a request, returns nil if not found
+	Get(ctx context.Context, flow, requestID, key string) ([]byte, error)
+}
+
+var (
+	// dataStore the reader of the DataStore the flows use, nil when the
+	// data inspector is disabled
+	dataStore DataStoreReader
+)
+
+// DataItem a value stored by a node of a request
+type DataItem struct {
+	Key string `json:"key"`
+	// Option the foreach key or the condition the value is stored for
+	Option string `json:"option,omitempty"`
+	// Target the unique id of the node the value is forwarded to
+	Target string `json:"target,omitempty"`
+	Size   int    `json:"size"`
+}
+
+// NodeData the values stored by a node of a request, values set by the flow
+// through the context have no node
+type NodeData struct {
+	UniqueID string      `json:"unique-id"`
+	Node     string      `json:"node"`
+	Items    []*DataItem `json:"items"`
+}
+
+// RequestData the values stored by a request grouped by node
+type RequestData struct {
+	Flow      string      `json:"flow"`
+	RequestID string      `json:"request-id"`
+	Nodes     []*NodeData `json:"nodes"`
+}
+
+// DataPreview the decoded value of a key, formatted as json, text or a hex dump
+type DataPreview struct {
+	Key       string `json:"key"`
+	Size      int    `json:"size"`
+	Format    string `json:"format"`
+	Preview   string `json:"preview"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// openDataStoreReader create the reader of the DataStore the flows use, an
+// empty type disables the data inspector
+func openDataStoreReader(storeType, storeURL string) (DataStoreReader, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	prefix := os.Getenv("datastore_prefix")
+
+	switch storeType {
+	case "":
+		return nil, nil
+	case "redis":
+		return newRedisReader(storeURL, prefix, storeCredential("datastore-password"))
+	case "s3", "minio":
+		return newS3Reader(client, storeURL, os.Getenv("datastore_bucket"), prefix, os.Getenv("datastore_region"),
+			storeCredential("datastore-access-key"), storeCredential("datastore-secret-key"))
+	case "consul":
+		return newConsulReader(client, storeURL, prefix, storeCredential("datastore-token"))
+	}
+	return nil, fmt.Errorf("unknown datastore type %s", storeType)
+}
+
+// storeCredential read a store credential from the environment, a secret of
+// the same name takes precedence
+func storeCredential(name string) string {
+	credential := os.Getenv(strings.Replace(name, "-", "_", -1))
+	if secret, err := sdk.ReadSecret(name); err == nil {
+		credential = strings.TrimSpace(secret)
+	}
+	return credential
+}
+
+// dataLocation get the location of the values of a request, {flow} and
+// {request} are replaced by the flow name and the request id
+func dataLocation(template, flow, requestID string) string {
+	return strings.NewReplacer("{flow}", flow, "{request}", requestID).Replace(template)
+}
+
+// escapeKeyPath escape a key for a url path, the path separators are kept
+func escapeKeyPath(key string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+	return escaped.String()
+}
+
+// parseDataKey split the key of a value forwarded between two nodes, the key
+// is made of the options of the enclosing dynamic nodes, the execution unique
+// id of the node and the unique id of the node it is forwarded to
+func parseDataKey(key string) (option, node, target string) {
+	parts := strings.Split(key, "--")
+	if len(parts) < 2 {
+		return "", "", ""
+	}
+	count := len(parts)
+	return strings.Join(parts[:count-2], "--"), parts[count-2], parts[count-1]
+}
+
+// dagNodes get the nodes of the dag of a flow by unique id, the dag is not
+// required to inspect a request
+func dagNodes(flow string) map[string]*exportedNode {
+	nodes := make(map[string]*exportedNode)
+
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		return nodes
+	}
+	for _, function := range functions {
+		if function.Name != flow {
+			continue
+		}
+		export, err := getDagExport(function.Name, function.Image)
+		if err != nil {
+			log.Printf("failed to export dag of %s, error: %v", flow, err)
+			return nodes
+		}
+		dag := &exportedDag{}
+		if err := json.Unmarshal(export, dag); err != nil {
+			log.Printf("failed to parse dag of %s, error: %v", flow, err)
+			return nodes
+		}
+		dag.index(nodes)
+	}
+	return nodes
+}
+
+// nodeName get the id of a node of a dag by unique id, the unique id when the
+// node is not known
+func nodeName(nodes map[string]*exportedNode, uniqueID string) string {
+	if node, found := nodes[uniqueID]; found && node.Id != "" {
+		return node.Id
+	}
+	return uniqueID
+}
+
+// listRequestData list the values stored by a request grouped by the node
+// which stored them
+func listRequestData(ctx context.Context, flow, requestID string) (*RequestData, error) {
+	if dataStore == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: "no datastore is configured"}
+	}
+	entries, err := dataStore.List(ctx, flow, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list request data, %v", err)
+	}
+
+	dag := dagNodes(flow)
+	nodes := make(map[string]*NodeData)
+	for _, entry := range entries {
+		option, node, target := parseDataKey(entry.Key)
+		nodeData, found := nodes[node]
+		if !found {
+			nodeData = &NodeData{UniqueID: node, Node: nodeName(dag, node)}
+			if node == "" {
+				nodeData.Node = "context"
+			}
+			nodes[node] = nodeData
+		}
+		nodeData.Items = append(nodeData.Items, &DataItem{
+			Key:    entry.Key,
+			Option: option,
+			Target: target,
+			Size:   entry.Size,
+		})
+	}
+
+	data := &RequestData{Flow: flow, RequestID: requestID, Nodes: make([]*NodeData, 0, len(nodes))}
+	for _, nodeData := range nodes {
+		sort.Slice(nodeData.Items, func(i, j int) bool {
+			return nodeData.Items[i].Key < nodeData.Items[j].Key
+		})
+		data.Nodes = append(data.Nodes, nodeData)
+	}
+	// the values set through the context are listed last
+	sort.Slice(data.Nodes, func(i, j int) bool {
+		if (data.Nodes[i].UniqueID == "") != (data.Nodes[j].UniqueID == "") {
+			return data.Nodes[j].UniqueID == ""
+		}
+		return data.Nodes[i].UniqueID < data.Nodes[j].UniqueID
+	})
+	return data, nil
+}
+
+// getRequestData get the payload of a value stored by a request, the value
+// is unwrapped from the json the flow context stores it in and values
+// forwarded between nodes are decoded from base64
+func getRequestData(ctx context.Context, flow, requestID, key string) ([]byte, error) {
+	if dataStore == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: "no datastore is configured"}
+	}
+	value, err := dataStore.Get(ctx, flow, requestID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request data, %v", err)
+	}
+	if value == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("no data for key %s", key)}
+	}
+
+	wrapped := &struct {
+		Key   *string         `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}{}
+	if json.Unmarshal(value, wrapped) != nil || wrapped.Key == nil || wrapped.Value == nil {
+		return value, nil
+	}
+	if _, _, target := parseDataKey(key); target != "" {
+		encoded := ""
+		if json.Unmarshal(wrapped.Value, &encoded) == nil {
+			if payload, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				return payload, nil
+			}
+		}
+	}
+	return []byte(wrapped.Value), nil
+}
+
+// isText check if a payload is printable text
+func isText(payload []byte) bool {
+	if !utf8.Valid(payload) {
+		return false
+	}
+	for _, r := range string(payload) {
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// previewData format the start of a payload for display
+func previewData(key string, payload []byte) *DataPreview {
+	preview := &DataPreview{Key: key, Size: len(payload)}
+
+	switch {
+	case len(payload) > 0 && json.Valid(payload):
+		preview.Format = "json"
+		indented := bytes.Buffer{}
+		if json.Indent(&indented, payload, "", "  ") == nil {
+			payload = indented.Bytes()
+		}
+	case isText(payload):
+		preview.Format = "text"
+	default:
+		preview.Format = "hex"
+		if len(payload) > dataPreviewLimit {
+			preview.Truncated = true
+			payload = payload[:dataPreviewLimit]
+		}
+		preview.Preview = hex.Dump(payload)
+		return preview
+	}
+
+	if len(payload) > dataPreviewLimit {
+		preview.Truncated = true
+		payload = payload[:dataPreviewLimit]
+		// do not cut a multi byte character
+		for len(payload) > 0 && !utf8.Valid(payload) {
+			payload = payload[:len(payload)-1]
+		}
+	}
+	preview.Preview = string(payload)
+	return preview
+}
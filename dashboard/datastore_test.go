@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDataKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		option string
+		node   string
+		target string
+	}{
+		// a value set through the context
+		{"key", "", "", ""},
+		{"0_node--1_next", "", "0_node", "1_next"},
+		// a value forwarded by a foreach iteration
+		{"item1--0_node--1_next", "item1", "0_node", "1_next"},
+		// a value forwarded inside nested dynamic nodes
+		{"item1--yes--0_node--1_next", "item1--yes", "0_node", "1_next"},
+		{"", "", "", ""},
+	}
+	for _, test := range tests {
+		option, node, target := parseDataKey(test.key)
+		if option != test.option || node != test.node || target != test.target {
+			t.Errorf("%q: got %q %q %q, want %q %q %q", test.key, option, node, target,
+				test.option, test.node, test.target)
+		}
+	}
+}
+
+func TestDataLocation(t *testing.T) {
+	location := dataLocation(defaultRedisPrefix, "flow", "req1")
+	if location != "faasflow-flow-req1-" {
+		t.Errorf("got %s", location)
+	}
+}
+
+func TestEscapeKeyPath(t *testing.T) {
+	escaped := escapeKeyPath("flow/req1/a b?c%d")
+	if escaped != "flow/req1/a%20b%3Fc%25d" {
+		t.Errorf("got %s", escaped)
+	}
+}
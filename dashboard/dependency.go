@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// exportedDag the part of a flow exported dag naming the operations
+type exportedDag struct {
+	Nodes map[string]*exportedNode `json:"nodes"`
+}
+
+// exportedNode the operations and the sub dags of a node of an exported dag
+type exportedNode struct {
+	Id string `json:"id"`
+	// UniqueId identifies the node in the keys of the intermediate data and
+	// of the execution state
+	UniqueId    string `json:"unique-id"`
+	IsCondition bool   `json:"is-condition"`
+	IsForeach   bool   `json:"is-foreach"`
+	InDegree    int    `json:"in-degree"`
+	Operations  []struct {
+		Name       string              `json:"name"`
+		Properties map[string][]string `json:"properties"`
+	} `json:"operations"`
+	SubDag          *exportedDag            `json:"sub-dag"`
+	ForeachDag      *exportedDag            `json:"foreach-dag"`
+	ConditionalDags map[string]*exportedDag `json:"conditional-dags"`
+}
+
+// FlowDependencies the functions and the endpoints a flow calls
+type FlowDependencies struct {
+	Flow      string   `json:"flow"`
+	Image     string   `json:"image"`
+	Functions []string `json:"functions"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// DependencyIndex the dependencies of the flows and the reverse lookup of
+// the flows calling each function and endpoint
+type DependencyIndex struct {
+	Flows     []*FlowDependencies `json:"flows"`
+	Functions map[string][]string `json:"functions"`
+	Endpoints map[string][]string `json:"endpoints"`
+	// Incomplete flows whose dag could not be exported
+	Incomplete []string `json:"incomplete,omitempty"`
+}
+
+// Dependents the flows calling a function
+type Dependents struct {
+	Function string   `json:"function"`
+	Flows    []string `json:"flows"`
+}
+
+// collect add the operations of a dag to a set of functions and endpoints
+func (dag *exportedDag) collect(functions, endpoints map[string]bool) {
+	if dag == nil {
+		return
+	}
+	for _, node := range dag.Nodes {
+		for _, operation := range node.Operations {
+			switch {
+			case len(operation.Properties["isFunction"]) > 0 && operation.Properties["isFunction"][0] == "true":
+				functions[operation.Name] = true
+			case len(operation.Properties["isHttpRequest"]) > 0 && operation.Properties["isHttpRequest"][0] == "true":
+				endpoints[operation.Name] = true
+			}
+		}
+		node.SubDag.collect(functions, endpoints)
+		node.ForeachDag.collect(functions, endpoints)
+		for _, conditionDag := range node.ConditionalDags {
+			conditionDag.collect(functions, endpoints)
+		}
+	}
+}
+
+// index add the nodes of a dag and its sub dags by unique id
+func (dag *exportedDag) index(nodes map[string]*exportedNode) {
+	if dag == nil {
+		return
+	}
+	for _, node := range dag.Nodes {
+		if node.UniqueId != "" {
+			nodes[node.UniqueId] = node
+		}
+		node.SubDag.index(nodes)
+		node.ForeachDag.index(nodes)
+		for _, conditionDag := range node.ConditionalDags {
+			conditionDag.index(nodes)
+		}
+	}
+}
+
+// sortedKeys get the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getDagExport get the exported dag of a flow image, from its snapshot when
+// it was already taken, the export is cached per image
+func getDagExport(function, image string) (json.RawMessage, error) {
+	if snapshots != nil {
+		snapshot, err := snapshots.Get(function, image)
+		if err != nil {
+			log.Printf("failed to get dag snapshot of %s, error: %v", function, err)
+		}
+		if snapshot != nil {
+			return snapshot.Dag, nil
+		}
+	}
+	value, err := serviceCache.get("dag/"+function+"/"+image, func() (interface{}, time.Duration, error) {
+		dag, err := fetchDagExport(function)
+		return dag, dotTTL, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(json.RawMessage), nil
+}
+
+// buildFlowDependencies get the functions and the endpoints a flow calls
+func buildFlowDependencies(function *Function) (*FlowDependencies, error) {
+	data, err := getDagExport(function.Name, function.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	dag := &exportedDag{}
+	if err := json.Unmarshal(data, dag); err != nil {
+		return nil, fmt.Errorf("failed to read dag of %s, %v", function.Name, err)
+	}
+
+	functions := make(map[string]bool)
+	endpoints := make(map[string]bool)
+	dag.collect(functions, endpoints)
+
+	return &FlowDependencies{
+		Flow:      function.Name,
+		Image:     function.Image,
+		Functions: sortedKeys(functions),
+		Endpoints: sortedKeys(endpoints),
+	}, nil
+}
+
+// buildDependencyIndex index the dependencies of all the flows, flows whose
+// dag can not be exported are reported as incomplete
+func buildDependencyIndex(ctx context.Context) (*DependencyIndex, error) {
+	functions, err := listFlowFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	flows := make([]*FlowDependencies, len(functions))
+	completed := fanOut(ctx, len(functions), func(ctx context.Context, index int) error {
+		dependencies, err := buildFlowDependencies(functions[index])
+		if err != nil {
+			log.Printf("failed to get dependencies of %s, error: %v", functions[index].Name, err)
+			return err
+		}
+		flows[index] = dependencies
+		return nil
+	})
+
+	index := &DependencyIndex{
+		Flows:     make([]*FlowDependencies, 0, len(functions)),
+		Functions: make(map[string][]string),
+		Endpoints: make(map[string][]string),
+	}
+	for i, dependencies := range flows {
+		if !completed[i] || dependencies == nil {
+			index.Incomplete = append(index.Incomplete, functions[i].Name)
+			continue
+		}
+		index.Flows = append(index.Flows, dependencies)
+		for _, function := range dependencies.Functions {
+			index.Functions[function] = append(index.Functions[function], dependencies.Flow)
+		}
+		for _, endpoint := range dependencies.Endpoints {
+			index.Endpoints[endpoint] = append(index.Endpoints[endpoint], dependencies.Flow)
+		}
+	}
+	sort.Slice(index.Flows, func(i, j int) bool {
+		return index.Flows[i].Flow < index.Flows[j].Flow
+	})
+	for _, flows := range index.Functions {
+		sort.Strings(flows)
+	}
+	for _, flows := range index.Endpoints {
+		sort.Strings(flows)
+	}
+	return index, nil
+}
+
+// dependents get the flows calling a function, except the function itself
+func (index *DependencyIndex) dependents(function string) []string {
+	flows := make([]string, 0)
+	for _, flow := range index.Functions[function] {
+		if flow != function {
+			flows = append(flows, flow)
+		}
+	}
+	sort.Strings(flows)
+	return flows
+}
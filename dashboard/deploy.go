@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	flowLabel           = "faas-flow"
+	flowDescAnnotation  = "faas-flow-desc"
+	deployActionCreate  = "deploy"
+	deployActionUpdate  = "update"
+	deployActionRollout = "redeploy"
+	deployActionDelete  = "delete"
+)
+
+// validFunctionName the function names accepted by the gateway
+var validFunctionName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// redeployFields the request fields of the definition a redeploy must send
+// when the gateway does not reply them, by the name the gateway uses
+var redeployFields = map[string]string{
+	"envVars":     "env",
+	"secrets":     "secrets",
+	"constraints": "constraints",
+	"limits":      "limits",
+	"requests":    "requests",
+}
+
+// FunctionResources resource limits or requests of a function
+type FunctionResources struct {
+	Memory string `json:"memory,omitempty"`
+	CPU    string `json:"cpu,omitempty"`
+}
+
+// FunctionDeployment the function definition of the gateway deploy API
+type FunctionDeployment struct {
+	Service                string             `json:"service"`
+	Image                  string             `json:"image"`
+	EnvProcess             string             `json:"envProcess,omitempty"`
+	EnvVars                map[string]string  `json:"envVars,omitempty"`
+	Constraints            []string           `json:"constraints,omitempty"`
+	Secrets                []string           `json:"secrets,omitempty"`
+	Labels                 map[string]string  `json:"labels,omitempty"`
+	Annotations            map[string]string  `json:"annotations,omitempty"`
+	Limits                 *FunctionResources `json:"limits,omitempty"`
+	Requests               *FunctionResources `json:"requests,omitempty"`
+	ReadOnlyRootFilesystem bool               `json:"readOnlyRootFilesystem,omitempty"`
+}
+
+// DeployRequest API request to deploy a flow
+type DeployRequest struct {
+	FlowName    string            `json:"function"`
+	Image       string            `json:"image"`
+	Description string            `json:"description,omitempty"`
+	EnvVars     map[string]string `json:"env,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Secrets     []string          `json:"secrets,omitempty"`
+}
+
+// RedeployRequest API request to redeploy a flow with a new image or tag, the
+// fields the gateway does not return for the flow must be sent again, an
+// empty value removes them
+type RedeployRequest struct {
+	FlowName    string             `json:"function"`
+	Image       string             `json:"image,omitempty"`
+	Tag         string             `json:"tag,omitempty"`
+	EnvVars     map[string]string  `json:"env,omitempty"`
+	Secrets     []string           `json:"secrets,omitempty"`
+	Constraints []string           `json:"constraints,omitempty"`
+	Limits      *FunctionResources `json:"limits,omitempty"`
+	Requests    *FunctionResources `json:"requests,omitempty"`
+}
+
+// DeployResult result of a flow deployment
+type DeployResult struct {
+	Flow   string `json:"function"`
+	Image  string `json:"image"`
+	Action string `json:"action"`
+}
+
+// buildDeployment build the gateway definition of a flow, a flow always has
+// the faas-flow label so it is listed by the dashboard
+func buildDeployment(request *DeployRequest) (*FunctionDeployment, error) {
+	if !validFunctionName.MatchString(request.FlowName) {
+		return nil, fmt.Errorf("invalid flow name %q", request.FlowName)
+	}
+	if strings.TrimSpace(request.Image) == "" {
+		return nil, fmt.Errorf("no image for flow %s", request.FlowName)
+	}
+
+	deployment := &FunctionDeployment{
+		Service:     request.FlowName,
+		Image:       strings.TrimSpace(request.Image),
+		EnvVars:     request.EnvVars,
+		Secrets:     request.Secrets,
+		Labels:      make(map[string]string),
+		Annotations: make(map[string]string),
+	}
+	for key, value := range request.Labels {
+		deployment.Labels[key] = value
+	}
+	deployment.Labels[flowLabel] = "1"
+	for key, value := range request.Annotations {
+		deployment.Annotations[key] = value
+	}
+	if request.Description != "" {
+		deployment.Annotations[flowDescAnnotation] = request.Description
+	}
+	return deployment, nil
+}
+
+// replaceImageTag replace the tag or the digest of an image
+func replaceImageTag(image, tag string) string {
+	if index := strings.Index(image, "@"); index >= 0 {
+		image = image[:index]
+	}
+	// a colon before the last slash is a registry port
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		image = image[:index]
+	}
+	return image + ":" + strings.TrimPrefix(tag, ":")
+}
+
+// gatewayRequest perform an authenticated request on the gateway system API
+func gatewayRequest(method, path string, body interface{}) ([]byte, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		reqBytes, _ := json.Marshal(body)
+		reader = bytes.NewReader(reqBytes)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	c := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	httpReq, err := http.NewRequest(method, gatewayUrl+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request %v", err)
+	}
+
+	addAuthErr := sdk.AddBasicAuth(httpReq)
+	if addAuthErr != nil {
+		return nil, fmt.Errorf("basic auth error %s", addAuthErr)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	response, err := c.Do(httpReq)
+	if err != nil {
+		return nil, &FunctionError{Function: "gateway", StatusCode: http.StatusBadGateway, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	respBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, &FunctionError{Function: "gateway", StatusCode: http.StatusBadGateway, Message: err.Error()}
+	}
+	if fErr := functionError("gateway", response.StatusCode, respBody); fErr != nil {
+		return nil, fErr
+	}
+	return respBody, nil
+}
+
+// getFunctionDeployment get the deployed definition of a function and the
+// fields of the definition the gateway did not reply, depending on the
+// provider the function status lacks the env, the secrets, the constraints or
+// the resources, and they are left out when empty
+func getFunctionDeployment(functionName string) (*FunctionDeployment, []string, error) {
+	respBody, err := gatewayRequest(http.MethodGet, "system/function/"+functionName, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the gateway replies the function status where the service is the name
+	status := &struct {
+		FunctionDeployment
+		Name string `json:"name"`
+	}{}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(respBody, status); err != nil {
+		return nil, nil, fmt.Errorf("failed to read function %s, %v", functionName, err)
+	}
+	json.Unmarshal(respBody, &fields)
+
+	missing := make([]string, 0)
+	for _, field := range []string{"envVars", "secrets", "constraints", "limits", "requests"} {
+		if _, found := fields[field]; !found {
+			missing = append(missing, field)
+		}
+	}
+	deployment := &status.FunctionDeployment
+	deployment.Service = status.Name
+	return deployment, missing, nil
+}
+
+// applyRedeployFields set the fields sent with a redeploy on the deployed
+// definition, a field the gateway did not reply must be sent as the redeploy
+// would remove it otherwise
+func applyRedeployFields(deployment *FunctionDeployment, request *RedeployRequest, missing []string) error {
+	// the fields sent by the request, by the name the gateway uses
+	sent := map[string]bool{}
+	if request.EnvVars != nil {
+		deployment.EnvVars = request.EnvVars
+		sent["envVars"] = true
+	}
+	if request.Secrets != nil {
+		deployment.Secrets = request.Secrets
+		sent["secrets"] = true
+	}
+	if request.Constraints != nil {
+		deployment.Constraints = request.Constraints
+		sent["constraints"] = true
+	}
+	if request.Limits != nil {
+		deployment.Limits = request.Limits
+		if *request.Limits == (FunctionResources{}) {
+			deployment.Limits = nil
+		}
+		sent["limits"] = true
+	}
+	if request.Requests != nil {
+		deployment.Requests = request.Requests
+		if *request.Requests == (FunctionResources{}) {
+			deployment.Requests = nil
+		}
+		sent["requests"] = true
+	}
+
+	unknown := make([]string, 0)
+	for _, field := range missing {
+		if !sent[field] {
+			unknown = append(unknown, redeployFields[field])
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("the gateway does not reply the %s of flow %s, send them with the redeploy",
+			strings.Join(unknown, ", "), deployment.Service)
+	}
+	return nil
+}
+
+// deployFlowFunction create or update a flow function
+func deployFlowFunction(deployment *FunctionDeployment, update bool) error {
+	method := http.MethodPost
+	if update {
+		method = http.MethodPut
+	}
+	_, err := gatewayRequest(method, "system/functions", deployment)
+	if err != nil {
+		return err
+	}
+	serviceCache.invalidate("functions")
+	return nil
+}
+
+// isNotFound check if an error is a missing resource
+func isNotFound(err error) bool {
+	fErr, ok := err.(*FunctionError)
+	return ok && fErr.StatusCode == http.StatusNotFound
+}
+
+// recordDeploy audit a deployment or a deletion of a flow
+func recordDeploy(r *http.Request, action, flowName, detail string, err error) {
+	entry := &AuditEntry{
+		Time:    time.Now(),
+		User:    requestUser(r),
+		Action:  action,
+		Flow:    flowName,
+		Detail:  detail,
+		Success: err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.record(entry)
+}
+
+// deployFlowHandler handle api request to deploy a flow, an existing flow is
+// updated with the new definition
+func deployFlowHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg DeployRequest
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deployment, err := buildDeployment(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, _, err := getFunctionDeployment(deployment.Service)
+	if err != nil && !isNotFound(err) {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+	exists := existing != nil
+	// functions which are not flows are not managed by the dashboard
+	if exists && existing.Labels[flowLabel] == "" {
+		http.Error(w, fmt.Sprintf("function %s exists and is not a flow", deployment.Service), http.StatusConflict)
+		return
+	}
+
+	action := deployActionCreate
+	if exists {
+		action = deployActionUpdate
+	}
+	log.Printf("%s flow %s with image %s", action, deployment.Service, deployment.Image)
+
+	err = deployFlowFunction(deployment, exists)
+	recordDeploy(r, action, deployment.Service, "image "+deployment.Image, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to deploy flow, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(&DeployResult{Flow: deployment.Service, Image: deployment.Image, Action: action}, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// redeployFlowHandler handle api request to redeploy a flow with a new image
+// or image tag, the deployed definition replied by the gateway is kept and the
+// fields it does not reply must be sent with the request
+func redeployFlowHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg RedeployRequest
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFunctionName.MatchString(msg.FlowName) {
+		http.Error(w, fmt.Sprintf("invalid request, invalid flow name %q", msg.FlowName), http.StatusBadRequest)
+		return
+	}
+	if msg.Image == "" && msg.Tag == "" {
+		http.Error(w, "invalid request, no image or tag", http.StatusBadRequest)
+		return
+	}
+
+	deployment, missing, err := getFunctionDeployment(msg.FlowName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), errorStatus(err))
+		return
+	}
+	if deployment.Labels[flowLabel] == "" {
+		http.Error(w, fmt.Sprintf("%s is not a flow", msg.FlowName), http.StatusBadRequest)
+		return
+	}
+	if err := applyRedeployFields(deployment, &msg, missing); err != nil {
+		http.Error(w, fmt.Sprintf("failed to redeploy flow, %v", err), http.StatusConflict)
+		return
+	}
+
+	previousImage := deployment.Image
+	if msg.Image != "" {
+		deployment.Image = msg.Image
+	} else {
+		deployment.Image = replaceImageTag(deployment.Image, msg.Tag)
+	}
+	log.Printf("redeploy flow %s from image %s to %s", msg.FlowName, previousImage, deployment.Image)
+
+	err = deployFlowFunction(deployment, true)
+	recordDeploy(r, deployActionRollout, msg.FlowName, "image "+previousImage+" to "+deployment.Image, err)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to redeploy flow, error: %v", err), errorStatus(err))
+		return
+	}
+
+	data, _ := json.MarshalIndent(&DeployResult{Flow: msg.FlowName, Image: deployment.Image, Action: deployActionRollout}, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
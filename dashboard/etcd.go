@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultEtcdPrefix = "faasflow/{flow}/{request}/"
+)
+
+// etcdReader reads the StateStore of the flows from etcd through the json
+// gateway of the v3 api, the state of a request is stored under a common key
+// prefix
+type etcdReader struct {
+	url      string
+	prefix   string
+	username string
+	password string
+	client   *http.Client
+}
+
+// etcdRange the reply of a range request, keys and values are base64 encoded
+type etcdRange struct {
+	Kvs []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"kvs"`
+}
+
+// newEtcdReader create an etcd reader for the url of the etcd gateway
+func newEtcdReader(client *http.Client, storeURL, prefix, username, password string) (*etcdReader, error) {
+	if storeURL == "" {
+		storeURL = "http://etcd:2379/"
+	}
+	if !strings.HasSuffix(storeURL, "/") {
+		storeURL = storeURL + "/"
+	}
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+	return &etcdReader{url: storeURL, prefix: prefix, username: username, password: password, client: client}, nil
+}
+
+// prefixEnd get the end of the range of the keys with a prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// every key after the prefix
+	return "\x00"
+}
+
+// call post a json request to the gateway and decode the reply
+func (reader *etcdReader) call(ctx context.Context, path, token string, body, reply interface{}) error {
+	encoded, _ := json.Marshal(body)
+	request, _ := http.NewRequest(http.MethodPost, reader.url+path, bytes.NewReader(encoded))
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", jsonType)
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+
+	response, err := reader.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	replied, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		failure := &struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(replied, failure) == nil && (failure.Message != "" || failure.Error != "") {
+			if failure.Message == "" {
+				failure.Message = failure.Error
+			}
+			return fmt.Errorf("%s", failure.Message)
+		}
+		return fmt.Errorf("%s", http.StatusText(response.StatusCode))
+	}
+	return json.Unmarshal(replied, reply)
+}
+
+// authenticate get a token for the user, no token is needed without user
+func (reader *etcdReader) authenticate(ctx context.Context) (string, error) {
+	if reader.username == "" {
+		return "", nil
+	}
+	reply := &struct {
+		Token string `json:"token"`
+	}{}
+	err := reader.call(ctx, "v3/auth/authenticate", "", map[string]string{
+		"name":     reader.username,
+		"password": reader.password,
+	}, reply)
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate to etcd, %v", err)
+	}
+	return reply.Token, nil
+}
+
+// Read read the execution state stored for a request
+func (reader *etcdReader) Read(ctx context.Context, flow, requestID string) (map[string]string, error) {
+	token, err := reader.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := dataLocation(reader.prefix, flow, requestID)
+	reply := &etcdRange{}
+	err = reader.call(ctx, "v3/kv/range", token, map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString([]byte(prefixEnd(prefix))),
+	}, reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys of %s, %v", prefix, err)
+	}
+
+	state := make(map[string]string, len(reply.Kvs))
+	for _, kv := range reply.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key, %v", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode value of %s, %v", key, err)
+		}
+		state[strings.TrimPrefix(string(key), prefix)] = string(value)
+	}
+	return state, nil
+}
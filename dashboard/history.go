@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestRecord a request discovered by the tower
+type RequestRecord struct {
+	Flow      string    `json:"flow"`
+	RequestID string    `json:"request-id"`
+	TraceID   string    `json:"trace-id"`
+	StartTime int       `json:"start-time"`
+	Duration  int       `json:"duration"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated-at"`
+}
+
+// HistoryStore stores the history of the flow requests independent of the
+// trace server retention
+type HistoryStore interface {
+	// Put create or update a request record
+	Put(record *RequestRecord) error
+	// Get get a request record, returns nil if not found
+	Get(flow, requestID string) (*RequestRecord, error)
+	// List list the request records of a flow ordered by start time, latest
+	// first, and the total count of records for the flow
+	List(flow string, offset, limit int) ([]*RequestRecord, int, error)
+	// Close flush and close the store
+	Close() error
+}
+
+// fileHistoryStore a HistoryStore backed by an append only log file, records
+// are indexed in memory and the log is compacted when it grows
+type fileHistoryStore struct {
+	lock      sync.Mutex
+	path      string
+	file      *os.File
+	retention time.Duration
+	logSize   int
+	records   map[string]map[string]*RequestRecord
+}
+
+var (
+	history HistoryStore
+
+	// interval between two discoveries of the requests of the flows, a zero
+	// interval disables the discovery
+	historyDiscoveryInterval = time.Minute
+
+	historyDiscovery = newRequestDiscovery()
+)
+
+// openFileHistoryStore open a file history store, an empty path keeps the
+// history only in memory
+func openFileHistoryStore(path string, retention time.Duration) (*fileHistoryStore, error) {
+	store := &fileHistoryStore{
+		path:      path,
+		retention: retention,
+		records:   make(map[string]map[string]*RequestRecord),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open history, %v", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			record := &RequestRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				log.Printf("skipping invalid history record, error: %v", err)
+				continue
+			}
+			store.index(record)
+			store.logSize++
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read history, %v", err)
+		}
+	}
+
+	err = store.compact()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// index add a record to the in memory index
+func (store *fileHistoryStore) index(record *RequestRecord) {
+	requests, found := store.records[record.Flow]
+	if !found {
+		requests = make(map[string]*RequestRecord)
+		store.records[record.Flow] = requests
+	}
+	requests[record.RequestID] = record
+}
+
+// compact rewrite the log with only the latest version of the records
+// within the retention period
+func (store *fileHistoryStore) compact() error {
+	expiry := time.Now().Add(-store.retention)
+	count := 0
+	for flow, requests := range store.records {
+		for requestID, record := range requests {
+			if store.retention > 0 && record.UpdatedAt.Before(expiry) {
+				delete(requests, requestID)
+				continue
+			}
+			count++
+		}
+		if len(requests) == 0 {
+			delete(store.records, flow)
+		}
+	}
+
+	if store.path == "" {
+		return nil
+	}
+
+	if store.file != nil {
+		store.file.Close()
+		store.file = nil
+	}
+
+	tmpPath := store.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact history, %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, requests := range store.records {
+		for _, record := range requests {
+			data, _ := json.Marshal(record)
+			writer.Write(append(data, '\n'))
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact history, %v", err)
+	}
+	file.Close()
+
+	if err = os.Rename(tmpPath, store.path); err != nil {
+		return fmt.Errorf("failed to compact history, %v", err)
+	}
+	store.logSize = count
+
+	store.file, err = os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history, %v", err)
+	}
+	return nil
+}
+
+// Put create or update a request record
+func (store *fileHistoryStore) Put(record *RequestRecord) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	record.UpdatedAt = time.Now()
+	store.index(record)
+
+	if store.file == nil {
+		return nil
+	}
+
+	data, _ := json.Marshal(record)
+	_, err := store.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write history, %v", err)
+	}
+	store.logSize++
+
+	// compact once the log holds more than twice the live records
+	count := 0
+	for _, requests := range store.records {
+		count += len(requests)
+	}
+	if store.logSize > 2*count+1000 {
+		return store.compact()
+	}
+	return nil
+}
+
+// Get get a request record, returns nil if not found
+func (store *fileHistoryStore) Get(flow, requestID string) (*RequestRecord, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	record, found := store.records[flow][requestID]
+	if !found {
+		return nil, nil
+	}
+	copied := *record
+	return &copied, nil
+}
+
+// List list the request records of a flow, latest first
+func (store *fileHistoryStore) List(flow string, offset, limit int) ([]*RequestRecord, int, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	requests := store.records[flow]
+	records := make([]*RequestRecord, 0, len(requests))
+	for _, record := range requests {
+		copied := *record
+		records = append(records, &copied)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime > records[j].StartTime
+	})
+
+	total := len(records)
+	if offset > total {
+		offset = total
+	}
+	records = records[offset:]
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	return records, total, nil
+}
+
+// Close flush and close the store
+func (store *fileHistoryStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+// recordRequest save the latest known details of a request in the history,
+// known details are not overridden by missing ones
+func recordRequest(flowName string, requestID string, trace *RequestTrace) {
+	if history == nil || trace == nil {
+		return
+	}
+
+	record, err := history.Get(flowName, requestID)
+	if err != nil {
+		log.Printf("failed to get history of %s, request %s, error: %v", flowName, requestID, err)
+	}
+	if record == nil {
+		record = &RequestRecord{Flow: flowName, RequestID: requestID}
+	}
+
+	if trace.TraceId != "" {
+		record.TraceID = trace.TraceId
+	}
+	if trace.StartTime != 0 {
+		record.StartTime = trace.StartTime
+		record.Duration = trace.Duration
+	}
+	if trace.Status != "" && trace.Status != "UNKNOWN" {
+		record.Status = trace.Status
+	}
+
+	err = history.Put(record)
+	if err != nil {
+		log.Printf("failed to record history of %s, request %s, error: %v", flowName, requestID, err)
+	}
+}
+
+const (
+	// requests listed in a page by the discovery
+	discoveryPageSize = 200
+)
+
+// requestDiscovery records the requests of every flow in the history as they
+// are listed by the metrics function, so requests never viewed are kept once
+// the trace server drops them. A request is looked up on each discovery until
+// its status is final
+type requestDiscovery struct {
+	// start time of the latest discovered request of each flow
+	lastStart map[string]int
+	pending   map[string]map[string]*RequestSummary
+}
+
+// newRequestDiscovery create a discovery with no discovered request
+func newRequestDiscovery() *requestDiscovery {
+	return &requestDiscovery{
+		lastStart: make(map[string]int),
+		pending:   make(map[string]map[string]*RequestSummary),
+	}
+}
+
+// run discover the requests periodically
+func (discovery *requestDiscovery) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		discovery.discover(ctx)
+		cancel()
+		<-ticker.C
+	}
+}
+
+// listRequestsSince list all the requests of a flow started since a time,
+// the list of the metrics function is capped so the requests are paged with
+// the cursor, latest first. A failed page fails the listing so that the
+// discovery doesn't move past the requests not listed
+func listRequestsSince(ctx context.Context, flow string, start int) ([]*RequestSummary, error) {
+	query := &RequestQuery{Start: start, Limit: discoveryPageSize}
+	requests := make([]*RequestSummary, 0)
+	for {
+		page, err := listFlowRequests(ctx, flow, query)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, page.Requests...)
+		if page.NextCursor == "" {
+			return requests, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// discover list the requests started since the previous discovery and record
+// the requests which are not final yet with their trace and status
+func (discovery *requestDiscovery) discover(ctx context.Context) {
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to discover requests, error: %v", err)
+		return
+	}
+
+	now := int(time.Now().UnixNano() / 1000)
+	lookback := int(activeLookback / time.Microsecond)
+	overlap := int(activeOverlap / time.Microsecond)
+
+	listed := make([][]*RequestSummary, len(functions))
+	fanOut(ctx, len(functions), func(ctx context.Context, index int) error {
+		start := now - lookback
+		if lastStart := discovery.lastStart[functions[index].Name]; lastStart > 0 {
+			start = lastStart - overlap
+		}
+		requests, err := listRequestsSince(ctx, functions[index].Name, start)
+		if err != nil {
+			log.Printf("failed to discover requests of %s, error: %v", functions[index].Name, err)
+			return err
+		}
+		listed[index] = requests
+		return nil
+	})
+
+	type lookup struct {
+		flow    string
+		summary *RequestSummary
+	}
+	lookups := make([]lookup, 0)
+
+	pending := make(map[string]map[string]*RequestSummary, len(functions))
+	for index, function := range functions {
+		// removed flows are not discovered anymore, their history is kept
+		requests, found := discovery.pending[function.Name]
+		if !found {
+			requests = make(map[string]*RequestSummary)
+		}
+		pending[function.Name] = requests
+
+		for _, summary := range listed[index] {
+			if summary.StartTime > discovery.lastStart[function.Name] {
+				discovery.lastStart[function.Name] = summary.StartTime
+			}
+			if _, found := requests[summary.RequestID]; found {
+				requests[summary.RequestID] = summary
+				continue
+			}
+			record, err := history.Get(function.Name, summary.RequestID)
+			if err == nil && record != nil && isFinalState(record.Status) {
+				continue
+			}
+			requests[summary.RequestID] = summary
+		}
+		for _, summary := range requests {
+			lookups = append(lookups, lookup{flow: function.Name, summary: summary})
+		}
+	}
+	discovery.pending = pending
+
+	statuses := make([]string, len(lookups))
+	fanOut(ctx, len(lookups), func(ctx context.Context, index int) error {
+		trace, err := fetchRequestTrace(ctx, lookups[index].flow, lookups[index].summary)
+		statuses[index] = trace.Status
+		return err
+	})
+
+	for index, lookup := range lookups {
+		// requests not final within the lookback are not looked up anymore
+		if isFinalState(statuses[index]) || lookup.summary.StartTime < now-lookback {
+			delete(pending[lookup.flow], lookup.summary.RequestID)
+		}
+	}
+}
+
+// historyRequestTrace build a request trace from a history record
+func historyRequestTrace(record *RequestRecord) *RequestTrace {
+	status := record.Status
+	if status == "" {
+		status = "UNKNOWN"
+	}
+	return &RequestTrace{
+		RequestID: record.RequestID,
+		TraceId:   record.TraceID,
+		StartTime: record.StartTime,
+		Duration:  record.Duration,
+		Status:    status,
+	}
+}
+
+// parsePaging parse the offset and limit query parameters
+func parsePaging(r *http.Request, defaultLimit int) (int, int, error) {
+	offset, limit := 0, defaultLimit
+	var err error
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %s", value)
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("invalid limit %s", value)
+		}
+	}
+	return offset, limit, nil
+}
+
+// requestHistoryHandler handle api request to page through the request history of a flow
+func requestHistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	flowName := r.URL.Query().Get("flow-name")
+	if flowName == "" {
+		http.Error(w, "invalid request, flow-name must be specified", http.StatusBadRequest)
+		return
+	}
+
+	offset, limit, err := parsePaging(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, total, err := history.List(flowName, offset, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to handle request, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Total   int              `json:"total"`
+		Offset  int              `json:"offset"`
+		Records []*RequestRecord `json:"records"`
+	}{total, offset, records}
+
+	data, _ := json.MarshalIndent(response, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// content type of the ingested log lines
+	ndjsonType = "application/x-ndjson"
+	// maximum size of an ingested batch of log lines
+	logBatchLimit = 1 << 20
+	// maximum size of an ingested log line
+	logLineLimit = 64 * 1024
+	// minimum interval between two expirations of the logs
+	logExpireInterval = time.Minute
+)
+
+var (
+	// logs the lines the flows logged for their requests
+	logs = newLogStore(24*time.Hour, 1000, 1000)
+	// logIngestToken the bearer token the flows post their logs with, the
+	// logs are posted by an operator when no token is configured
+	logIngestToken = ""
+)
+
+// requestLog the lines logged for a request
+type requestLog struct {
+	lines   []*LogLine
+	dropped int
+	updated time.Time
+}
+
+// logStore keeps the logs of the requests in memory, the logs of a request
+// expire after the retention, a request keeps its latest lines and the least
+// recently updated requests are evicted beyond the request limit
+type logStore struct {
+	lock        sync.Mutex
+	retention   time.Duration
+	maxLines    int
+	maxRequests int
+	requests    map[string]*requestLog
+	expired     time.Time
+}
+
+// newLogStore create an empty log store
+func newLogStore(retention time.Duration, maxLines, maxRequests int) *logStore {
+	return &logStore{
+		retention:   retention,
+		maxLines:    maxLines,
+		maxRequests: maxRequests,
+		requests:    make(map[string]*requestLog),
+	}
+}
+
+// logKey get the key of the logs of a request
+func logKey(flow, requestID string) string {
+	return flow + "/" + requestID
+}
+
+// append add lines to the logs of their request, the lines beyond the line
+// limit are dropped once the batch is added
+func (store *logStore) append(lines []*LogLine) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	updated := make(map[string]*requestLog)
+	for _, line := range lines {
+		key := logKey(line.Flow, line.RequestID)
+		logged, found := store.requests[key]
+		if !found {
+			logged = &requestLog{}
+			store.requests[key] = logged
+		}
+		logged.lines = append(logged.lines, line)
+		logged.updated = now
+		updated[key] = logged
+	}
+	for _, logged := range updated {
+		if store.maxLines > 0 && len(logged.lines) > store.maxLines {
+			overflow := len(logged.lines) - store.maxLines
+			logged.lines = append([]*LogLine(nil), logged.lines[overflow:]...)
+			logged.dropped += overflow
+		}
+	}
+
+	if now.Sub(store.expired) >= logExpireInterval || store.maxRequests > 0 && len(store.requests) > store.maxRequests {
+		store.expire(now)
+	}
+}
+
+// expire remove the logs older than the retention and the least recently
+// updated requests beyond the request limit
+func (store *logStore) expire(now time.Time) {
+	for key, logged := range store.requests {
+		if store.retention > 0 && now.Sub(logged.updated) > store.retention {
+			delete(store.requests, key)
+		}
+	}
+	if store.maxRequests > 0 && len(store.requests) > store.maxRequests {
+		keys := make([]string, 0, len(store.requests))
+		for key := range store.requests {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return store.requests[keys[i]].updated.Before(store.requests[keys[j]].updated)
+		})
+		for _, key := range keys[:len(keys)-store.maxRequests] {
+			delete(store.requests, key)
+		}
+	}
+	store.expired = now
+}
+
+// query get the lines logged for a request ordered by time, only the lines
+// containing the search are returned when a search is given
+func (store *logStore) query(flow, requestID, search string) *RequestLogs {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	requestLogs := &RequestLogs{Flow: flow, RequestID: requestID, Lines: make([]*LogLine, 0)}
+	logged, found := store.requests[logKey(flow, requestID)]
+	if !found || store.retention > 0 && time.Since(logged.updated) > store.retention {
+		return requestLogs
+	}
+
+	search = strings.ToLower(search)
+	for _, line := range logged.lines {
+		if search != "" && !strings.Contains(strings.ToLower(line.Message), search) {
+			continue
+		}
+		requestLogs.Lines = append(requestLogs.Lines, &LogLine{Time: line.Time, Message: line.Message})
+	}
+	requestLogs.Total = len(logged.lines)
+	requestLogs.Dropped = logged.dropped
+
+	// lines of a batch may be posted out of order by concurrent nodes
+	sort.SliceStable(requestLogs.Lines, func(i, j int) bool {
+		return requestLogs.Lines[i].Time < requestLogs.Lines[j].Time
+	})
+	return requestLogs
+}
+
+// authorizeIngest allow the flows to post their logs with the ingest token,
+// other clients must be authenticated with the given role and post ndjson
+func authorizeIngest(role string, handler http.HandlerFunc) http.HandlerFunc {
+	authorized := authorizeContent(role, ndjsonType, handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if logIngestToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(logIngestToken)) == 1 {
+			handler(w, r)
+			return
+		}
+		authorized(w, r)
+	}
+}
+
+// ingestLogsHandler handle the log lines posted by the flows as ndjson, the
+// flow and the request of a line default to the flow-name and request-id
+// query parameters
+func ingestLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid request, logs must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	flow := r.URL.Query().Get("flow-name")
+	requestID := r.URL.Query().Get("request-id")
+	received := time.Now().UnixNano() / 1000
+
+	lines := make([]*LogLine, 0)
+	rejected := 0
+	reader := bufio.NewReaderSize(http.MaxBytesReader(w, r.Body, logBatchLimit), logLineLimit)
+	for {
+		data, tooLong, err := readLogLine(reader)
+		if err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("failed to read logs, error: %v", err), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if tooLong {
+			rejected++
+		} else if len(bytes.TrimSpace(data)) > 0 {
+			if line := parseLogLine(data, flow, requestID, received); line != nil {
+				lines = append(lines, line)
+			} else {
+				rejected++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	logs.append(lines)
+	if rejected > 0 {
+		log.Printf("rejected %d invalid log lines", rejected)
+	}
+
+	reply := struct {
+		Accepted int `json:"accepted"`
+		Rejected int `json:"rejected"`
+	}{len(lines), rejected}
+
+	data, _ := json.MarshalIndent(reply, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// readLogLine read a line of ndjson, the rest of a line longer than the
+// buffer of the reader is skipped and the line is reported as too long
+func readLogLine(reader *bufio.Reader) (line []byte, tooLong bool, err error) {
+	line, err = reader.ReadSlice('\n')
+	for err == bufio.ErrBufferFull {
+		line, tooLong = nil, true
+		_, err = reader.ReadSlice('\n')
+	}
+	return line, tooLong, err
+}
+
+// parseLogLine decode a posted log line, the flow and the request default to
+// the ones of the query, returns nil for an invalid line
+func parseLogLine(data []byte, flow, requestID string, received int64) *LogLine {
+	line := &LogLine{}
+	if err := json.Unmarshal(data, line); err != nil {
+		return nil
+	}
+	if line.Flow == "" {
+		line.Flow = flow
+	}
+	if line.RequestID == "" {
+		line.RequestID = requestID
+	}
+	if line.Flow == "" || line.RequestID == "" {
+		return nil
+	}
+	if line.Time <= 0 {
+		line.Time = int(received)
+	}
+	return line
+}
+
+// requestLogsHandler request handler for the lines logged for a request
+func requestLogsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Body == nil {
+		http.Error(w, "invalid request, no content", http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if msg.FlowName == "" || msg.RequestID == "" {
+		http.Error(w, "invalid request, function and request-id must be specified", http.StatusBadRequest)
+		return
+	}
+
+	requestLogs := logs.query(msg.FlowName, msg.RequestID, msg.Search)
+
+	data, _ := json.MarshalIndent(requestLogs, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	pageGen "html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	mockIdpPath   = "/oidc/mock"
+	mockIdpIssuer = "http://127.0.0.1:8082" + mockIdpPath
+)
+
+// mockIdentityProvider an in-process openid provider to test the oidc login
+// without a real identity provider, users log in by picking their name
+type mockIdentityProvider struct {
+	issuer   string
+	clientID string
+	kid      string
+	key      *rsa.PrivateKey
+	users    map[string]string
+
+	// redirectURL the only callback codes are issued for
+	redirectURL string
+
+	lock   sync.Mutex
+	grants map[string]*mockGrant
+}
+
+// mockGrant an authorization code issued by the mock provider
+type mockGrant struct {
+	user        string
+	redirectURI string
+	nonce       string
+	expiry      time.Time
+}
+
+var (
+	mockIdp *mockIdentityProvider
+
+	mockLoginPage = pageGen.Must(pageGen.New("mock-login").Parse(`<!DOCTYPE html>
+<html><head><title>Mock identity provider</title></head>
+<body>
+<h3>Mock identity provider</h3>
+<p>Log in to faas-flow-dashboard as</p>
+{{ range .Users }}
+<form method="get" action="">
+  {{ range $name, $values := $.Params }}{{ range $values }}<input type="hidden" name="{{ $name }}" value="{{ . }}">{{ end }}{{ end }}
+  <input type="hidden" name="login" value="{{ .Name }}">
+  <button type="submit">{{ .Name }} ({{ .Role }})</button>
+</form>
+{{ end }}
+</body></html>`))
+)
+
+// initMockIdp create the mock provider for a comma separated list of user=role,
+// codes are only issued for the redirect url of the dashboard
+func initMockIdp(users string, redirectURL string) error {
+	roles, err := parseRoles(users)
+	if err != nil {
+		return fmt.Errorf("invalid mock users, %v", err)
+	}
+	if redirectURL == "" {
+		return fmt.Errorf("oidc_redirect_url must be set for the mock identity provider")
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("failed to generate mock signing key, %v", err)
+	}
+	mockIdp = &mockIdentityProvider{
+		issuer:   mockIdpIssuer,
+		clientID: "faas-flow-dashboard",
+		kid:      randomString(8),
+		key:      key,
+		users:    roles,
+		grants:   make(map[string]*mockGrant),
+
+		redirectURL: redirectURL,
+	}
+	log.Printf("mock identity provider enabled, it must only be used for testing")
+	return nil
+}
+
+// registerHandlers serve the provider endpoints on a mux
+func (idp *mockIdentityProvider) registerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(mockIdpPath+"/.well-known/openid-configuration", idp.discoveryHandler)
+	mux.HandleFunc(mockIdpPath+"/authorize", idp.authorizeHandler)
+	mux.HandleFunc(mockIdpPath+"/token", idp.tokenHandler)
+	mux.HandleFunc(mockIdpPath+"/jwks", idp.jwksHandler)
+}
+
+// discoveryHandler serve the discovery document, the authorization endpoint
+// is opened by the browser through the gateway
+func (idp *mockIdentityProvider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	data, _ := json.MarshalIndent(&oidcProvider{
+		Issuer:                idp.issuer,
+		AuthorizationEndpoint: publicUri + mockIdpPath + "/authorize",
+		TokenEndpoint:         idp.issuer + "/token",
+		JwksUri:               idp.issuer + "/jwks",
+	}, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// authorizeHandler show the users to log in as and issue a code for the
+// chosen user
+func (idp *mockIdentityProvider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.clientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid client or response type", http.StatusBadRequest)
+		return
+	}
+	// a code is never sent to another site than the dashboard
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") != idp.redirectURL {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	user := query.Get("login")
+	if _, found := idp.users[user]; !found {
+		type mockUser struct{ Name, Role string }
+		users := make([]mockUser, 0, len(idp.users))
+		for name, role := range idp.users {
+			users = append(users, mockUser{Name: name, Role: role})
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+		query.Del("login")
+		w.Header().Set("Content-Type", htmlType)
+		err := mockLoginPage.Execute(w, map[string]interface{}{"Users": users, "Params": query})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to generate login page, error: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	code := randomString(16)
+	idp.lock.Lock()
+	idp.grants[code] = &mockGrant{
+		user:        user,
+		redirectURI: redirectURI.String(),
+		nonce:       query.Get("nonce"),
+		expiry:      time.Now().Add(time.Minute),
+	}
+	idp.lock.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// tokenError reply an oauth token error
+func tokenError(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", jsonType)
+	w.WriteHeader(http.StatusBadRequest)
+	data, _ := json.Marshal(map[string]string{"error": reason})
+	w.Write(data)
+}
+
+// tokenHandler exchange a code for a signed id token
+func (idp *mockIdentityProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	if r.PostForm.Get("client_id") != idp.clientID {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	idp.lock.Lock()
+	grant, found := idp.grants[code]
+	// a code is only valid once
+	delete(idp.grants, code)
+	idp.lock.Unlock()
+	if !found || time.Now().After(grant.expiry) || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken, err := idp.sign(map[string]interface{}{
+		"iss":                idp.issuer,
+		"sub":                grant.user,
+		"aud":                idp.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              grant.nonce,
+		"preferred_username": grant.user,
+		"roles":              []string{idp.users[grant.user]},
+	})
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
+
+// sign sign the claims of an id token
+func (idp *mockIdentityProvider) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": idp.kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwksHandler serve the signing key
+func (idp *mockIdentityProvider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := idp.key.PublicKey
+	data, _ := json.MarshalIndent(&jsonWebKeySet{Keys: []*jsonWebKey{{
+		Kty: "RSA",
+		Kid: idp.kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}}}, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
@@ -1,13 +1,24 @@
 package main
 
+import (
+	"time"
+)
+
 // Function object to retrieve and response flow-function details
 type Function struct {
-	Name            string            `json:"name"`
-	Image           string            `json:"image"`
-	InvocationCount float64           `json:"invocationCount"`
-	Replicas        uint64            `json:"replicas"`
-	Labels          map[string]string `json:"labels"`
-	Annotations     map[string]string `json:"annotations"`
+	Name              string            `json:"name"`
+	Image             string            `json:"image"`
+	InvocationCount   float64           `json:"invocationCount"`
+	Replicas          uint64            `json:"replicas"`
+	AvailableReplicas uint64            `json:"availableReplicas"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+}
+
+// isReady check if all the replicas of a flow function are available, a flow
+// scaled to zero is not ready
+func (function *Function) isReady() bool {
+	return function.AvailableReplicas > 0 && function.AvailableReplicas >= function.Replicas
 }
 
 type DashboardSpec struct {
@@ -15,6 +26,8 @@ type DashboardSpec struct {
 	ReadyFlows     int
 	TotalRequests  int
 	ActiveRequests int
+	// TotalTruncated the total is a lower bound as the list of a flow is capped
+	TotalTruncated bool
 }
 
 type Location struct {
@@ -31,19 +44,93 @@ type FlowDesc struct {
 	Labels          map[string]string `json:"labels"`
 	Annotations     map[string]string `json:"annotations"`
 	Dot             string            `json:"dot,omitempty"`
+	Lint            *LintReport       `json:"lint,omitempty"`
+	// InvocationTruncated the count is a lower bound as the list is capped
+	InvocationTruncated bool `json:"invocation-truncated,omitempty"`
+}
+
+// LintIssue a problem found in the dag of a flow
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+// LintReport the problems found in the dag of a flow by dot-generator
+type LintReport struct {
+	Flow     string       `json:"flow"`
+	Passed   bool         `json:"passed"`
+	Errors   int          `json:"errors"`
+	Warnings int          `json:"warnings"`
+	Issues   []*LintIssue `json:"issues"`
 }
 
 type FlowRequests struct {
-	Flow             string
-	TracingEnabled   bool
-	Requests         map[string]*RequestTrace
-	CurrentRequestID string
+	Flow              string
+	TracingEnabled    bool
+	DataStoreEnabled  bool
+	StateStoreEnabled bool
+	Requests          []*RequestTrace
+	CurrentRequestID  string
+
+	// Paging of the requests list, the total is a lower bound when truncated
+	Source    string
+	Query     *RequestQuery
+	Total     int
+	Truncated bool
+	PrevPage  string
+	NextPage  string
+}
+
+// RequestQuery query to list the requests of a flow, times are in microseconds
+type RequestQuery struct {
+	Start  int    `json:"start,omitempty"`
+	End    int    `json:"end,omitempty"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+	// PrevCursor lists the page before the request starting at the cursor
+	PrevCursor string `json:"prev-cursor,omitempty"`
+	Sort       string `json:"sort,omitempty"`
+	Order      string `json:"order,omitempty"`
+	// CountOnly only the total of the requests is listed
+	CountOnly bool `json:"count-only,omitempty"`
+}
+
+// RequestSummary summary of a request of a flow
+type RequestSummary struct {
+	RequestID string `json:"request-id"`
+	TraceID   string `json:"trace-id"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+}
+
+// RequestList an ordered page of the requests of a flow
+type RequestList struct {
+	Requests   []*RequestSummary `json:"requests"`
+	Total      int               `json:"total"`
+	Truncated  bool              `json:"truncated,omitempty"`
+	Offset     int               `json:"offset"`
+	NextCursor string            `json:"next-cursor,omitempty"`
+	PrevCursor string            `json:"prev-cursor,omitempty"`
+}
+
+// NodeInstance a single execution of a node for a foreach iteration or a
+// conditional branch
+type NodeInstance struct {
+	Key       string `json:"key"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
 }
 
 // NodeTrace traces of each nodes in a dag
 type NodeTrace struct {
-	StartTime int `json:"start-time"`
-	Duration  int `json:"duration"`
+	StartTime int             `json:"start-time"`
+	Duration  int             `json:"duration"`
+	Instances []*NodeInstance `json:"instances"`
 	// Other can be added based on the needs
 }
 
@@ -55,4 +142,151 @@ type RequestTrace struct {
 	StartTime  int                   `json:"start-time"`
 	Duration   int                   `json:"duration"`
 	Status     string                `json:"status"`
+	// Error the failure of the request, when reported
+	Error string `json:"error,omitempty"`
+	// Failed the request or one of its nodes failed, from the span error tags
+	Failed bool `json:"failed,omitempty"`
+}
+
+// SpanTrace a span of a request with its parent, times are in microseconds
+type SpanTrace struct {
+	SpanID    string `json:"span-id"`
+	ParentID  string `json:"parent-id,omitempty"`
+	Node      string `json:"node"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Critical  bool   `json:"critical,omitempty"`
+}
+
+// PathSegment a part of the critical path spent in a span, the request is
+// waiting when no node on the path executes
+type PathSegment struct {
+	Node      string `json:"node"`
+	SpanID    string `json:"span-id"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Waiting   bool   `json:"waiting,omitempty"`
+}
+
+// NodeContribution the time a node executes on the critical path
+type NodeContribution struct {
+	Node     string  `json:"node"`
+	Duration int     `json:"duration"`
+	Share    float64 `json:"share"`
+}
+
+// CriticalPath the chain of spans which determines the duration of a request
+type CriticalPath struct {
+	RequestID   string              `json:"request-id"`
+	TraceID     string              `json:"trace-id"`
+	StartTime   int                 `json:"start-time"`
+	Duration    int                 `json:"duration"`
+	Executing   int                 `json:"executing"`
+	Waiting     int                 `json:"waiting"`
+	Nodes       []string            `json:"nodes"`
+	Segments    []*PathSegment      `json:"segments"`
+	Bottlenecks []*NodeContribution `json:"bottlenecks"`
+	Spans       []*SpanTrace        `json:"spans"`
+}
+
+// RequestEvent a state transition of a request pushed to the stream subscribers
+type RequestEvent struct {
+	Type      string `json:"type"`
+	Node      string `json:"node,omitempty"`
+	Status    string `json:"status,omitempty"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+}
+
+// ThroughputBucket the requests of a flow started in an interval
+type ThroughputBucket struct {
+	Time     int `json:"time"`
+	Requests int `json:"requests"`
+	Failed   int `json:"failed"`
+}
+
+// LatencyPercentiles the end to end latency of the requests in microseconds
+type LatencyPercentiles struct {
+	P50 int `json:"p50"`
+	P95 int `json:"p95"`
+	P99 int `json:"p99"`
+}
+
+// NodeStatistics the durations of a node over the requests in microseconds
+type NodeStatistics struct {
+	Node    string `json:"node"`
+	Count   int    `json:"count"`
+	Average int    `json:"average"`
+	Max     int    `json:"max"`
+}
+
+// NodeCount the number of requests a node appears in
+type NodeCount struct {
+	Node  string `json:"node"`
+	Count int    `json:"count"`
+}
+
+// FlowAnalytics the aggregated statistics of the requests of a flow started
+// within a window, as computed by the metrics function
+type FlowAnalytics struct {
+	Flow            string              `json:"flow"`
+	Window          string              `json:"window,omitempty"`
+	Start           int                 `json:"start"`
+	End             int                 `json:"end"`
+	Requests        int                 `json:"requests"`
+	Failed          int                 `json:"failed"`
+	FailureRate     float64             `json:"failure-rate"`
+	Throughput      []*ThroughputBucket `json:"throughput"`
+	Latency         *LatencyPercentiles `json:"latency"`
+	SlowestNodes    []*NodeStatistics   `json:"slowest-nodes"`
+	FailedLastNodes []*NodeCount        `json:"failed-last-nodes"`
+	Truncated       bool                `json:"truncated,omitempty"`
+}
+
+// StuckRequest a running request of a flow with no span ended within the
+// stuck threshold of the flow, times are in microseconds
+type StuckRequest struct {
+	Flow         string `json:"flow"`
+	RequestID    string `json:"request-id"`
+	TraceID      string `json:"trace-id"`
+	StartTime    int    `json:"start-time"`
+	LastActivity int    `json:"last-activity"`
+	Inactive     int    `json:"inactive"`
+	Threshold    int    `json:"threshold"`
+	// Orphaned the trace server has no trace of the request
+	Orphaned bool `json:"orphaned,omitempty"`
+	// Action the remediation of the flow, stop or alert, and its outcome
+	Action      string    `json:"action,omitempty"`
+	Remediation string    `json:"remediation,omitempty"`
+	DetectedAt  time.Time `json:"detected-at"`
+}
+
+// InactiveFor get the inactivity of the request rounded to the second
+func (request *StuckRequest) InactiveFor() time.Duration {
+	return (time.Duration(request.Inactive) * time.Microsecond).Round(time.Second)
+}
+
+// StuckRequests the stuck requests found by the last sweep
+type StuckRequests struct {
+	SweptAt  *time.Time      `json:"swept-at,omitempty"`
+	Requests []*StuckRequest `json:"requests"`
+}
+
+// LogLine a line logged by a flow while executing a request, the time is in
+// microseconds
+type LogLine struct {
+	Flow      string `json:"flow,omitempty"`
+	RequestID string `json:"request-id,omitempty"`
+	Time      int    `json:"time"`
+	Message   string `json:"message"`
+}
+
+// RequestLogs the lines logged for a request ordered by time, the oldest
+// lines beyond the limit of a request are dropped
+type RequestLogs struct {
+	Flow      string     `json:"flow"`
+	RequestID string     `json:"request-id"`
+	Total     int        `json:"total"`
+	Dropped   int        `json:"dropped,omitempty"`
+	Lines     []*LogLine `json:"lines"`
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcProvider the endpoints of an openid provider discovery document
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []*jsonWebKey `json:"keys"`
+}
+
+// idTokenClaims the claims of an id token
+type idTokenClaims map[string]interface{}
+
+// oidcClient an openid connect relying party using the authorization code flow
+type oidcClient struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	roleClaim    string
+
+	lock     sync.Mutex
+	provider *oidcProvider
+	keys     map[string]*rsa.PublicKey
+}
+
+// newOidcClient create the oidc client from the environment
+func newOidcClient() (*oidcClient, error) {
+	client := &oidcClient{
+		issuer:      strings.TrimSuffix(os.Getenv("oidc_issuer"), "/"),
+		clientID:    os.Getenv("oidc_client_id"),
+		redirectURL: os.Getenv("oidc_redirect_url"),
+		scopes:      os.Getenv("oidc_scopes"),
+		roleClaim:   os.Getenv("oidc_role_claim"),
+		keys:        make(map[string]*rsa.PublicKey),
+	}
+
+	client.clientSecret = os.Getenv("oidc_client_secret")
+	if secret, err := sdk.ReadSecret("oidc-client-secret"); err == nil {
+		client.clientSecret = strings.TrimSpace(secret)
+	}
+
+	if mockIdp != nil {
+		if client.issuer == "" {
+			client.issuer = mockIdp.issuer
+		}
+		if client.clientID == "" {
+			client.clientID = mockIdp.clientID
+		}
+	}
+
+	if client.issuer == "" {
+		return nil, fmt.Errorf("oidc_issuer is not set")
+	}
+	if client.clientID == "" {
+		return nil, fmt.Errorf("oidc_client_id is not set")
+	}
+	if client.scopes == "" {
+		client.scopes = "openid profile email"
+	}
+	if client.roleClaim == "" {
+		client.roleClaim = "roles"
+	}
+	return client, nil
+}
+
+// getJson get a json document
+func getJson(ctx context.Context, url string, value interface{}) error {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d, %s", response.StatusCode, bodyBytes)
+	}
+	return json.Unmarshal(bodyBytes, value)
+}
+
+// discover get the provider endpoints, the discovery is retried until it
+// succeeds as the provider may not be up when the dashboard starts
+func (client *oidcClient) discover(ctx context.Context) (*oidcProvider, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.provider != nil {
+		return client.provider, nil
+	}
+	provider := &oidcProvider{}
+	err := getJson(ctx, client.issuer+"/.well-known/openid-configuration", provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s, %v", client.issuer, err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != client.issuer {
+		return nil, fmt.Errorf("issuer mismatch, expected %s, got %s", client.issuer, provider.Issuer)
+	}
+	client.provider = provider
+	return provider, nil
+}
+
+// authCodeURL get the authorization endpoint of the provider
+func (client *oidcClient) authCodeURL() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	provider, err := client.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return provider.AuthorizationEndpoint, nil
+}
+
+// exchange exchange an authorization code for the verified id token claims
+func (client *oidcClient) exchange(ctx context.Context, code, redirectURL, nonce string) (idTokenClaims, error) {
+	if code == "" {
+		return nil, fmt.Errorf("no authorization code")
+	}
+	provider, err := client.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("client_id", client.clientID)
+	form.Set("client_secret", client.clientSecret)
+
+	request, _ := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token, %v", err)
+	}
+	defer response.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token, %v", err)
+	}
+
+	token := &struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, token); err != nil {
+		return nil, fmt.Errorf("failed to get token, status code %d, %s", response.StatusCode, bodyBytes)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("failed to get token, %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IdToken == "" {
+		return nil, fmt.Errorf("failed to get token, no id token in the reply")
+	}
+	return client.verify(ctx, token.IdToken, nonce)
+}
+
+// publicKey get a signing key of the provider, the key set is reloaded for an
+// unknown key so rotated keys are picked up
+func (client *oidcClient) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	client.lock.Lock()
+	key, found := client.keys[kid]
+	client.lock.Unlock()
+	if found {
+		return key, nil
+	}
+
+	provider, err := client.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keySet := &jsonWebKeySet{}
+	if err := getJson(ctx, provider.JwksUri, keySet); err != nil {
+		return nil, fmt.Errorf("failed to get signing keys, %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, webKey := range keySet.Keys {
+		if webKey.Kty != "RSA" {
+			continue
+		}
+		n, nErr := base64.RawURLEncoding.DecodeString(webKey.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(webKey.E)
+		if nErr != nil || eErr != nil {
+			continue
+		}
+		keys[webKey.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	client.lock.Lock()
+	client.keys = keys
+	client.lock.Unlock()
+
+	key, found = keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return key, nil
+}
+
+// verify verify the signature and the claims of an id token
+func (client *oidcClient) verify(ctx context.Context, idToken, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id token")
+	}
+
+	header := &struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerBytes, header) != nil {
+		return nil, fmt.Errorf("malformed id token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id token algorithm %s", header.Alg)
+	}
+
+	key, err := client.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id token signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid id token signature")
+	}
+
+	claims := idTokenClaims{}
+	claimBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimBytes, &claims) != nil {
+		return nil, fmt.Errorf("malformed id token claims")
+	}
+
+	if strings.TrimSuffix(claims.string("iss"), "/") != client.issuer {
+		return nil, fmt.Errorf("invalid id token issuer %s", claims.string("iss"))
+	}
+	if !claims.has("aud", client.clientID) {
+		return nil, fmt.Errorf("id token is not issued for %s", client.clientID)
+	}
+	expiry, _ := claims["exp"].(float64)
+	// allow a small clock skew with the provider
+	if time.Now().Add(-time.Minute).Unix() > int64(expiry) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if claims.string("nonce") != nonce {
+		return nil, fmt.Errorf("invalid id token nonce")
+	}
+	return claims, nil
+}
+
+// string get a string claim
+func (claims idTokenClaims) string(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// values get a claim which is either a string or a list of strings
+func (claims idTokenClaims) values(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// has check if a claim contains a value
+func (claims idTokenClaims) has(name, expected string) bool {
+	for _, value := range claims.values(name) {
+		if value == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// userName get the name of the user, the email is preferred as it is the
+// name roles are usually configured with
+func (claims idTokenClaims) userName() string {
+	for _, name := range []string{"email", "preferred_username", "sub"} {
+		if value := claims.string(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// role get the highest dashboard role given by a claim
+func (claims idTokenClaims) role(name string) string {
+	role := ""
+	for _, value := range claims.values(name) {
+		if roleRanks[value] > roleRanks[role] {
+			role = value
+		}
+	}
+	return role
+}
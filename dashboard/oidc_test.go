@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRedirectURL = "http://dashboard/function/faas-flow-dashboard/auth/callback"
+
+// newTestMockIdp serve a mock provider with its own key on a test server
+func newTestMockIdp(t *testing.T, users map[string]string) (*mockIdentityProvider, *httptest.Server) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key, %v", err)
+	}
+	idp := &mockIdentityProvider{
+		clientID:    "faas-flow-dashboard",
+		kid:         "kid",
+		key:         key,
+		users:       users,
+		grants:      make(map[string]*mockGrant),
+		redirectURL: testRedirectURL,
+	}
+	mux := http.NewServeMux()
+	idp.registerHandlers(mux)
+	server := httptest.NewServer(mux)
+	idp.issuer = server.URL + mockIdpPath
+	return idp, server
+}
+
+// newTestOidcClient create a client of the mock provider
+func newTestOidcClient(idp *mockIdentityProvider) *oidcClient {
+	return &oidcClient{
+		issuer:    idp.issuer,
+		clientID:  idp.clientID,
+		roleClaim: "roles",
+		keys:      make(map[string]*rsa.PublicKey),
+	}
+}
+
+// authorizeCode get a code of the mock provider for a user
+func authorizeCode(t *testing.T, server *httptest.Server, user, redirectURL, nonce string) (*http.Response, string) {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "faas-flow-dashboard")
+	params.Set("redirect_uri", redirectURL)
+	params.Set("state", "state")
+	params.Set("nonce", nonce)
+	params.Set("login", user)
+
+	client := server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	response, err := client.Get(server.URL + mockIdpPath + "/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatalf("failed to authorize, %v", err)
+	}
+	response.Body.Close()
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid location, %v", err)
+	}
+	return response, location.Query().Get("code")
+}
+
+func TestMockIdpLogin(t *testing.T) {
+	idp, server := newTestMockIdp(t, map[string]string{"alice": roleAdmin})
+	defer server.Close()
+	client := newTestOidcClient(idp)
+
+	response, code := authorizeCode(t, server, "alice", testRedirectURL, "nonce")
+	if response.StatusCode != http.StatusFound || code == "" {
+		t.Fatalf("got status %d and code %q, want a redirect with a code", response.StatusCode, code)
+	}
+	if location := response.Header.Get("Location"); !strings.HasPrefix(location, testRedirectURL+"?") {
+		t.Errorf("redirected to %s, want %s", location, testRedirectURL)
+	}
+
+	claims, err := client.exchange(context.Background(), code, testRedirectURL, "nonce")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if claims.userName() != "alice" || !claims.has("roles", roleAdmin) {
+		t.Errorf("got claims %v", claims)
+	}
+
+	// a code is only valid once
+	if _, err := client.exchange(context.Background(), code, testRedirectURL, "nonce"); err == nil {
+		t.Errorf("expected an error for a reused code")
+	}
+}
+
+func TestMockIdpAuthorizeRedirect(t *testing.T) {
+	_, server := newTestMockIdp(t, map[string]string{"alice": roleAdmin})
+	defer server.Close()
+
+	tests := []string{
+		"",
+		"http://attacker/function/faas-flow-dashboard/auth/callback",
+		testRedirectURL + "?next=/",
+		"http://dashboard/function/faas-flow-dashboard/auth/callback/",
+	}
+	for _, redirectURL := range tests {
+		response, code := authorizeCode(t, server, "alice", redirectURL, "nonce")
+		if response.StatusCode != http.StatusBadRequest || code != "" {
+			t.Errorf("%q: got status %d and code %q, want %d", redirectURL, response.StatusCode, code,
+				http.StatusBadRequest)
+		}
+	}
+}
+
+func TestOidcVerify(t *testing.T) {
+	idp, server := newTestMockIdp(t, map[string]string{"alice": roleAdmin})
+	defer server.Close()
+	other, otherServer := newTestMockIdp(t, map[string]string{"alice": roleAdmin})
+	defer otherServer.Close()
+
+	claims := func(update func(map[string]interface{})) map[string]interface{} {
+		values := map[string]interface{}{
+			"iss":   idp.issuer,
+			"sub":   "alice",
+			"aud":   idp.clientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+		if update != nil {
+			update(values)
+		}
+		return values
+	}
+
+	tests := []struct {
+		name   string
+		signer *mockIdentityProvider
+		claims map[string]interface{}
+		token  string
+		// err the expected error, none when empty
+		err string
+	}{
+		{name: "valid", signer: idp, claims: claims(nil)},
+		{name: "audience list", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["aud"] = []string{"other", idp.clientID}
+		})},
+		{name: "clock skew", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["exp"] = time.Now().Add(-30 * time.Second).Unix()
+		})},
+		{name: "malformed", token: "invalid", err: "malformed id token"},
+		{name: "bad signature", signer: other, claims: claims(nil), err: "invalid id token signature"},
+		{name: "wrong issuer", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["iss"] = other.issuer
+		}), err: "invalid id token issuer"},
+		{name: "wrong audience", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["aud"] = "other"
+		}), err: "id token is not issued for"},
+		{name: "expired", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+		}), err: "id token expired"},
+		{name: "nonce mismatch", signer: idp, claims: claims(func(values map[string]interface{}) {
+			values["nonce"] = "other"
+		}), err: "invalid id token nonce"},
+	}
+
+	client := newTestOidcClient(idp)
+	for _, test := range tests {
+		token := test.token
+		if test.signer != nil {
+			var err error
+			token, err = test.signer.sign(test.claims)
+			if err != nil {
+				t.Fatalf("%s: failed to sign, %v", test.name, err)
+			}
+		}
+		_, err := client.verify(context.Background(), token, "nonce")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// maximum concurrent upstream lookups of a page
+	fanOutWorkers = 8
+	// deadline to build a page, lookups not completed are left partial
+	pageTimeout = 5 * time.Second
+)
+
+// fanOut run task for each index in [0, count) with at most fanOutWorkers
+// running at a time, it returns once all the started tasks are completed.
+// Tasks are not started once the context is done and are reported as not
+// completed
+func fanOut(ctx context.Context, count int, task func(ctx context.Context, index int) error) []bool {
+	completed := make([]bool, count)
+
+	workers := fanOutWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if ctx.Err() != nil {
+					continue
+				}
+				completed[index] = task(ctx, index) == nil && ctx.Err() == nil
+			}
+		}()
+	}
+
+	for index := 0; index < count; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return completed
+}
+
+// pageContext create the context bounding the upstream lookups of a page
+func pageContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, pageTimeout)
+}
+
+// countIncomplete count the tasks not completed by fanOut
+func countIncomplete(completed []bool) int {
+	count := 0
+	for _, done := range completed {
+		if !done {
+			count++
+		}
+	}
+	return count
+}
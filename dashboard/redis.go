@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRedisPrefix = "faasflow-{flow}-{request}-"
+	// keys returned by each scan of the redis keyspace
+	redisScanCount = 100
+)
+
+// redisReader reads the DataStore of the flows from redis, the values of a
+// request are stored as strings under a common key prefix
+type redisReader struct {
+	address  string
+	password string
+	database int
+	prefix   string
+	timeout  time.Duration
+	// dial connect to redis, replaced to read from a fake server
+	dial func(ctx context.Context, address string) (net.Conn, error)
+}
+
+// redisConn a connection speaking the redis protocol
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError an error replied by redis
+type redisError string
+
+func (err redisError) Error() string {
+	return string(err)
+}
+
+// newRedisReader create a redis reader from an url as redis://host:port/db,
+// the password of the url takes precedence
+func newRedisReader(storeURL, prefix, password string) (*redisReader, error) {
+	if storeURL == "" {
+		storeURL = "redis://redis:6379/0"
+	}
+	if !strings.Contains(storeURL, "://") {
+		storeURL = "redis://" + storeURL
+	}
+	parsed, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url %s, %v", storeURL, err)
+	}
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+
+	reader := &redisReader{
+		address:  parsed.Host,
+		password: password,
+		prefix:   prefix,
+		timeout:  30 * time.Second,
+	}
+	if parsed.Port() == "" {
+		reader.address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if urlPassword, found := parsed.User.Password(); found {
+		reader.password = urlPassword
+	}
+	if database := strings.Trim(parsed.Path, "/"); database != "" {
+		reader.database, err = strconv.Atoi(database)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %s", database)
+		}
+	}
+	reader.dial = func(ctx context.Context, address string) (net.Conn, error) {
+		dialer := &net.Dialer{}
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	return reader, nil
+}
+
+// connect open a connection, authenticate and select the database
+func (reader *redisReader) connect(ctx context.Context) (*redisConn, error) {
+	conn, err := reader.dial(ctx, reader.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis, %v", err)
+	}
+	deadline, found := ctx.Deadline()
+	if !found {
+		deadline = time.Now().Add(reader.timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	redis := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if reader.password != "" {
+		if _, err := redis.do("AUTH", reader.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate to redis, %v", err)
+		}
+	}
+	if reader.database != 0 {
+		if _, err := redis.do("SELECT", strconv.Itoa(reader.database)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to select redis database %d, %v", reader.database, err)
+		}
+	}
+	return redis, nil
+}
+
+// do send a command and read its reply
+func (redis *redisConn) do(args ...string) (interface{}, error) {
+	command := strings.Builder{}
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(redis.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return redis.readReply()
+}
+
+// readReply read a reply, bulk strings are read as bytes and missing values
+// as nil
+func (redis *redisConn) readReply() (interface{}, error) {
+	line, err := redis.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("invalid redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %s", line)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(redis.reader, value); err != nil {
+			return nil, err
+		}
+		return value[:length], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %s", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, count)
+		for i := range values {
+			values[i], err = redis.readReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %s", line)
+}
+
+// escapeGlob escape the glob characters of a redis key pattern
+func escapeGlob(pattern string) string {
+	escaped := strings.Builder{}
+	for _, r := range pattern {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// List list the keys stored for a request by scanning its prefix
+func (reader *redisReader) List(ctx context.Context, flow, requestID string) ([]*DataEntry, error) {
+	redis, err := reader.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer redis.conn.Close()
+
+	prefix := dataLocation(reader.prefix, flow, requestID)
+	pattern := escapeGlob(prefix) + "*"
+
+	// a key may be returned by more than one scan
+	keys := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := redis.do("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan redis, %v", err)
+		}
+		scan, ok := reply.([]interface{})
+		if !ok || len(scan) != 2 {
+			return nil, fmt.Errorf("failed to scan redis, invalid reply")
+		}
+		next, _ := scan[0].([]byte)
+		found, _ := scan[1].([]interface{})
+		for _, key := range found {
+			if key, ok := key.([]byte); ok {
+				keys[string(key)] = true
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	entries := make([]*DataEntry, 0, len(keys))
+	for key := range keys {
+		reply, err := redis.do("STRLEN", key)
+		if _, wrongType := err.(redisError); wrongType {
+			// only string values are stored by the datastore
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get size of %s, %v", key, err)
+		}
+		size, _ := reply.(int64)
+		entries = append(entries, &DataEntry{Key: strings.TrimPrefix(key, prefix), Size: int(size)})
+	}
+	return entries, nil
+}
+
+// Get get the value of a key stored for a request
+func (reader *redisReader) Get(ctx context.Context, flow, requestID, key string) ([]byte, error) {
+	redis, err := reader.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer redis.conn.Close()
+
+	reply, err := redis.do("GET", dataLocation(reader.prefix, flow, requestID)+key)
+	if _, wrongType := err.(redisError); wrongType {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s, %v", key, err)
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
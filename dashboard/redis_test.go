@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis a redis server replying from a map of string values, keys of
+// the lists are values of another type
+type fakeRedis struct {
+	password string
+	values   map[string]string
+	lists    map[string]bool
+	// keys returned by each scan
+	scanCount int
+	commands  []string
+}
+
+// dial serve a connection of the fake server through a pipe
+func (server *fakeRedis) dial(ctx context.Context, address string) (net.Conn, error) {
+	client, conn := net.Pipe()
+	go server.serve(conn)
+	return client, nil
+}
+
+// readCommand read a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	readLine := func(kind byte) (int, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || line[0] != kind {
+			return 0, fmt.Errorf("invalid command %q", line)
+		}
+		return strconv.Atoi(line[1:])
+	}
+
+	count, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		length, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:length])
+	}
+	return args, nil
+}
+
+// bulk encode a bulk string
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (server *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := server.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		server.commands = append(server.commands, args[0])
+
+		reply := ""
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == server.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-ERR invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "SCAN":
+			reply = server.scan(args[1], strings.TrimSuffix(args[3], "*"))
+		case args[0] == "STRLEN" || args[0] == "GET":
+			value, found := server.values[args[1]]
+			switch {
+			case server.lists[args[1]]:
+				reply = "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+			case !found && args[0] == "GET":
+				reply = "$-1\r\n"
+			case args[0] == "GET":
+				reply = bulk(value)
+			default:
+				reply = fmt.Sprintf(":%d\r\n", len(value))
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// scan reply a page of the keys starting with a prefix, the cursor is the
+// offset of the page in the sorted keys
+func (server *fakeRedis) scan(cursor, prefix string) string {
+	keys := make([]string, 0)
+	for key := range server.values {
+		keys = append(keys, key)
+	}
+	for key := range server.lists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	offset, _ := strconv.Atoi(cursor)
+	end := offset + server.scanCount
+	next := strconv.Itoa(end)
+	if end >= len(keys) {
+		end = len(keys)
+		next = "0"
+	}
+	found := make([]string, 0)
+	for _, key := range keys[offset:end] {
+		if strings.HasPrefix(key, strings.Replace(prefix, "\\", "", -1)) {
+			found = append(found, bulk(key))
+		}
+	}
+	return fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk(next), len(found), strings.Join(found, ""))
+}
+
+func newFakeRedisReader(t *testing.T, server *fakeRedis, storeURL string) *redisReader {
+	reader, err := newRedisReader(storeURL, "", "")
+	if err != nil {
+		t.Fatalf("failed to create redis reader, %v", err)
+	}
+	reader.dial = server.dial
+	return reader
+}
+
+func TestNewRedisReader(t *testing.T) {
+	tests := []struct {
+		url      string
+		address  string
+		password string
+		database int
+	}{
+		{"", "redis:6379", "", 0},
+		{"localhost", "localhost:6379", "", 0},
+		{"redis://:secret@store:6380/2", "store:6380", "secret", 2},
+	}
+	for _, test := range tests {
+		reader, err := newRedisReader(test.url, "", "")
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.url, err)
+			continue
+		}
+		if reader.address != test.address || reader.password != test.password || reader.database != test.database {
+			t.Errorf("%q: got %s %q %d, want %s %q %d", test.url, reader.address, reader.password,
+				reader.database, test.address, test.password, test.database)
+		}
+		if reader.prefix != defaultRedisPrefix {
+			t.Errorf("%q: got prefix %s, want %s", test.url, reader.prefix, defaultRedisPrefix)
+		}
+	}
+
+	if _, err := newRedisReader("redis://store/db", "", ""); err == nil {
+		t.Errorf("expected an error for an invalid database")
+	}
+}
+
+func TestRedisReaderList(t *testing.T) {
+	server := &fakeRedis{
+		password: "secret",
+		values: map[string]string{
+			"faasflow-flow-req1-key":                   "value",
+			"faasflow-flow-req1-0--node--next":         "forwarded",
+			"faasflow-flow-req1-1--node--next":         "forwarded value",
+			"faasflow-flow-req2-key":                   "other request",
+			"faasflow-other-req1-key":                  "other flow",
+			"faasflow-flow-req1-nested-dynamic--node2": "",
+		},
+		lists:     map[string]bool{"faasflow-flow-req1-list": true},
+		scanCount: 2,
+	}
+	reader := newFakeRedisReader(t, server, "redis://:secret@store/3")
+
+	entries, err := reader.List(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sizes := make(map[string]int)
+	for _, entry := range entries {
+		sizes[entry.Key] = entry.Size
+	}
+	expected := map[string]int{
+		"key":                   5,
+		"0--node--next":         9,
+		"1--node--next":         15,
+		"nested-dynamic--node2": 0,
+	}
+	if len(sizes) != len(expected) {
+		t.Errorf("got entries %v, want %v", sizes, expected)
+	}
+	for key, size := range expected {
+		if got, found := sizes[key]; !found || got != size {
+			t.Errorf("key %s: got size %d (found %v), want %d", key, got, found, size)
+		}
+	}
+
+	scans := 0
+	for _, command := range server.commands {
+		if command == "SCAN" {
+			scans++
+		}
+	}
+	// the 7 keys are scanned 2 by 2
+	if scans != 4 {
+		t.Errorf("got %d scans, want 4", scans)
+	}
+	if server.commands[0] != "AUTH" || server.commands[1] != "SELECT" {
+		t.Errorf("got commands %v, want AUTH and SELECT first", server.commands)
+	}
+}
+
+func TestRedisReaderListAuthFailure(t *testing.T) {
+	server := &fakeRedis{password: "secret", scanCount: 10}
+	reader := newFakeRedisReader(t, server, "redis://:wrong@store")
+
+	if _, err := reader.List(context.Background(), "flow", "req1"); err == nil {
+		t.Errorf("expected an authentication error")
+	}
+}
+
+func TestRedisReaderGet(t *testing.T) {
+	server := &fakeRedis{
+		values: map[string]string{"faasflow-flow-req1-key": "value"},
+		lists:  map[string]bool{"faasflow-flow-req1-list": true},
+	}
+	reader := newFakeRedisReader(t, server, "")
+
+	tests := []struct {
+		key   string
+		value []byte
+	}{
+		{"key", []byte("value")},
+		{"missing", nil},
+		{"list", nil},
+	}
+	for _, test := range tests {
+		value, err := reader.Get(context.Background(), "flow", "req1", test.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.key, err)
+			continue
+		}
+		if string(value) != string(test.value) || (value == nil) != (test.value == nil) {
+			t.Errorf("%s: got %q, want %q", test.key, value, test.value)
+		}
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	escaped := escapeGlob("flow-[a]*?\\")
+	if escaped != "flow-\\[a\\]\\*\\?\\\\" {
+		t.Errorf("got %s", escaped)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Bucket = "faasflow-{flow}-{request}"
+	defaultS3Region = "us-east-1"
+	// sha256 of an empty payload, the reader only sends requests without body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Reader reads the DataStore of the flows from an S3 compatible object
+// store such as minio, the values of a request are the objects of a bucket
+// under an optional prefix
+type s3Reader struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// s3ListResult the reply of the ListObjectsV2 api
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Error the error replied by the object store
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// newS3Reader create an s3 reader, buckets are addressed in the path so that
+// any S3 compatible endpoint works
+func newS3Reader(client *http.Client, storeURL, bucket, prefix, region, accessKey, secretKey string) (*s3Reader, error) {
+	if storeURL == "" {
+		storeURL = "http://minio:9000/"
+	}
+	endpoint, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 url %s, %v", storeURL, err)
+	}
+	if bucket == "" {
+		bucket = defaultS3Bucket
+	}
+	if region == "" {
+		region = defaultS3Region
+	}
+	return &s3Reader{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    client,
+	}, nil
+}
+
+// newRequest create a signed request for an object path of a bucket
+func (reader *s3Reader) newRequest(ctx context.Context, bucket, object string, query url.Values) *http.Request {
+	location := *reader.endpoint
+	path := strings.TrimRight(location.Path, "/") + "/" + bucket + "/" + object
+	location.Path = path
+	location.RawPath = escapeKeyPath(path)
+	// s3 expects spaces of the query encoded as %20
+	location.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	request, _ := http.NewRequest(http.MethodGet, location.String(), nil)
+	request = request.WithContext(ctx)
+	if reader.accessKey != "" {
+		reader.sign(request, time.Now().UTC())
+	}
+	return request
+}
+
+// hmacSHA256 compute the hmac of a value
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// sign sign a request without body with the AWS signature version 4
+func (reader *s3Reader) sign(request *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+
+	headers := map[string]string{
+		"host":                 request.URL.Host,
+		"x-amz-content-sha256": emptyPayloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + reader.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+reader.secretKey), date)
+	key = hmacSHA256(key, reader.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		reader.accessKey, scope, signedHeaders, signature))
+}
+
+// query perform a request, a missing bucket or object is replied as nil
+func (reader *s3Reader) query(request *http.Request) ([]byte, error) {
+	response, err := reader.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		replied := &s3Error{}
+		if xml.Unmarshal(body, replied) == nil && replied.Code != "" {
+			return nil, fmt.Errorf("%s: %s", replied.Code, replied.Message)
+		}
+		return nil, fmt.Errorf("%s", http.StatusText(response.StatusCode))
+	}
+	return body, nil
+}
+
+// List list the objects stored for a request
+func (reader *s3Reader) List(ctx context.Context, flow, requestID string) ([]*DataEntry, error) {
+	bucket := dataLocation(reader.bucket, flow, requestID)
+	prefix := dataLocation(reader.prefix, flow, requestID)
+
+	entries := make([]*DataEntry, 0)
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := reader.query(reader.newRequest(ctx, bucket, "", query))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of %s, %v", bucket, err)
+		}
+		if body == nil {
+			// the bucket is created with the first value of the request
+			return entries, nil
+		}
+
+		result := &s3ListResult{}
+		if err := xml.Unmarshal(body, result); err != nil {
+			return nil, fmt.Errorf("failed to list objects of %s, %v", bucket, err)
+		}
+		for _, object := range result.Contents {
+			entries = append(entries, &DataEntry{Key: strings.TrimPrefix(object.Key, prefix), Size: object.Size})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return entries, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get get an object stored for a request
+func (reader *s3Reader) Get(ctx context.Context, flow, requestID, key string) ([]byte, error) {
+	bucket := dataLocation(reader.bucket, flow, requestID)
+	object := dataLocation(reader.prefix, flow, requestID) + key
+
+	body, err := reader.query(reader.newRequest(ctx, bucket, object, url.Values{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s of %s, %v", object, bucket, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeS3 an object store serving the objects of its buckets, the listing is
+// paginated by the page size
+type fakeS3 struct {
+	objects  map[string]map[string]string
+	pageSize int
+	lists    int
+	signed   bool
+}
+
+func (store *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		store.signed = true
+	}
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	objects, found := store.objects[path[0]]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code><Message>The bucket does not exist</Message></Error>")
+		return
+	}
+	if path[0] == "denied" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+
+	if len(path) == 2 && path[1] != "" {
+		value, found := objects[path[1]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, value)
+		return
+	}
+
+	store.lists++
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	keys := make([]string, 0)
+	for key := range objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	offset, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := offset + store.pageSize
+	truncated := end < len(keys)
+	if !truncated {
+		end = len(keys)
+	}
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys[offset:end] {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(objects[key]))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%v</IsTruncated>", truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func newFakeS3Reader(t *testing.T, server *httptest.Server, bucket, prefix string) *s3Reader {
+	reader, err := newS3Reader(server.Client(), server.URL, bucket, prefix, "", "access", "secret")
+	if err != nil {
+		t.Fatalf("failed to create s3 reader, %v", err)
+	}
+	return reader
+}
+
+func TestS3ReaderList(t *testing.T) {
+	store := &fakeS3{
+		objects: map[string]map[string]string{
+			"faasflow-flow-req1": {
+				"key":           "value",
+				"0--node--next": "forwarded",
+				"1--node--next": "forwarded value",
+				"a b--node":     "",
+				"other":         "other value",
+			},
+		},
+		pageSize: 2,
+	}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	reader := newFakeS3Reader(t, server, "", "")
+
+	entries, err := reader.List(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("got %d entries, want 5", len(entries))
+	}
+	for _, entry := range entries {
+		if value := store.objects["faasflow-flow-req1"][entry.Key]; len(value) != entry.Size {
+			t.Errorf("key %s: got size %d, want %d", entry.Key, entry.Size, len(value))
+		}
+	}
+	// the 5 objects are listed 2 by 2
+	if store.lists != 3 {
+		t.Errorf("got %d listings, want 3", store.lists)
+	}
+	if !store.signed {
+		t.Errorf("expected signed requests")
+	}
+}
+
+func TestS3ReaderListPrefix(t *testing.T) {
+	store := &fakeS3{
+		objects: map[string]map[string]string{
+			"faasflow": {
+				"flow/req1/key":  "value",
+				"flow/req1/key2": "value2",
+				"flow/req2/key":  "other request",
+			},
+		},
+		pageSize: 10,
+	}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	reader := newFakeS3Reader(t, server, "faasflow", "{flow}/{request}/")
+
+	entries, err := reader.List(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	keys := make([]string, 0)
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "key,key2" {
+		t.Errorf("got keys %v, want [key key2]", keys)
+	}
+}
+
+func TestS3ReaderListErrors(t *testing.T) {
+	store := &fakeS3{objects: map[string]map[string]string{"denied": {}}, pageSize: 10}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	// the bucket of a request without value is not created
+	entries, err := newFakeS3Reader(t, server, "", "").List(context.Background(), "flow", "missing")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("got %v %v, want no entries", entries, err)
+	}
+
+	_, err = newFakeS3Reader(t, server, "denied", "").List(context.Background(), "flow", "req1")
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("got error %v, want AccessDenied", err)
+	}
+}
+
+func TestS3ReaderGet(t *testing.T) {
+	store := &fakeS3{
+		objects: map[string]map[string]string{
+			"faasflow-flow-req1": {"key": "value", "a b--node": "spaced"},
+		},
+	}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	reader := newFakeS3Reader(t, server, "", "")
+
+	tests := []struct {
+		key   string
+		value []byte
+	}{
+		{"key", []byte("value")},
+		{"a b--node", []byte("spaced")},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		value, err := reader.Get(context.Background(), "flow", "req1", test.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.key, err)
+			continue
+		}
+		if string(value) != string(test.value) || (value == nil) != (test.value == nil) {
+			t.Errorf("%s: got %q, want %q", test.key, value, test.value)
+		}
+	}
+}
@@ -29,6 +29,58 @@ func initialize() error {
 	}
 	gatewayUrl = os.Getenv("gateway_url")
 	gen = pageGen.Must(pageGen.ParseGlob("views/*.html"))
+
+	err := initAuth()
+	if err != nil {
+		return fmt.Errorf("failed to initialize authentication, %v", err)
+	}
+
+	trustedProxies, err = parseTrustedProxies(os.Getenv("trusted_proxies"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize trusted proxies, %v", err)
+	}
+
+	err = initAuditLog(os.Getenv("audit_log"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize audit log, %v", err)
+	}
+
+	retention := parseIntOrDurationValue(os.Getenv("history_retention"), 30*24*time.Hour)
+	history, err = openFileHistoryStore(os.Getenv("history_path"), retention)
+	if err != nil {
+		return fmt.Errorf("failed to initialize request history, %v", err)
+	}
+
+	snapshotLimit, err := strconv.Atoi(os.Getenv("dag_history_limit"))
+	if err != nil || snapshotLimit < 0 {
+		snapshotLimit = 20
+	}
+	snapshots, err = openFileSnapshotStore(os.Getenv("dag_history_path"), snapshotLimit)
+	if err != nil {
+		return fmt.Errorf("failed to initialize dag history, %v", err)
+	}
+
+	dataStore, err = openDataStoreReader(os.Getenv("datastore_type"), os.Getenv("datastore_url"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize datastore, %v", err)
+	}
+
+	stateStore, err = openStateStoreReader(os.Getenv("statestore_type"), os.Getenv("statestore_url"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize statestore, %v", err)
+	}
+
+	logRetention := parseIntOrDurationValue(os.Getenv("log_retention"), 24*time.Hour)
+	logMaxLines, err := strconv.Atoi(os.Getenv("log_max_lines"))
+	if err != nil || logMaxLines < 0 {
+		logMaxLines = 1000
+	}
+	logMaxRequests, err := strconv.Atoi(os.Getenv("log_max_requests"))
+	if err != nil || logMaxRequests < 0 {
+		logMaxRequests = 1000
+	}
+	logs = newLogStore(logRetention, logMaxLines, logMaxRequests)
+	logIngestToken = storeCredential("log-ingest-token")
 	return nil
 }
 
@@ -52,6 +104,31 @@ func main() {
 	readTimeout := parseIntOrDurationValue(os.Getenv("read_timeout"), 10*time.Second)
 	writeTimeout := parseIntOrDurationValue(os.Getenv("write_timeout"), 10*time.Second)
 
+	// streams are closed before the write timeout and reconnected by the client
+	streamInterval = parseIntOrDurationValue(os.Getenv("stream_interval"), 2*time.Second)
+	if writeTimeout > 2*streamInterval {
+		streamDuration = writeTimeout - streamInterval
+	}
+
+	// page lookups must complete before the write timeout
+	pageTimeout = parseIntOrDurationValue(os.Getenv("page_timeout"), writeTimeout/2)
+	if workers, err := strconv.Atoi(os.Getenv("fanout_workers")); err == nil && workers > 0 {
+		fanOutWorkers = workers
+	}
+
+	// requests not final within the lookback are not counted as active
+	activeLookback = parseIntOrDurationValue(os.Getenv("active_requests_lookback"), activeLookback)
+
+	// a zero ttl disables caching of the function list
+	functionListTTL = parseIntOrDurationValue(os.Getenv("cache_ttl"), functionListTTL)
+
+	// flows are swept for stuck requests when annotated with a threshold
+	stuckSweepInterval = parseIntOrDurationValue(os.Getenv("stuck_sweep_interval"), stuckSweepInterval)
+	stuckAlertURL = os.Getenv("stuck_alert_url")
+
+	// requests are recorded in the history without being viewed
+	historyDiscoveryInterval = parseIntOrDurationValue(os.Getenv("history_discovery_interval"), historyDiscoveryInterval)
+
 	var err error
 
 	err = initialize()
@@ -60,6 +137,13 @@ func main() {
 	}
 	log.Printf("successfully initialized gateway")
 
+	if stuckSweepInterval > 0 {
+		go stuckRequests.run(stuckSweepInterval)
+	}
+	if historyDiscoveryInterval > 0 {
+		go historyDiscovery.run(historyDiscoveryInterval)
+	}
+
 	s := &http.Server{
 		Addr:           fmt.Sprintf(":%d", 8082),
 		ReadTimeout:    readTimeout,
@@ -68,20 +152,57 @@ func main() {
 	}
 
 	// Template
-	http.HandleFunc("/", dashboardPageHandler)
-	http.HandleFunc("/flow/info", flowInfoPageHandler)
-	http.HandleFunc("/flow/requests", flowRequestsPageHandler)
-	http.HandleFunc("/flow/request/monitor", flowRequestMonitorPageHandler)
+	http.HandleFunc("/", authorize(roleViewer, dashboardPageHandler))
+	http.HandleFunc("/flow/info", authorize(roleViewer, flowInfoPageHandler))
+	http.HandleFunc("/flow/requests", authorize(roleViewer, flowRequestsPageHandler))
+	http.HandleFunc("/flow/request/monitor", authorize(roleViewer, flowRequestMonitorPageHandler))
+	http.HandleFunc("/flow/versions", authorize(roleViewer, flowVersionsPageHandler))
+	http.HandleFunc("/flow/analytics", authorize(roleViewer, flowAnalyticsPageHandler))
+	http.HandleFunc("/dependencies", authorize(roleViewer, dependenciesPageHandler))
+	http.HandleFunc("/requests/stuck", authorize(roleViewer, stuckRequestsPageHandler))
 
 	// Static content
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./assets/static/"))))
 
+	// Authentication
+	http.HandleFunc("/auth/login", loginHandler)
+	http.HandleFunc("/auth/callback", callbackHandler)
+	http.HandleFunc("/auth/logout", logoutHandler)
+	if mockIdp != nil {
+		mockIdp.registerHandlers(http.DefaultServeMux)
+	}
+
 	// API request
-	http.HandleFunc("/api/flow/list", listFlowsHandler)
-	http.HandleFunc("/api/flow/delete", deleteFlowsHandler)
-	http.HandleFunc("/api/flow/info", flowDescHandler)
-	http.HandleFunc("/api/flow/requests", listFlowRequestsHandler)
-	http.HandleFunc("/api/flow/request/traces", requestTracesHandler)
+	http.HandleFunc("/api/user", authorize(roleViewer, userHandler))
+	http.HandleFunc("/api/flow/list", authorize(roleViewer, listFlowsHandler))
+	http.HandleFunc("/api/flow/delete", authorize(roleAdmin, deleteFlowsHandler))
+	http.HandleFunc("/api/flow/deploy", authorize(roleAdmin, deployFlowHandler))
+	http.HandleFunc("/api/flow/redeploy", authorize(roleAdmin, redeployFlowHandler))
+	http.HandleFunc("/api/flow/info", authorize(roleViewer, flowDescHandler))
+	http.HandleFunc("/api/flow/lint", authorize(roleViewer, flowLintHandler))
+	http.HandleFunc("/api/flow/versions", authorize(roleViewer, flowVersionsHandler))
+	http.HandleFunc("/api/flow/analytics", authorize(roleViewer, flowAnalyticsHandler))
+	http.HandleFunc("/api/flow/requests", authorize(roleViewer, listFlowRequestsHandler))
+	http.HandleFunc("/api/flow/request/traces", authorize(roleViewer, requestTracesHandler))
+	http.HandleFunc("/api/flow/request/dot", authorize(roleViewer, requestDotHandler))
+	http.HandleFunc("/api/flow/request/critical-path", authorize(roleViewer, criticalPathHandler))
+	http.HandleFunc("/api/flow/request/stream", authorize(roleViewer, requestStreamHandler))
+	http.HandleFunc("/api/flow/request/data", authorize(roleOperator, requestDataHandler))
+	http.HandleFunc("/api/flow/request/data/download", authorize(roleOperator, requestDataDownloadHandler))
+	http.HandleFunc("/api/flow/request/state", authorize(roleOperator, requestStateHandler))
+	http.HandleFunc("/api/flow/request/logs", authorize(roleViewer, requestLogsHandler))
+	http.HandleFunc("/api/flow/request/logs/ingest", authorizeIngest(roleOperator, ingestLogsHandler))
+	http.HandleFunc("/api/flow/request/pause", authorize(roleOperator, controlRequestHandler("pause")))
+	http.HandleFunc("/api/flow/request/resume", authorize(roleOperator, controlRequestHandler("resume")))
+	http.HandleFunc("/api/flow/request/stop", authorize(roleOperator, controlRequestHandler("stop")))
+	http.HandleFunc("/api/flow/requests/pause", authorize(roleOperator, controlRequestsHandler("pause")))
+	http.HandleFunc("/api/flow/requests/resume", authorize(roleOperator, controlRequestsHandler("resume")))
+	http.HandleFunc("/api/flow/requests/stop", authorize(roleOperator, controlRequestsHandler("stop")))
+	http.HandleFunc("/api/dependencies", authorize(roleViewer, dependenciesHandler))
+	http.HandleFunc("/api/dependents", authorize(roleViewer, dependentsHandler))
+	http.HandleFunc("/api/audit", authorize(roleOperator, auditHandler))
+	http.HandleFunc("/api/flow/requests/history", authorize(roleViewer, requestHistoryHandler))
+	http.HandleFunc("/api/flow/requests/stuck", authorize(roleViewer, stuckRequestsHandler))
 
 	log.Fatal(s.ListenAndServe())
 }
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/openfaas/openfaas-cloud/sdk"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,8 +19,73 @@ type DeleteFunctionRequest struct {
 	FunctionName string `json:"functionName"`
 }
 
-// listFlowFunctions request to list-flow-function to get flow-function list
+// FunctionError an error replied by a faas-flow-tower function
+type FunctionError struct {
+	Function   string
+	StatusCode int
+	Message    string
+}
+
+func (err *FunctionError) Error() string {
+	if err.Function == "" {
+		return err.Message
+	}
+	return fmt.Sprintf("%s: %s", err.Function, err.Message)
+}
+
+// functionError get the error of a function reply, nil for a successful
+// reply, the functions reply errors as json with the status and the message
+func functionError(function string, statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	message := strings.TrimSpace(string(body))
+	reply := &struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}{}
+	if json.Unmarshal(body, reply) == nil && reply.Error != "" {
+		message = reply.Error
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return &FunctionError{Function: function, StatusCode: statusCode, Message: message}
+}
+
+// errorStatus get the status to reply for a service error, a missing or
+// invalid resource is replied as is and any other function failure is a
+// gateway failure
+func errorStatus(err error) int {
+	fErr, ok := err.(*FunctionError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch fErr.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return fErr.StatusCode
+	}
+	return http.StatusBadGateway
+}
+
+// listFlowFunctions get the flow-function list, the list is cached for
+// functionListTTL
 func listFlowFunctions() ([]*Function, error) {
+	value, err := serviceCache.get("functions", func() (interface{}, time.Duration, error) {
+		functions, err := fetchFlowFunctions()
+		if err == nil {
+			snapshotFlows(functions)
+		}
+		return functions, functionListTTL, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]*Function), nil
+}
+
+// fetchFlowFunctions request to list-flow-function to get flow-function list
+func fetchFlowFunctions() ([]*Function, error) {
 	var err error
 
 	c := http.Client{}
@@ -31,6 +101,9 @@ func listFlowFunctions() ([]*Function, error) {
 			if bErr != nil {
 				return nil, fmt.Errorf("failed to get function list, %v", bErr)
 			}
+			if fErr := functionError("list-flow-functions", response.StatusCode, bodyBytes); fErr != nil {
+				return nil, fErr
+			}
 
 			functions := []*Function{}
 			mErr := json.Unmarshal(bodyBytes, &functions)
@@ -83,11 +156,26 @@ func deleteFlowFunction(functionName string) error {
 		return fmt.Errorf("unable to query functions, status: %d, body: %v", response.StatusCode, respBody)
 	}
 
+	serviceCache.invalidate("functions")
+
 	return nil
 }
 
-// getDot request to dot-generator for the dag dot graph
-func getDot(function string) (string, error) {
+// getDot get the dag dot graph of a flow, the dot is cached per image so a
+// redeployed flow gets a fresh graph
+func getDot(function, image string) (string, error) {
+	value, err := serviceCache.get("dot/"+function+"/"+image, func() (interface{}, time.Duration, error) {
+		dot, err := fetchDot(function)
+		return dot, dotTTL, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// fetchDot request to dot-generator for the dag dot graph
+func fetchDot(function string) (string, error) {
 	var err error
 
 	c := http.Client{}
@@ -102,6 +190,9 @@ func getDot(function string) (string, error) {
 			if bErr != nil {
 				return "", fmt.Errorf("failed to get dag, %v", bErr)
 			}
+			if fErr := functionError("dot-generator", response.StatusCode, bodyBytes); fErr != nil {
+				return "", fErr
+			}
 			return string(bodyBytes), nil
 		}
 		return "", fmt.Errorf("failed to get dag, empty reply")
@@ -109,13 +200,146 @@ func getDot(function string) (string, error) {
 	return "", fmt.Errorf("failed to get dag, %v", err)
 }
 
-// listFlowRequests request to metrics function to get list of request for a flow function
-func listFlowRequests(flow string) (map[string]string, error) {
+// getLintReport get the lint report of the dag of a flow, the report is
+// cached for functionListTTL as it depends on the deployed functions
+func getLintReport(function, image string) (*LintReport, error) {
+	value, err := serviceCache.get("lint/"+function+"/"+image, func() (interface{}, time.Duration, error) {
+		report, err := fetchLintReport(function)
+		return report, functionListTTL, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*LintReport), nil
+}
+
+// fetchLintReport request to dot-generator for the lint report of a flow
+func fetchLintReport(function string) (*LintReport, error) {
+	c := http.Client{}
+
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"function/dot-generator?lint=true&function="+url.QueryEscape(function), nil)
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lint report, %v", err)
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lint report, %v", err)
+	}
+	if fErr := functionError("dot-generator", response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
+
+	report := &LintReport{}
+	err = json.Unmarshal(bodyBytes, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lint report, %v", err)
+	}
+	return report, nil
+}
+
+// getRequestDot request to dot-generator for the dag dot graph overlaid with
+// the execution of a request, and its critical path when critical is set
+func getRequestDot(ctx context.Context, function, requestID, traceID string, critical bool) (string, error) {
 	var err error
 
 	c := http.Client{}
-	url := gatewayUrl + "function/metrics?method=list&function=" + flow
-	request, _ := http.NewRequest(http.MethodGet, url, nil)
+
+	params := url.Values{}
+	params.Set("function", function)
+	params.Set("request", requestID)
+	params.Set("trace", traceID)
+	if critical {
+		params.Set("critical", "true")
+	}
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"function/dot-generator?"+params.Encode(), nil)
+	request = request.WithContext(ctx)
+
+	response, err := c.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to get request dag, %v", err)
+	}
+
+	defer response.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get request dag, %v", err)
+	}
+	if fErr := functionError("dot-generator", response.StatusCode, bodyBytes); fErr != nil {
+		return "", fErr
+	}
+
+	return string(bodyBytes), nil
+}
+
+// getCriticalPath request to metrics function to get the critical path of a
+// request from its span tree
+func getCriticalPath(ctx context.Context, traceID string) (*CriticalPath, error) {
+	c := http.Client{}
+	request, _ := http.NewRequest(http.MethodGet,
+		gatewayUrl+"function/metrics?method=critical-path&trace="+url.QueryEscape(traceID), nil)
+	request = request.WithContext(ctx)
+
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get critical path, %v", err)
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get critical path, %v", err)
+	}
+	if fErr := functionError("metrics", response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
+
+	path := &CriticalPath{}
+	if err := json.Unmarshal(bodyBytes, path); err != nil {
+		return nil, fmt.Errorf("failed to get critical path, %v", err)
+	}
+	return path, nil
+}
+
+// listFlowRequests request to metrics function to get an ordered page of
+// requests for a flow function, a zero limit lists all the requests
+func listFlowRequests(ctx context.Context, flow string, query *RequestQuery) (*RequestList, error) {
+	var err error
+
+	params := url.Values{}
+	params.Set("method", "list")
+	params.Set("function", flow)
+	params.Set("limit", strconv.Itoa(query.Limit))
+	if query.Offset > 0 {
+		params.Set("offset", strconv.Itoa(query.Offset))
+	}
+	if query.Start > 0 {
+		params.Set("start", strconv.Itoa(query.Start))
+	}
+	if query.End > 0 {
+		params.Set("end", strconv.Itoa(query.End))
+	}
+	if query.Cursor != "" {
+		params.Set("cursor", query.Cursor)
+	}
+	if query.PrevCursor != "" {
+		params.Set("prev-cursor", query.PrevCursor)
+	}
+	if query.Sort != "" {
+		params.Set("sort", query.Sort)
+	}
+	if query.Order != "" {
+		params.Set("order", query.Order)
+	}
+	if query.CountOnly {
+		params.Set("count-only", "true")
+	}
+
+	c := http.Client{}
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"function/metrics?"+params.Encode(), nil)
+	request = request.WithContext(ctx)
 
 	response, err := c.Do(request)
 
@@ -127,9 +351,12 @@ func listFlowRequests(flow string) (map[string]string, error) {
 			if bErr != nil {
 				return nil, fmt.Errorf("failed to get request list, %v", bErr)
 			}
+			if fErr := functionError("metrics", response.StatusCode, bodyBytes); fErr != nil {
+				return nil, fErr
+			}
 
-			var requests map[string]string
-			mErr := json.Unmarshal(bodyBytes, &requests)
+			requests := &RequestList{}
+			mErr := json.Unmarshal(bodyBytes, requests)
 			if mErr != nil {
 				return nil, fmt.Errorf("failed to get request list, %v", mErr)
 			}
@@ -141,21 +368,45 @@ func listFlowRequests(flow string) (map[string]string, error) {
 	return nil, fmt.Errorf("failed to get requests list, %v", err)
 }
 
+// findRequestTraceID find the trace ID of a request from the history or the
+// request list
+func findRequestTraceID(ctx context.Context, flow, requestID string) (string, error) {
+	record, err := history.Get(flow, requestID)
+	if err == nil && record != nil && record.TraceID != "" {
+		return record.TraceID, nil
+	}
+
+	requests, err := listFlowRequests(ctx, flow, &RequestQuery{})
+	if err != nil {
+		return "", err
+	}
+	for _, request := range requests.Requests {
+		if request.RequestID == requestID {
+			return request.TraceID, nil
+		}
+	}
+	return "", fmt.Errorf("request %s not found", requestID)
+}
+
 // buildFlowDesc get a flow details
 func buildFlowDesc(functions []*Function, flowName string) (*FlowDesc, error) {
 
 	var functionObj *Function
-	for _, functionObj = range functions {
-		if functionObj.Name == flowName {
+	for _, function := range functions {
+		if function.Name == flowName {
+			functionObj = function
 			break
 		}
 	}
+	if functionObj == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("flow %s not found", flowName)}
+	}
 
 	description := functionObj.Annotations["faas-flow-desc"]
 
-	dot, dErr := getDot(flowName)
+	dot, dErr := getDot(flowName, functionObj.Image)
 	if dErr != nil {
-		return nil, fmt.Errorf("failed to get dot, %v", dErr)
+		return nil, dErr
 	}
 
 	flowDesc := &FlowDesc{
@@ -173,12 +424,13 @@ func buildFlowDesc(functions []*Function, flowName string) (*FlowDesc, error) {
 }
 
 // listRequestTraces request to metrics function to get list of traces for a request traceID
-func listRequestTraces(requestTraceId string) (*RequestTrace, error) {
+func listRequestTraces(ctx context.Context, requestTraceId string) (*RequestTrace, error) {
 	var err error
 
 	c := http.Client{}
 	url := gatewayUrl + "function/metrics?method=traces&trace=" + requestTraceId
 	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request = request.WithContext(ctx)
 
 	response, err := c.Do(request)
 	if err != nil {
@@ -190,6 +442,9 @@ func listRequestTraces(requestTraceId string) (*RequestTrace, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get traces, %v", err)
 	}
+	if fErr := functionError("metrics", response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
 
 	trace := &RequestTrace{}
 	err = json.Unmarshal(bodyBytes, trace)
@@ -203,12 +458,17 @@ func listRequestTraces(requestTraceId string) (*RequestTrace, error) {
 }
 
 // getRequestStatus request the flow for the request status
-func getRequestStatus(function, requestTraceId string) (string, error) {
+func getRequestStatus(ctx context.Context, function, requestTraceId string) (string, error) {
 	var err error
 
+	if !validFunctionName.MatchString(function) {
+		return "", &FunctionError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("invalid flow name %q", function)}
+	}
+
 	c := http.Client{}
-	url := gatewayUrl + "function/" + function + "?state=" + requestTraceId
+	url := gatewayUrl + "function/" + function + "?state=" + url.QueryEscape(requestTraceId)
 	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request = request.WithContext(ctx)
 
 	response, err := c.Do(request)
 	if err != nil {
@@ -220,6 +480,104 @@ func getRequestStatus(function, requestTraceId string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to read state, %v", err)
 	}
+	if fErr := functionError(function, response.StatusCode, bodyBytes); fErr != nil {
+		return "", fErr
+	}
 
-	return string(bodyBytes), nil
+	return strings.TrimSpace(string(bodyBytes)), nil
+}
+
+// controlFlowRequest request the flow to pause, resume or stop a request
+func controlFlowRequest(function, requestID, action string) error {
+	var err error
+
+	if !validFunctionName.MatchString(function) {
+		return fmt.Errorf("invalid flow name %q", function)
+	}
+
+	c := http.Client{
+		Timeout: time.Second * 3,
+	}
+
+	url := gatewayUrl + "function/" + function + "?" + action + "-flow=" + url.QueryEscape(requestID)
+	httpReq, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request %v", err)
+	}
+
+	addAuthErr := sdk.AddBasicAuth(httpReq)
+	if addAuthErr != nil {
+		return fmt.Errorf("basic auth error %s", addAuthErr)
+	}
+
+	response, err := c.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to %s request, %v", action, err)
+	}
+	defer response.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to %s request, status: %d, body: %s", action, response.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// buildRequestTrace get the traces and the state of a request and record it
+// in the history, on failure the returned trace is partial. The trace of a
+// completed request doesn't change and is cached for requestTraceTTL. The
+// load is shared by the concurrent callers, it is bounded by pageTimeout
+// rather than by the context of the caller which started it
+func buildRequestTrace(flowName string, request *RequestSummary) (*RequestTrace, error) {
+	value, err := serviceCache.get("trace/"+flowName+"/"+request.TraceID, func() (interface{}, time.Duration, error) {
+		loadCtx, cancel := pageContext(context.Background())
+		defer cancel()
+
+		requestTrace, err := fetchRequestTrace(loadCtx, flowName, request)
+		if err == nil && isFinalState(requestTrace.Status) {
+			return requestTrace, requestTraceTTL, nil
+		}
+		return requestTrace, 0, err
+	})
+	if value == nil {
+		return &RequestTrace{RequestID: request.RequestID, TraceId: request.TraceID, Status: "UNKNOWN"}, err
+	}
+
+	// the cached trace is shared, callers get their own copy
+	requestTrace := *value.(*RequestTrace)
+	return &requestTrace, err
+}
+
+// fetchRequestTrace get the traces and the state of a request and record it
+// in the history
+func fetchRequestTrace(ctx context.Context, flowName string, request *RequestSummary) (*RequestTrace, error) {
+	requestState, stateErr := getRequestStatus(ctx, flowName, request.RequestID)
+	if stateErr != nil {
+		log.Printf("failed to get request state for %s, request %s, error: %v",
+			flowName, request.RequestID, stateErr)
+	}
+
+	requestTrace, traceErr := listRequestTraces(ctx, request.TraceID)
+	if traceErr != nil {
+		log.Printf("failed to get request traces for request %s, traceId %s, error: %v",
+			request.RequestID, request.TraceID, traceErr)
+		requestTrace = &RequestTrace{
+			RequestID: request.RequestID,
+			TraceId:   request.TraceID,
+			StartTime: request.StartTime,
+			Duration:  request.Duration,
+			// without its trace a request has not ended until its flow says so
+			Status: requestStatus(&RequestTrace{}, requestState, stateErr),
+		}
+	} else {
+		requestTrace.Status = requestStatus(requestTrace, requestState, stateErr)
+	}
+	recordRequest(flowName, request.RequestID, requestTrace)
+
+	if traceErr != nil {
+		return requestTrace, traceErr
+	}
+	return requestTrace, stateErr
 }
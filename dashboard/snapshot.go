@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DagSnapshot the exported dag of a flow for an image
+type DagSnapshot struct {
+	Flow  string          `json:"flow"`
+	Image string          `json:"image"`
+	Time  time.Time       `json:"time"`
+	Dag   json.RawMessage `json:"dag,omitempty"`
+}
+
+// DagChange a structural change between two versions of a flow dag as
+// reported by dot-generator
+type DagChange struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FlowDiff the diff of the dag of a flow between two images
+type FlowDiff struct {
+	Flow    string       `json:"flow"`
+	From    *DagSnapshot `json:"from"`
+	To      *DagSnapshot `json:"to"`
+	Dot     string       `json:"dot"`
+	Changes []*DagChange `json:"changes"`
+}
+
+// FlowVersions the snapshots of a flow and the diff between two of them
+type FlowVersions struct {
+	Flow      string         `json:"flow"`
+	Snapshots []*DagSnapshot `json:"snapshots"`
+	Diff      *FlowDiff      `json:"diff,omitempty"`
+}
+
+// SnapshotStore stores the dag of the flows per image, so the dag of a
+// replaced image can be compared with the current one
+type SnapshotStore interface {
+	// Put add the snapshot of a flow image, an existing one is replaced
+	Put(snapshot *DagSnapshot) error
+	// Get get the snapshot of a flow image, returns nil if not found
+	Get(flow, image string) (*DagSnapshot, error)
+	// List list the snapshots of a flow without their dag, latest first
+	List(flow string) ([]*DagSnapshot, error)
+	// Close flush and close the store
+	Close() error
+}
+
+// fileSnapshotStore a SnapshotStore backed by an append only log file, only
+// the latest snapshots of a flow are kept
+type fileSnapshotStore struct {
+	lock      sync.Mutex
+	path      string
+	file      *os.File
+	limit     int
+	snapshots map[string][]*DagSnapshot
+}
+
+var (
+	snapshots SnapshotStore
+
+	// images of the flows being snapshotted
+	pendingSnapshots     = make(map[string]bool)
+	pendingSnapshotsLock sync.Mutex
+	// images of the flows snapshotted and not verified yet, by snapshot time
+	unverifiedSnapshots = make(map[string]time.Time)
+	// delay after which a snapshot is exported again, once the replicas of the
+	// previous image are replaced
+	snapshotVerifyDelay = 2 * time.Minute
+)
+
+// openFileSnapshotStore open a file snapshot store, an empty path keeps the
+// snapshots only in memory
+func openFileSnapshotStore(path string, limit int) (*fileSnapshotStore, error) {
+	store := &fileSnapshotStore{
+		path:      path,
+		limit:     limit,
+		snapshots: make(map[string][]*DagSnapshot),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open dag snapshots, %v", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		// a dag can be larger than the default token size
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			snapshot := &DagSnapshot{}
+			if err := json.Unmarshal(scanner.Bytes(), snapshot); err != nil {
+				log.Printf("skipping invalid dag snapshot, error: %v", err)
+				continue
+			}
+			store.index(snapshot)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read dag snapshots, %v", err)
+		}
+	}
+
+	err = store.compact()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// index add a snapshot to the in memory index, returns true if older
+// snapshots of the flow were dropped
+func (store *fileSnapshotStore) index(snapshot *DagSnapshot) bool {
+	flowSnapshots := store.snapshots[snapshot.Flow]
+	for i, existing := range flowSnapshots {
+		if existing.Image == snapshot.Image {
+			flowSnapshots = append(flowSnapshots[:i], flowSnapshots[i+1:]...)
+			break
+		}
+	}
+	flowSnapshots = append(flowSnapshots, snapshot)
+	sort.Slice(flowSnapshots, func(i, j int) bool {
+		return flowSnapshots[i].Time.Before(flowSnapshots[j].Time)
+	})
+
+	dropped := false
+	if store.limit > 0 && len(flowSnapshots) > store.limit {
+		flowSnapshots = flowSnapshots[len(flowSnapshots)-store.limit:]
+		dropped = true
+	}
+	store.snapshots[snapshot.Flow] = flowSnapshots
+	return dropped
+}
+
+// compact rewrite the log with only the kept snapshots
+func (store *fileSnapshotStore) compact() error {
+	if store.path == "" {
+		return nil
+	}
+
+	if store.file != nil {
+		store.file.Close()
+		store.file = nil
+	}
+
+	tmpPath := store.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact dag snapshots, %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, flowSnapshots := range store.snapshots {
+		for _, snapshot := range flowSnapshots {
+			data, _ := json.Marshal(snapshot)
+			writer.Write(append(data, '\n'))
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact dag snapshots, %v", err)
+	}
+	file.Close()
+
+	if err = os.Rename(tmpPath, store.path); err != nil {
+		return fmt.Errorf("failed to compact dag snapshots, %v", err)
+	}
+
+	store.file, err = os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open dag snapshots, %v", err)
+	}
+	return nil
+}
+
+// Put add the snapshot of a flow image
+func (store *fileSnapshotStore) Put(snapshot *DagSnapshot) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if snapshot.Time.IsZero() {
+		snapshot.Time = time.Now()
+	}
+	dropped := store.index(snapshot)
+
+	if store.file == nil {
+		return nil
+	}
+	if dropped {
+		return store.compact()
+	}
+
+	data, _ := json.Marshal(snapshot)
+	_, err := store.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write dag snapshot, %v", err)
+	}
+	return nil
+}
+
+// Get get the snapshot of a flow image, returns nil if not found
+func (store *fileSnapshotStore) Get(flow, image string) (*DagSnapshot, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for _, snapshot := range store.snapshots[flow] {
+		if snapshot.Image == image {
+			copied := *snapshot
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// List list the snapshots of a flow without their dag, latest first
+func (store *fileSnapshotStore) List(flow string) ([]*DagSnapshot, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	flowSnapshots := store.snapshots[flow]
+	list := make([]*DagSnapshot, 0, len(flowSnapshots))
+	for i := len(flowSnapshots) - 1; i >= 0; i-- {
+		copied := *flowSnapshots[i]
+		copied.Dag = nil
+		list = append(list, &copied)
+	}
+	return list, nil
+}
+
+// Close flush and close the store
+func (store *fileSnapshotStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+// snapshotFlows snapshot the dag of the flows whose image was not seen yet,
+// the dags are exported in background so the function list is not delayed.
+// A flow is only snapshotted once its replicas are ready, as the gateway
+// reports the new image while the replicas of the previous one still serve
+// the requests. A rollout may still be in progress when the replicas are
+// ready, so the dag is exported again after snapshotVerifyDelay and the
+// snapshot is replaced when it differs
+func snapshotFlows(functions []*Function) {
+	if snapshots == nil {
+		return
+	}
+
+	for _, function := range functions {
+		if function.Image == "" || !function.isReady() {
+			continue
+		}
+		known, err := snapshots.Get(function.Name, function.Image)
+		if err != nil {
+			log.Printf("failed to get dag snapshot of %s, error: %v", function.Name, err)
+			continue
+		}
+
+		key := function.Name + "/" + function.Image
+		pendingSnapshotsLock.Lock()
+		if known != nil {
+			snapshotted, unverified := unverifiedSnapshots[key]
+			if !unverified || time.Since(snapshotted) < snapshotVerifyDelay {
+				pendingSnapshotsLock.Unlock()
+				continue
+			}
+		}
+		if pendingSnapshots[key] {
+			pendingSnapshotsLock.Unlock()
+			continue
+		}
+		pendingSnapshots[key] = true
+		pendingSnapshotsLock.Unlock()
+
+		go snapshotFlow(function.Name, function.Image, key, known)
+	}
+}
+
+// snapshotFlow export the dag of a flow image and save it, the known snapshot
+// is verified and replaced when the dag changed
+func snapshotFlow(flowName, image, key string, known *DagSnapshot) {
+	defer func() {
+		pendingSnapshotsLock.Lock()
+		delete(pendingSnapshots, key)
+		pendingSnapshotsLock.Unlock()
+	}()
+
+	dag, err := fetchDagExport(flowName)
+	if err != nil {
+		log.Printf("failed to snapshot dag of %s, image %s, error: %v", flowName, image, err)
+		return
+	}
+	if known != nil && sameDag(known.Dag, dag) {
+		pendingSnapshotsLock.Lock()
+		delete(unverifiedSnapshots, key)
+		pendingSnapshotsLock.Unlock()
+		return
+	}
+
+	err = snapshots.Put(&DagSnapshot{Flow: flowName, Image: image, Dag: dag})
+	if err != nil {
+		log.Printf("failed to save dag snapshot of %s, image %s, error: %v", flowName, image, err)
+		return
+	}
+
+	// a replaced snapshot is verified again
+	pendingSnapshotsLock.Lock()
+	unverifiedSnapshots[key] = time.Now()
+	pendingSnapshotsLock.Unlock()
+
+	if known != nil {
+		// the graphs rendered during the rollout may be of the previous image
+		serviceCache.invalidate("dot/" + key)
+		serviceCache.invalidate("lint/" + key)
+		log.Printf("replaced dag snapshot of %s, image %s, the dag changed after the rollout", flowName, image)
+		return
+	}
+	log.Printf("saved dag snapshot of %s, image %s", flowName, image)
+}
+
+// sameDag check if two exported dags are equal, ignoring the formatting
+func sameDag(a, b json.RawMessage) bool {
+	compactA, compactB := bytes.Buffer{}, bytes.Buffer{}
+	if json.Compact(&compactA, a) != nil || json.Compact(&compactB, b) != nil {
+		return false
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
+
+// fetchDagExport request a flow function for its exported dag
+func fetchDagExport(function string) (json.RawMessage, error) {
+	if !validFunctionName.MatchString(function) {
+		return nil, &FunctionError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("invalid flow name %q", function)}
+	}
+
+	c := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"function/"+function+"?export-dag=true", nil)
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export dag, %v", err)
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export dag, %v", err)
+	}
+	if fErr := functionError(function, response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
+	if !json.Valid(bodyBytes) {
+		return nil, fmt.Errorf("failed to export dag, invalid dag definition")
+	}
+	return json.RawMessage(bodyBytes), nil
+}
+
+// fetchDagDiff request to dot-generator for the diff of two dags
+func fetchDagDiff(old, new json.RawMessage, format string) ([]byte, error) {
+	reqBytes, _ := json.Marshal(map[string]json.RawMessage{"old": old, "new": new})
+
+	c := http.Client{}
+
+	request, _ := http.NewRequest(http.MethodPost, gatewayUrl+"function/dot-generator?diff=true&format="+format,
+		bytes.NewReader(reqBytes))
+	request.Header.Set("Content-Type", jsonType)
+
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dag diff, %v", err)
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dag diff, %v", err)
+	}
+	if fErr := functionError("dot-generator", response.StatusCode, bodyBytes); fErr != nil {
+		return nil, fErr
+	}
+	return bodyBytes, nil
+}
+
+// buildFlowVersions list the snapshots of a flow and diff the dag of two
+// images, by default the previous image is compared with the latest
+func buildFlowVersions(flowName, from, to string) (*FlowVersions, error) {
+	if snapshots == nil {
+		return nil, fmt.Errorf("dag snapshots are not enabled")
+	}
+
+	list, err := snapshots.List(flowName)
+	if err != nil {
+		return nil, err
+	}
+	versions := &FlowVersions{Flow: flowName, Snapshots: list}
+
+	if to == "" && len(list) > 0 {
+		to = list[0].Image
+	}
+	if from == "" && len(list) > 1 {
+		for _, snapshot := range list {
+			if snapshot.Image != to {
+				from = snapshot.Image
+				break
+			}
+		}
+	}
+	if from == "" || to == "" {
+		// nothing to compare yet
+		return versions, nil
+	}
+
+	fromSnapshot, err := snapshots.Get(flowName, from)
+	if err != nil {
+		return nil, err
+	}
+	if fromSnapshot == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("no dag snapshot of %s for image %s", flowName, from)}
+	}
+	toSnapshot, err := snapshots.Get(flowName, to)
+	if err != nil {
+		return nil, err
+	}
+	if toSnapshot == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("no dag snapshot of %s for image %s", flowName, to)}
+	}
+
+	dot, err := fetchDagDiff(fromSnapshot.Dag, toSnapshot.Dag, "dot")
+	if err != nil {
+		return nil, err
+	}
+	changesBytes, err := fetchDagDiff(fromSnapshot.Dag, toSnapshot.Dag, "changes")
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*DagChange, 0)
+	if err := json.Unmarshal(changesBytes, &changes); err != nil {
+		return nil, fmt.Errorf("failed to read dag changes, %v", err)
+	}
+
+	fromSnapshot.Dag = nil
+	toSnapshot.Dag = nil
+	versions.Diff = &FlowDiff{
+		Flow:    flowName,
+		From:    fromSnapshot,
+		To:      toSnapshot,
+		Dot:     string(dot),
+		Changes: changes,
+	}
+	return versions, nil
+}
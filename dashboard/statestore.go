@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keys the flow executor stores in the StateStore
+const (
+	requestStateKey        = "request-state"
+	partialStateKey        = "partial-state"
+	branchCompletionSuffix = "-branch-completion"
+	branchOptionsSuffix    = "-dynamic-branch-options"
+)
+
+// StateStoreReader reads the execution state the requests of a flow keep in
+// the StateStore, keys are relative to the location of the request
+type StateStoreReader interface {
+	// Read read the keys and the values stored for a request
+	Read(ctx context.Context, flow, requestID string) (map[string]string, error)
+}
+
+var (
+	// stateStore the reader of the StateStore the flows use, nil when the
+	// state inspector is disabled
+	stateStore StateStoreReader
+)
+
+// memoryStateReader a StateStoreReader serving states loaded in memory, it
+// stands in for a StateStore to try the inspector
+type memoryStateReader struct {
+	// states the values by key of each request of each flow
+	states map[string]map[string]map[string]string
+}
+
+// BranchState the branches of an execution of a dynamic node
+type BranchState struct {
+	ExecutionID string `json:"execution-id"`
+	UniqueID    string `json:"unique-id"`
+	Node        string `json:"node"`
+	// Kind foreach, condition or dynamic when the dag is not known
+	Kind string `json:"kind"`
+	// Option the options of the enclosing dynamic nodes
+	Option    string   `json:"option,omitempty"`
+	Options   []string `json:"options"`
+	Completed int      `json:"completed"`
+	Pending   int      `json:"pending"`
+}
+
+// InputState the inputs received by an execution of a node with more than
+// one parent
+type InputState struct {
+	ExecutionID string `json:"execution-id"`
+	UniqueID    string `json:"unique-id"`
+	Node        string `json:"node"`
+	Option      string `json:"option,omitempty"`
+	Received    int    `json:"received"`
+	// InDegree the expected inputs, zero when the dag is not known
+	InDegree int `json:"in-degree,omitempty"`
+	Pending  int `json:"pending"`
+}
+
+// PartialStateView a partial request stored while the request is paused
+type PartialStateView struct {
+	// Position the node executed at each depth of the dag
+	Position []string          `json:"position"`
+	Options  map[string]string `json:"options,omitempty"`
+	DataSize int               `json:"data-size"`
+}
+
+// StateEntry a key of the StateStore and its value
+type StateEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// RequestExecutionState the execution state of a request decoded from the
+// StateStore
+type RequestExecutionState struct {
+	Flow          string              `json:"flow"`
+	RequestID     string              `json:"request-id"`
+	State         string              `json:"state"`
+	Branches      []*BranchState      `json:"branches"`
+	Inputs        []*InputState       `json:"inputs"`
+	PartialStates []*PartialStateView `json:"partial-states"`
+	// Diagnosis what the request is waiting for
+	Diagnosis []string `json:"diagnosis"`
+	// Keys the keys of the StateStore, the partial states excluded
+	Keys []*StateEntry `json:"keys"`
+}
+
+// openStateStoreReader create the reader of the StateStore the flows use, an
+// empty type disables the state inspector
+func openStateStoreReader(storeType, storeURL string) (StateStoreReader, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	prefix := os.Getenv("statestore_prefix")
+
+	switch storeType {
+	case "":
+		return nil, nil
+	case "consul":
+		return newConsulReader(client, storeURL, prefix, storeCredential("statestore-token"))
+	case "etcd":
+		return newEtcdReader(client, storeURL, prefix,
+			storeCredential("statestore-username"), storeCredential("statestore-password"))
+	case "memory":
+		return newMemoryStateReader(storeURL)
+	}
+	return nil, fmt.Errorf("unknown statestore type %s", storeType)
+}
+
+// newMemoryStateReader create a memory state reader, the states are loaded
+// from a json file of the values by key of each request of each flow
+func newMemoryStateReader(path string) (*memoryStateReader, error) {
+	reader := &memoryStateReader{states: make(map[string]map[string]map[string]string)}
+	if path == "" {
+		return reader, nil
+	}
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read states, %v", err)
+	}
+	if err := json.Unmarshal(encoded, &reader.states); err != nil {
+		return nil, fmt.Errorf("failed to read states, %v", err)
+	}
+	return reader, nil
+}
+
+// Read read a copy of the values stored for a request
+func (reader *memoryStateReader) Read(ctx context.Context, flow, requestID string) (map[string]string, error) {
+	state := make(map[string]string)
+	for key, value := range reader.states[flow][requestID] {
+		state[key] = value
+	}
+	return state, nil
+}
+
+// splitExecutionID split the execution unique id of a node into the options
+// of the enclosing dynamic nodes and the unique id of the node
+func splitExecutionID(executionID string) (option, uniqueID string) {
+	index := strings.LastIndex(executionID, "--")
+	if index < 0 {
+		return "", executionID
+	}
+	return executionID[:index], executionID[index+2:]
+}
+
+// decodePartialStates decode the partial requests stored while paused, each
+// is an encoded flow request holding the execution state of the pipeline
+func decodePartialStates(encoded string) ([]*PartialStateView, error) {
+	states := make([]string, 0)
+	if err := json.Unmarshal([]byte(encoded), &states); err != nil {
+		return nil, fmt.Errorf("failed to decode partial states, %v", err)
+	}
+
+	views := make([]*PartialStateView, 0, len(states))
+	for _, state := range states {
+		request := &struct {
+			ExecutionState string `json:"ExecutionState"`
+			Data           []byte `json:"Data"`
+		}{}
+		if err := json.Unmarshal([]byte(state), request); err != nil {
+			return nil, fmt.Errorf("failed to decode partial state, %v", err)
+		}
+		pipeline := &struct {
+			Position map[string]string `json:"pipeline-execution-position"`
+			Depth    int               `json:"pipeline-execution-depth"`
+			Options  map[string]string `json:"pipeline-dynamic-option"`
+		}{}
+		if err := json.Unmarshal([]byte(request.ExecutionState), pipeline); err != nil {
+			return nil, fmt.Errorf("failed to decode partial state execution, %v", err)
+		}
+
+		view := &PartialStateView{Options: pipeline.Options, DataSize: len(request.Data)}
+		for depth := 0; depth <= pipeline.Depth; depth++ {
+			if node, found := pipeline.Position[strconv.Itoa(depth)]; found {
+				view.Position = append(view.Position, node)
+			}
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// decodeExecutionState decode the values a request keeps in the StateStore
+// and diagnose what the request waits for
+func decodeExecutionState(flow, requestID string, values map[string]string, nodes map[string]*exportedNode) *RequestExecutionState {
+	state := &RequestExecutionState{
+		Flow:          flow,
+		RequestID:     requestID,
+		State:         values[requestStateKey],
+		Branches:      make([]*BranchState, 0),
+		Inputs:        make([]*InputState, 0),
+		PartialStates: make([]*PartialStateView, 0),
+		Diagnosis:     make([]string, 0),
+		Keys:          make([]*StateEntry, 0, len(values)),
+	}
+
+	branches := make(map[string]*BranchState)
+	branch := func(executionID string) *BranchState {
+		if found, ok := branches[executionID]; ok {
+			return found
+		}
+		option, uniqueID := splitExecutionID(executionID)
+		created := &BranchState{
+			ExecutionID: executionID,
+			UniqueID:    uniqueID,
+			Node:        nodeName(nodes, uniqueID),
+			Kind:        "dynamic",
+			Option:      option,
+			Options:     make([]string, 0),
+		}
+		if node, found := nodes[uniqueID]; found {
+			if node.IsForeach {
+				created.Kind = "foreach"
+			} else if node.IsCondition {
+				created.Kind = "condition"
+			}
+		}
+		branches[executionID] = created
+		return created
+	}
+
+	for key, value := range values {
+		switch {
+		case key == requestStateKey:
+		case key == partialStateKey:
+			partials, err := decodePartialStates(value)
+			if err != nil {
+				state.Diagnosis = append(state.Diagnosis, err.Error())
+				break
+			}
+			state.PartialStates = partials
+			continue
+		case strings.HasSuffix(key, branchOptionsSuffix):
+			options := make([]string, 0)
+			if err := json.Unmarshal([]byte(value), &options); err == nil {
+				branch(strings.TrimSuffix(key, branchOptionsSuffix)).Options = options
+			}
+		case strings.HasSuffix(key, branchCompletionSuffix):
+			if completed, err := strconv.Atoi(value); err == nil {
+				branch(strings.TrimSuffix(key, branchCompletionSuffix)).Completed = completed
+			}
+		default:
+			// the in-degree counters are keyed by the execution unique id
+			received, err := strconv.Atoi(value)
+			if err != nil {
+				break
+			}
+			option, uniqueID := splitExecutionID(key)
+			input := &InputState{
+				ExecutionID: key,
+				UniqueID:    uniqueID,
+				Node:        nodeName(nodes, uniqueID),
+				Option:      option,
+				Received:    received,
+			}
+			if node, found := nodes[uniqueID]; found {
+				input.InDegree = node.InDegree
+				if input.InDegree > received {
+					input.Pending = input.InDegree - received
+				}
+			}
+			state.Inputs = append(state.Inputs, input)
+		}
+		state.Keys = append(state.Keys, &StateEntry{Key: key, Value: value})
+	}
+
+	for _, found := range branches {
+		// a single branch completes without counting
+		if len(found.Options) > 1 && found.Completed < len(found.Options) {
+			found.Pending = len(found.Options) - found.Completed
+		}
+		state.Branches = append(state.Branches, found)
+	}
+	sort.Slice(state.Branches, func(i, j int) bool {
+		return state.Branches[i].ExecutionID < state.Branches[j].ExecutionID
+	})
+	sort.Slice(state.Inputs, func(i, j int) bool {
+		return state.Inputs[i].ExecutionID < state.Inputs[j].ExecutionID
+	})
+	sort.Slice(state.Keys, func(i, j int) bool {
+		return state.Keys[i].Key < state.Keys[j].Key
+	})
+
+	state.Diagnosis = append(state.Diagnosis, diagnoseExecution(state)...)
+	return state
+}
+
+// describeExecution describe the execution of a node for a diagnosis
+func describeExecution(node, uniqueID, option string) string {
+	description := node
+	if node != uniqueID {
+		description = fmt.Sprintf("%s (%s)", node, uniqueID)
+	}
+	if option != "" {
+		description += " for " + strings.Replace(option, "--", "/", -1)
+	}
+	return description
+}
+
+// diagnoseExecution describe what a request waits for
+func diagnoseExecution(state *RequestExecutionState) []string {
+	diagnosis := make([]string, 0)
+	if state.State == "" {
+		return append(diagnosis, "No request state is stored, the request completed and its state was removed or it never started")
+	}
+	diagnosis = append(diagnosis, fmt.Sprintf("The request is %s", state.State))
+
+	pending := false
+	for _, branch := range state.Branches {
+		if branch.Pending == 0 {
+			continue
+		}
+		pending = true
+		diagnosis = append(diagnosis, fmt.Sprintf("%s node %s waits for %d of %d branches",
+			branch.Kind, describeExecution(branch.Node, branch.UniqueID, branch.Option), branch.Pending, len(branch.Options)))
+	}
+	for _, input := range state.Inputs {
+		if input.Pending == 0 {
+			continue
+		}
+		pending = true
+		diagnosis = append(diagnosis, fmt.Sprintf("node %s received %d of %d inputs",
+			describeExecution(input.Node, input.UniqueID, input.Option), input.Received, input.InDegree))
+	}
+
+	if len(state.PartialStates) > 0 {
+		if state.State == "PAUSED" {
+			diagnosis = append(diagnosis, fmt.Sprintf("%d partial requests are queued until the request is resumed",
+				len(state.PartialStates)))
+		} else {
+			diagnosis = append(diagnosis, fmt.Sprintf("%d partial requests stored while paused were not resumed",
+				len(state.PartialStates)))
+		}
+	}
+	if state.State == "RUNNING" && !pending && len(state.PartialStates) == 0 {
+		diagnosis = append(diagnosis, "No branch or input is pending, a node may still be executing or a forwarded request was lost")
+	}
+	return diagnosis
+}
+
+// getExecutionState read and decode the execution state of a request
+func getExecutionState(ctx context.Context, flow, requestID string) (*RequestExecutionState, error) {
+	if stateStore == nil {
+		return nil, &FunctionError{StatusCode: http.StatusNotFound, Message: "no statestore is configured"}
+	}
+	values, err := stateStore.Read(ctx, flow, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request state, %v", err)
+	}
+	return decodeExecutionState(flow, requestID, values, dagNodes(flow)), nil
+}
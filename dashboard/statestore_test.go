@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testStateNodes the dag of the flow the states are stored by, the foreach
+// node split runs a sub dag with the condition node check and the node join
+// waits for two inputs
+var testStateNodes = map[string]*exportedNode{
+	"0_split": {Id: "split", UniqueId: "0_split", IsForeach: true},
+	"1_check": {Id: "check", UniqueId: "1_check", IsCondition: true},
+	"2_join":  {Id: "join", UniqueId: "2_join", InDegree: 2},
+}
+
+// encodePartialState encode a partial request the way the executor stores it
+// while the request is paused
+func encodePartialState(t *testing.T, position map[string]string, depth int, options map[string]string, data string) string {
+	pipeline, err := json.Marshal(map[string]interface{}{
+		"pipeline-execution-position": position,
+		"pipeline-execution-depth":    depth,
+		"pipeline-dynamic-option":     options,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode pipeline, %v", err)
+	}
+	request, err := json.Marshal(map[string]interface{}{
+		"ID":             "req1",
+		"ExecutionState": string(pipeline),
+		"Data":           []byte(data),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode request, %v", err)
+	}
+	return string(request)
+}
+
+// encodePartialStates encode the partial-state value holding partial requests
+func encodePartialStates(t *testing.T, states ...string) string {
+	encoded, err := json.Marshal(states)
+	if err != nil {
+		t.Fatalf("failed to encode partial states, %v", err)
+	}
+	return string(encoded)
+}
+
+func TestMemoryStateReader(t *testing.T) {
+	file, err := ioutil.TempFile("", "states")
+	if err != nil {
+		t.Fatalf("failed to create states, %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"flow": {"req1": {"request-state": "RUNNING", "0_split-branch-completion": "1"}}}`)
+	file.Close()
+
+	reader, err := newMemoryStateReader(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	values, err := reader.Read(context.Background(), "flow", "req1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := map[string]string{"request-state": "RUNNING", "0_split-branch-completion": "1"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("got %v, want %v", values, expected)
+	}
+
+	// the values are copied
+	values["request-state"] = "PAUSED"
+	if values, _ := reader.Read(context.Background(), "flow", "req1"); values["request-state"] != "RUNNING" {
+		t.Errorf("the stored values were modified")
+	}
+	if values, _ := reader.Read(context.Background(), "flow", "missing"); len(values) != 0 {
+		t.Errorf("got %v for a missing request", values)
+	}
+
+	if _, err := newMemoryStateReader(file.Name() + ".missing"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestDecodeExecutionState(t *testing.T) {
+	paused := encodePartialState(t, map[string]string{"0": "0_split", "1": "1_check"}, 1,
+		map[string]string{"0_split": "item1"}, "data")
+
+	tests := []struct {
+		name     string
+		values   map[string]string
+		branches []BranchState
+		inputs   []InputState
+		partials []PartialStateView
+		// diagnosis the lines expected in the diagnosis
+		diagnosis []string
+	}{
+		{
+			name:      "completed",
+			values:    map[string]string{},
+			diagnosis: []string{"No request state is stored"},
+		},
+		{
+			name: "foreach branches pending",
+			values: map[string]string{
+				"request-state":                  "RUNNING",
+				"0_split-dynamic-branch-options": `["item1","item2","item3"]`,
+				"0_split-branch-completion":      "1",
+			},
+			branches: []BranchState{{
+				ExecutionID: "0_split", UniqueID: "0_split", Node: "split", Kind: "foreach",
+				Options: []string{"item1", "item2", "item3"}, Completed: 1, Pending: 2,
+			}},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"foreach node split (0_split) waits for 2 of 3 branches",
+			},
+		},
+		{
+			name: "nested condition of a foreach iteration",
+			values: map[string]string{
+				"request-state":                         "RUNNING",
+				"0_split-dynamic-branch-options":        `["item1","item2"]`,
+				"0_split-branch-completion":             "2",
+				"item1--1_check-dynamic-branch-options": `["yes","no"]`,
+				"item1--1_check-branch-completion":      "0",
+			},
+			branches: []BranchState{
+				{
+					ExecutionID: "0_split", UniqueID: "0_split", Node: "split", Kind: "foreach",
+					Options: []string{"item1", "item2"}, Completed: 2,
+				},
+				{
+					ExecutionID: "item1--1_check", UniqueID: "1_check", Node: "check", Kind: "condition",
+					Option: "item1", Options: []string{"yes", "no"}, Pending: 2,
+				},
+			},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"condition node check (1_check) for item1 waits for 2 of 2 branches",
+			},
+		},
+		{
+			name: "single branch and unknown node",
+			values: map[string]string{
+				"request-state":                   "RUNNING",
+				"1_check-dynamic-branch-options":  `["yes"]`,
+				"1_check-branch-completion":       "0",
+				"9_other-dynamic-branch-options":  `["a","b"]`,
+				"9_other-branch-completion":       "1",
+				"a--b--9_other-branch-completion": "invalid",
+			},
+			branches: []BranchState{
+				{
+					ExecutionID: "1_check", UniqueID: "1_check", Node: "check", Kind: "condition",
+					Options: []string{"yes"},
+				},
+				{
+					ExecutionID: "9_other", UniqueID: "9_other", Node: "9_other", Kind: "dynamic",
+					Options: []string{"a", "b"}, Completed: 1, Pending: 1,
+				},
+			},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"dynamic node 9_other waits for 1 of 2 branches",
+			},
+		},
+		{
+			name: "inputs pending",
+			values: map[string]string{
+				"request-state":  "RUNNING",
+				"2_join":         "1",
+				"item2--2_join":  "2",
+				"request-secret": "text",
+			},
+			inputs: []InputState{
+				{ExecutionID: "2_join", UniqueID: "2_join", Node: "join", Received: 1, InDegree: 2, Pending: 1},
+				{ExecutionID: "item2--2_join", UniqueID: "2_join", Node: "join", Option: "item2", Received: 2, InDegree: 2},
+			},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"node join (2_join) received 1 of 2 inputs",
+			},
+		},
+		{
+			name:   "nothing pending",
+			values: map[string]string{"request-state": "RUNNING"},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"No branch or input is pending",
+			},
+		},
+		{
+			name: "paused",
+			values: map[string]string{
+				"request-state": "PAUSED",
+				"partial-state": encodePartialStates(t, paused, paused),
+			},
+			partials: []PartialStateView{
+				{Position: []string{"0_split", "1_check"}, Options: map[string]string{"0_split": "item1"}, DataSize: 4},
+				{Position: []string{"0_split", "1_check"}, Options: map[string]string{"0_split": "item1"}, DataSize: 4},
+			},
+			diagnosis: []string{
+				"The request is PAUSED",
+				"2 partial requests are queued until the request is resumed",
+			},
+		},
+		{
+			name: "resumed with partial states",
+			values: map[string]string{
+				"request-state": "RUNNING",
+				"partial-state": encodePartialStates(t, paused),
+			},
+			partials: []PartialStateView{
+				{Position: []string{"0_split", "1_check"}, Options: map[string]string{"0_split": "item1"}, DataSize: 4},
+			},
+			diagnosis: []string{
+				"The request is RUNNING",
+				"1 partial requests stored while paused were not resumed",
+			},
+		},
+		{
+			name: "invalid partial state",
+			values: map[string]string{
+				"request-state": "PAUSED",
+				"partial-state": "invalid",
+			},
+			diagnosis: []string{
+				"failed to decode partial states",
+				"The request is PAUSED",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		reader := &memoryStateReader{states: map[string]map[string]map[string]string{
+			"flow": {"req1": test.values},
+		}}
+		values, err := reader.Read(context.Background(), "flow", "req1")
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		state := decodeExecutionState("flow", "req1", values, testStateNodes)
+
+		branches := make([]BranchState, 0)
+		for _, branch := range state.Branches {
+			branches = append(branches, *branch)
+		}
+		if len(test.branches) == 0 {
+			test.branches = []BranchState{}
+		}
+		if !reflect.DeepEqual(branches, test.branches) {
+			t.Errorf("%s: got branches %+v, want %+v", test.name, branches, test.branches)
+		}
+
+		inputs := make([]InputState, 0)
+		for _, input := range state.Inputs {
+			inputs = append(inputs, *input)
+		}
+		if len(test.inputs) == 0 {
+			test.inputs = []InputState{}
+		}
+		if !reflect.DeepEqual(inputs, test.inputs) {
+			t.Errorf("%s: got inputs %+v, want %+v", test.name, inputs, test.inputs)
+		}
+
+		partials := make([]PartialStateView, 0)
+		for _, partial := range state.PartialStates {
+			partials = append(partials, *partial)
+		}
+		if len(test.partials) == 0 {
+			test.partials = []PartialStateView{}
+		}
+		if !reflect.DeepEqual(partials, test.partials) {
+			t.Errorf("%s: got partial states %+v, want %+v", test.name, partials, test.partials)
+		}
+
+		if len(state.Diagnosis) != len(test.diagnosis) {
+			t.Errorf("%s: got diagnosis %q, want %q", test.name, state.Diagnosis, test.diagnosis)
+			continue
+		}
+		for i, line := range test.diagnosis {
+			if !strings.HasPrefix(state.Diagnosis[i], line) {
+				t.Errorf("%s: got diagnosis %q, want %q", test.name, state.Diagnosis[i], line)
+			}
+		}
+	}
+}
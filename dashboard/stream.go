@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	streamInterval = 2 * time.Second
+	streamDuration = 8 * time.Second
+
+	watchers     = make(map[string]*requestWatcher)
+	watchersLock sync.Mutex
+)
+
+// requestWatcher polls a single request and fans out the changes to all the
+// subscribed streams, so that multiple viewers of the same request share the
+// same upstream calls
+type requestWatcher struct {
+	flowName  string
+	requestID string
+	traceID   string
+	// listed the trace was found in the requests of the flow, a trace id given
+	// by the client is not recorded in the history
+	listed bool
+
+	lock        sync.Mutex
+	subscribers map[chan []*RequestEvent]bool
+	current     *RequestTrace
+	stop        chan struct{}
+}
+
+// isFinalState check if a request status is final
+func isFinalState(state string) bool {
+	return state == "FINISHED" || state == "FAILED"
+}
+
+// requestStatus get the status of a request from the state reported by its
+// flow and its trace. Flows only report RUNNING, PAUSED and FINISHED and clean
+// up the state of a failed request, so a failure is taken from the error tags
+// of the trace and a request whose state can't be read has ended once its
+// root span is traced
+func requestStatus(trace *RequestTrace, state string, stateErr error) string {
+	switch {
+	case trace.Failed:
+		return "FAILED"
+	case stateErr == nil && state != "":
+		return state
+	case trace.RequestID != "":
+		return "FINISHED"
+	}
+	return "UNKNOWN"
+}
+
+// diffRequestTrace compute the events that transform prev into curr
+func diffRequestTrace(prev, curr *RequestTrace) []*RequestEvent {
+	events := make([]*RequestEvent, 0)
+	if curr == nil {
+		return events
+	}
+	if prev == nil {
+		prev = &RequestTrace{NodeTraces: make(map[string]*NodeTrace)}
+	}
+
+	nodes := make([]string, 0, len(curr.NodeTraces))
+	for node := range curr.NodeTraces {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	for _, node := range nodes {
+		trace := curr.NodeTraces[node]
+		old, found := prev.NodeTraces[node]
+		if !found {
+			events = append(events, &RequestEvent{
+				Type:      "node-start",
+				Node:      node,
+				StartTime: trace.StartTime,
+			})
+		}
+		if !found || old.StartTime != trace.StartTime || old.Duration != trace.Duration {
+			events = append(events, &RequestEvent{
+				Type:      "node-end",
+				Node:      node,
+				StartTime: trace.StartTime,
+				Duration:  trace.Duration,
+			})
+		}
+	}
+
+	if prev.Status != curr.Status || prev.StartTime != curr.StartTime || prev.Duration != curr.Duration {
+		events = append(events, &RequestEvent{
+			Type:      "status",
+			Status:    curr.Status,
+			StartTime: curr.StartTime,
+			Duration:  curr.Duration,
+		})
+	}
+
+	return events
+}
+
+// getWatcher get or create the watcher of a request
+func getWatcher(flowName, requestID, traceID string) *requestWatcher {
+	key := flowName + "/" + requestID
+
+	watchersLock.Lock()
+	defer watchersLock.Unlock()
+
+	watcher, found := watchers[key]
+	if found {
+		// a stopped watcher is replaced instead of being reused
+		select {
+		case <-watcher.stop:
+			found = false
+		default:
+		}
+	}
+	if !found {
+		watcher = &requestWatcher{
+			flowName:    flowName,
+			requestID:   requestID,
+			traceID:     traceID,
+			subscribers: make(map[chan []*RequestEvent]bool),
+			stop:        make(chan struct{}),
+		}
+		watchers[key] = watcher
+		go watcher.run(key)
+	}
+	return watcher
+}
+
+// subscribe register a subscriber and replay the current state to it
+func (watcher *requestWatcher) subscribe() chan []*RequestEvent {
+	subscriber := make(chan []*RequestEvent, 16)
+
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	watcher.subscribers[subscriber] = true
+	if watcher.current != nil {
+		subscriber <- diffRequestTrace(nil, watcher.current)
+	}
+	return subscriber
+}
+
+// unsubscribe remove a subscriber, the watcher stops once it has none
+func (watcher *requestWatcher) unsubscribe(subscriber chan []*RequestEvent) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	delete(watcher.subscribers, subscriber)
+	if len(watcher.subscribers) == 0 {
+		select {
+		case <-watcher.stop:
+		default:
+			close(watcher.stop)
+		}
+	}
+}
+
+// poll fetch the latest trace and state of the request, a poll is bounded by
+// the poll interval so that a hung gateway doesn't freeze the subscribers
+func (watcher *requestWatcher) poll() *RequestTrace {
+	ctx, cancel := context.WithTimeout(context.Background(), streamInterval)
+	defer cancel()
+
+	if watcher.traceID == "" {
+		traceID, err := findRequestTraceID(ctx, watcher.flowName, watcher.requestID)
+		if err != nil {
+			log.Printf("failed to get trace of request %s, error: %v", watcher.requestID, err)
+		}
+		watcher.traceID = traceID
+		watcher.listed = traceID != ""
+	}
+
+	trace := &RequestTrace{}
+	if watcher.traceID != "" {
+		var err error
+		trace, err = listRequestTraces(ctx, watcher.traceID)
+		if err != nil {
+			log.Printf("failed to get request traces for request %s, traceId %s, error: %v",
+				watcher.requestID, watcher.traceID, err)
+			trace = &RequestTrace{TraceId: watcher.traceID}
+		}
+	}
+	if trace.NodeTraces == nil {
+		trace.NodeTraces = make(map[string]*NodeTrace)
+	}
+
+	state, err := getRequestStatus(ctx, watcher.flowName, watcher.requestID)
+	if err != nil {
+		log.Printf("failed to get request state for %s, request %s, error: %v",
+			watcher.flowName, watcher.requestID, err)
+	}
+	trace.Status = requestStatus(trace, state, err)
+	if watcher.listed {
+		recordRequest(watcher.flowName, watcher.requestID, trace)
+		activeRequests.observe(watcher.flowName, watcher.requestID, trace.Status)
+	}
+
+	return trace
+}
+
+// run poll the request periodically and publish the deltas to subscribers
+func (watcher *requestWatcher) run(key string) {
+	defer func() {
+		watchersLock.Lock()
+		if watchers[key] == watcher {
+			delete(watchers, key)
+		}
+		watchersLock.Unlock()
+	}()
+
+	ticker := time.NewTicker(streamInterval)
+	defer ticker.Stop()
+
+	for {
+		trace := watcher.poll()
+
+		watcher.lock.Lock()
+		events := diffRequestTrace(watcher.current, trace)
+		watcher.current = trace
+		if len(events) > 0 {
+			for subscriber := range watcher.subscribers {
+				select {
+				case subscriber <- events:
+				default:
+					log.Printf("dropping events for a slow subscriber of %s", key)
+				}
+			}
+		}
+		watcher.lock.Unlock()
+
+		if isFinalState(trace.Status) {
+			return
+		}
+
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requestStreamHandler stream the state transitions of a request as server sent events
+func requestStreamHandler(w http.ResponseWriter, r *http.Request) {
+
+	flowName := r.URL.Query().Get("flow-name")
+	requestID := r.URL.Query().Get("request")
+	traceID := r.URL.Query().Get("trace-id")
+	if flowName == "" || requestID == "" {
+		http.Error(w, "invalid request, flow-name and request must be specified", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// ask the client to reconnect once the stream is closed
+	fmt.Fprintf(w, "retry: %d\n\n", streamInterval/time.Millisecond)
+	flusher.Flush()
+
+	watcher := getWatcher(flowName, requestID, traceID)
+	subscriber := watcher.subscribe()
+	defer watcher.unsubscribe(subscriber)
+
+	timeout := time.After(streamDuration)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-timeout:
+			return
+
+		case events := <-subscriber:
+			final := false
+			for _, event := range events {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+				if event.Type == "status" && isFinalState(event.Status) {
+					final = true
+				}
+			}
+			if final {
+				fmt.Fprintf(w, "event: end\ndata: {}\n\n")
+			}
+			flusher.Flush()
+			if final {
+				return
+			}
+		}
+	}
+}
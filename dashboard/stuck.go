@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// inactivity after which a running request of a flow is stuck
+	stuckAfterAnnotation = "faas-flow-stuck-after"
+	// remediation of the stuck requests of a flow, stop or alert
+	stuckActionAnnotation = "faas-flow-stuck-action"
+
+	stuckActionStop  = "stop"
+	stuckActionAlert = "alert"
+
+	// user the sweeper audits its actions as
+	stuckSweeperUser = "stuck-request-sweeper"
+)
+
+var (
+	// interval between two sweeps, a zero interval disables the sweeper
+	stuckSweepInterval = time.Minute
+	// url the stuck requests of the flows with the alert action are posted to
+	stuckAlertURL = ""
+
+	stuckRequests = newStuckSweeper()
+)
+
+// stuckPolicy the inactivity threshold and the remediation of a flow
+type stuckPolicy struct {
+	after  time.Duration
+	action string
+}
+
+// stuckSweeper periodically looks for the running requests with no span
+// ended within the threshold of their flow and remediates them
+type stuckSweeper struct {
+	lock     sync.Mutex
+	requests map[string]map[string]*StuckRequest
+	swept    time.Time
+}
+
+// newStuckSweeper create a sweeper with no stuck request
+func newStuckSweeper() *stuckSweeper {
+	return &stuckSweeper{requests: make(map[string]map[string]*StuckRequest)}
+}
+
+// flowStuckPolicy get the stuck policy of a flow from its annotations, a flow
+// without threshold is not swept
+func flowStuckPolicy(function *Function) *stuckPolicy {
+	after := parseIntOrDurationValue(function.Annotations[stuckAfterAnnotation], 0)
+	if after <= 0 {
+		return nil
+	}
+	action := strings.ToLower(strings.TrimSpace(function.Annotations[stuckActionAnnotation]))
+	if action != "" && action != stuckActionStop && action != stuckActionAlert {
+		log.Printf("unknown stuck action %s of %s, stuck requests are only listed", action, function.Name)
+		action = ""
+	}
+	return &stuckPolicy{after: after, action: action}
+}
+
+// lastActivity get the end of the latest span of a request in microseconds,
+// the start of the request when no node span is reported
+func lastActivity(trace *RequestTrace) int {
+	last := trace.StartTime
+	for _, node := range trace.NodeTraces {
+		if end := node.StartTime + node.Duration; end > last {
+			last = end
+		}
+		for _, instance := range node.Instances {
+			if end := instance.StartTime + instance.Duration; end > last {
+				last = end
+			}
+		}
+	}
+	return last
+}
+
+// run sweep the flows every interval until the process exits
+func (sweeper *stuckSweeper) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		sweeper.sweep(ctx)
+		cancel()
+	}
+}
+
+// sweep detect the stuck requests of the flows with a stuck policy, new stuck
+// requests are remediated once, requests which are not stuck anymore are
+// forgotten
+func (sweeper *stuckSweeper) sweep(ctx context.Context) {
+	functions, err := listFlowFunctions()
+	if err != nil {
+		log.Printf("failed to get functions, error: %v", err)
+		return
+	}
+
+	policies := make(map[string]*stuckPolicy)
+	candidates := make([]*StuckRequest, 0)
+	for _, function := range functions {
+		policy := flowStuckPolicy(function)
+		if policy == nil {
+			continue
+		}
+		policies[function.Name] = policy
+		for _, request := range activeRequests.running(ctx, functions, function.Name) {
+			candidates = append(candidates, &StuckRequest{
+				Flow:      function.Name,
+				RequestID: request.RequestID,
+				TraceID:   request.TraceID,
+				StartTime: request.StartTime,
+				Threshold: int(policy.after / time.Microsecond),
+				Action:    policy.action,
+			})
+		}
+	}
+
+	now := int(time.Now().UnixNano() / 1000)
+	stuck := make([]bool, len(candidates))
+	completed := fanOut(ctx, len(candidates), func(ctx context.Context, index int) error {
+		candidate := candidates[index]
+		candidate.LastActivity = candidate.StartTime
+		trace, err := listRequestTraces(ctx, candidate.TraceID)
+		if err != nil {
+			// the trace server lost the spans of a request the flow still runs
+			if errorStatus(err) != http.StatusNotFound {
+				return err
+			}
+			candidate.Orphaned = true
+		} else {
+			candidate.LastActivity = lastActivity(trace)
+		}
+		candidate.Inactive = now - candidate.LastActivity
+		stuck[index] = candidate.Inactive > candidate.Threshold
+		return nil
+	})
+
+	sweeper.lock.Lock()
+	requests := make(map[string]map[string]*StuckRequest)
+	detected := make([]*StuckRequest, 0)
+	for index, candidate := range candidates {
+		previous := sweeper.requests[candidate.Flow][candidate.RequestID]
+		if !completed[index] {
+			// keep the requests whose trace can not be read until the next sweep
+			if previous != nil {
+				candidate = previous
+			} else {
+				continue
+			}
+		} else if !stuck[index] {
+			continue
+		} else if previous != nil {
+			candidate.DetectedAt = previous.DetectedAt
+			candidate.Remediation = previous.Remediation
+			// a failed stop or alert is retried on every sweep
+			if strings.HasPrefix(previous.Remediation, "failed") {
+				detected = append(detected, candidate)
+			}
+		} else {
+			candidate.DetectedAt = time.Now()
+			detected = append(detected, candidate)
+		}
+		if requests[candidate.Flow] == nil {
+			requests[candidate.Flow] = make(map[string]*StuckRequest)
+		}
+		requests[candidate.Flow][candidate.RequestID] = candidate
+	}
+	sweeper.requests = requests
+	sweeper.swept = time.Now()
+	sweeper.lock.Unlock()
+
+	for _, request := range detected {
+		log.Printf("%s request %s is stuck, no activity for %v",
+			request.Flow, request.RequestID, time.Duration(request.Inactive)*time.Microsecond)
+		remediation := sweeper.remediate(ctx, request)
+		sweeper.lock.Lock()
+		request.Remediation = remediation
+		sweeper.lock.Unlock()
+	}
+}
+
+// remediate stop a stuck request or alert on it as set for its flow
+func (sweeper *stuckSweeper) remediate(ctx context.Context, request *StuckRequest) string {
+	switch request.Action {
+	case stuckActionStop:
+		result := performControl(ctx, stuckSweeperUser, request.Flow, request.RequestID, "stop")
+		if !result.Success {
+			log.Printf("failed to stop stuck %s request %s, error: %v", request.Flow, request.RequestID, result.Error)
+			return "failed to stop, " + result.Error
+		}
+		return "stopped"
+	case stuckActionAlert:
+		if err := alertStuckRequest(ctx, request); err != nil {
+			log.Printf("failed to alert on stuck %s request %s, error: %v", request.Flow, request.RequestID, err)
+			return "failed to alert, " + err.Error()
+		}
+		return "alerted"
+	}
+	return ""
+}
+
+// alertStuckRequest post a stuck request to the alert url
+func alertStuckRequest(ctx context.Context, request *StuckRequest) error {
+	if stuckAlertURL == "" {
+		return fmt.Errorf("no alert url is configured")
+	}
+
+	data, _ := json.Marshal(request)
+	httpReq, _ := http.NewRequest(http.MethodPost, stuckAlertURL, bytes.NewReader(data))
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", jsonType)
+
+	c := http.Client{Timeout: 5 * time.Second}
+	response, err := c.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("status: %d", response.StatusCode)
+	}
+	return nil
+}
+
+// list get the stuck requests found by the last sweep, of a flow or of every
+// flow when no flow is given, the longest inactive first
+func (sweeper *stuckSweeper) list(flowName string) *StuckRequests {
+	sweeper.lock.Lock()
+	defer sweeper.lock.Unlock()
+
+	stuck := &StuckRequests{Requests: make([]*StuckRequest, 0)}
+	if !sweeper.swept.IsZero() {
+		swept := sweeper.swept
+		stuck.SweptAt = &swept
+	}
+	for flow, requests := range sweeper.requests {
+		if flowName != "" && flow != flowName {
+			continue
+		}
+		for _, request := range requests {
+			copied := *request
+			stuck.Requests = append(stuck.Requests, &copied)
+		}
+	}
+	sort.Slice(stuck.Requests, func(i, j int) bool {
+		return stuck.Requests[i].Inactive > stuck.Requests[j].Inactive
+	})
+	return stuck
+}
+
+// stuckRequestsHandler handle api request to list the stuck requests
+func stuckRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	stuck := stuckRequests.list(r.URL.Query().Get("flow-name"))
+
+	data, _ := json.MarshalIndent(stuck, "", "    ")
+	w.Header().Set("Content-Type", jsonType)
+	w.Write(data)
+}
@@ -0,0 +1,370 @@
+package function
+
+import (
+	"fmt"
+	sdk "github.com/s8sg/faas-flow/sdk"
+	"sort"
+	"strings"
+)
+
+const (
+	NODE_ADDED     = "added"
+	NODE_REMOVED   = "removed"
+	NODE_CHANGED   = "changed"
+	NODE_UNCHANGED = "unchanged"
+
+	ADDED_COLOR     = "\"#1cc88a\""
+	REMOVED_COLOR   = "\"#e74a3b\""
+	CHANGED_COLOR   = "\"#f6c23e\""
+	UNCHANGED_COLOR = "\"#d1d3e2\""
+
+	CHANGE_NODE       = "node"
+	CHANGE_EDGE       = "edge"
+	CHANGE_OPERATIONS = "operations"
+	CHANGE_BRANCH     = "branch"
+)
+
+// DagDiffRequest the two versions of a dag to compare
+type DagDiffRequest struct {
+	Old *sdk.DagExporter `json:"old"`
+	New *sdk.DagExporter `json:"new"`
+}
+
+// DagChange a structural change between two versions of a dag, the path
+// names a node by the ids of the nodes and branches leading to it
+type DagChange struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DiffOverlay the changes of the nodes of a dag merged from two versions,
+// nodes are the nodes of the merged dag
+type DiffOverlay struct {
+	nodes    map[*sdk.NodeExporter]string
+	edges    map[*sdk.NodeExporter]map[string]string
+	branches map[*sdk.NodeExporter]map[string]string
+	Changes  []*DagChange
+}
+
+// nodeKind get the structural kind of a node
+func nodeKind(node *sdk.NodeExporter) string {
+	switch {
+	case node.IsCondition:
+		return "condition"
+	case node.IsForeach:
+		return "foreach"
+	case node.SubDag != nil:
+		return "subdag"
+	default:
+		return "node"
+	}
+}
+
+// operationsSignature describe the operations of a node
+func operationsSignature(node *sdk.NodeExporter) string {
+	operations := make([]string, 0, len(node.Operations))
+	for _, operation := range node.Operations {
+		operations = append(operations, generateOperationName(operation))
+	}
+	return strings.Join(operations, ", ")
+}
+
+// nodePath get the path of a node in a dag
+func nodePath(path string, node *sdk.NodeExporter) string {
+	return path + "/" + node.Id
+}
+
+// change record a change
+func (diff *DiffOverlay) change(kind, status, path, detail string) {
+	diff.Changes = append(diff.Changes, &DagChange{Kind: kind, Status: status, Path: path, Detail: detail})
+}
+
+// setEdge set the status of the edge from a node to a child
+func (diff *DiffOverlay) setEdge(node *sdk.NodeExporter, childId, status string) {
+	edges, found := diff.edges[node]
+	if !found {
+		edges = make(map[string]string)
+		diff.edges[node] = edges
+	}
+	edges[childId] = status
+}
+
+// setBranch set the status of a condition branch of a node
+func (diff *DiffOverlay) setBranch(node *sdk.NodeExporter, condition, status string) {
+	branches, found := diff.branches[node]
+	if !found {
+		branches = make(map[string]string)
+		diff.branches[node] = branches
+	}
+	branches[condition] = status
+}
+
+// markDag mark every node, edge and branch of a dag which only exists in
+// one version
+func (diff *DiffOverlay) markDag(dag *sdk.DagExporter, status string, path string) {
+	if dag == nil {
+		return
+	}
+	for _, node := range sortedNodes(dag) {
+		diff.markNode(node, status, nodePath(path, node))
+	}
+}
+
+// markNode mark a node which only exists in one version with its content
+func (diff *DiffOverlay) markNode(node *sdk.NodeExporter, status string, path string) {
+	diff.nodes[node] = status
+	for _, childId := range node.Children {
+		diff.setEdge(node, childId, status)
+	}
+	diff.markDag(node.SubDag, status, path)
+	diff.markDag(node.ForeachDag, status, path+"[foreach]")
+	for _, condition := range sortedConditions(node) {
+		diff.setBranch(node, condition, status)
+		diff.markDag(node.ConditionalDags[condition], status, path+"["+condition+"]")
+	}
+}
+
+// mergeDag merge two versions of a dag into the new version, nodes which are
+// removed are added to the new version after its nodes
+func (diff *DiffOverlay) mergeDag(old, new *sdk.DagExporter, path string) *sdk.DagExporter {
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case old == nil:
+		diff.markDag(new, NODE_ADDED, path)
+		return new
+	case new == nil:
+		diff.markDag(old, NODE_REMOVED, path)
+		return old
+	}
+	// the removed nodes are merged into the new dag
+	if new.Nodes == nil {
+		new.Nodes = make(map[string]*sdk.NodeExporter)
+	}
+
+	maxIndex := 0
+	for _, node := range sortedNodes(new) {
+		if node.Index > maxIndex {
+			maxIndex = node.Index
+		}
+		oldNode, found := old.Nodes[node.Id]
+		if !found {
+			diff.change(CHANGE_NODE, NODE_ADDED, nodePath(path, node), nodeKind(node))
+			diff.markNode(node, NODE_ADDED, nodePath(path, node))
+			continue
+		}
+		diff.mergeNode(oldNode, node, nodePath(path, node))
+	}
+
+	// edges of the nodes in both versions
+	for _, node := range sortedNodes(new) {
+		oldNode, found := old.Nodes[node.Id]
+		if !found {
+			continue
+		}
+		diff.mergeEdges(oldNode, node, path)
+	}
+
+	for _, oldNode := range sortedNodes(old) {
+		if _, found := new.Nodes[oldNode.Id]; found {
+			continue
+		}
+		// removed nodes get a free index so their vertices are unique
+		maxIndex++
+		oldNode.Index = maxIndex
+		diff.change(CHANGE_NODE, NODE_REMOVED, nodePath(path, oldNode), nodeKind(oldNode))
+		diff.markNode(oldNode, NODE_REMOVED, nodePath(path, oldNode))
+		new.Nodes[oldNode.Id] = oldNode
+	}
+	return new
+}
+
+// mergeEdges merge the edges of a node in both versions
+func (diff *DiffOverlay) mergeEdges(old, new *sdk.NodeExporter, path string) {
+	newChildren := make(map[string]bool)
+	for _, childId := range new.Children {
+		newChildren[childId] = true
+	}
+	oldChildren := make(map[string]bool)
+	for _, childId := range old.Children {
+		oldChildren[childId] = true
+	}
+
+	for _, childId := range new.Children {
+		if oldChildren[childId] {
+			diff.setEdge(new, childId, NODE_UNCHANGED)
+			continue
+		}
+		diff.setEdge(new, childId, NODE_ADDED)
+		diff.change(CHANGE_EDGE, NODE_ADDED, nodePath(path, new), "to "+path+"/"+childId)
+	}
+	for _, childId := range old.Children {
+		if newChildren[childId] {
+			continue
+		}
+		new.Children = append(new.Children, childId)
+		if old.ChildrenExecOnly[childId] {
+			if new.ChildrenExecOnly == nil {
+				new.ChildrenExecOnly = make(map[string]bool)
+			}
+			new.ChildrenExecOnly[childId] = true
+		}
+		diff.setEdge(new, childId, NODE_REMOVED)
+		diff.change(CHANGE_EDGE, NODE_REMOVED, nodePath(path, new), "to "+path+"/"+childId)
+	}
+}
+
+// mergeNode merge a node in both versions, a node which changed its kind is
+// shown as the new version
+func (diff *DiffOverlay) mergeNode(old, new *sdk.NodeExporter, path string) {
+	status := NODE_UNCHANGED
+
+	oldKind, newKind := nodeKind(old), nodeKind(new)
+	if oldKind != newKind {
+		diff.nodes[new] = NODE_CHANGED
+		diff.change(CHANGE_NODE, NODE_CHANGED, path, fmt.Sprintf("%s to %s", oldKind, newKind))
+		diff.markDag(new.SubDag, NODE_ADDED, path)
+		diff.markDag(new.ForeachDag, NODE_ADDED, path+"[foreach]")
+		for _, condition := range sortedConditions(new) {
+			diff.setBranch(new, condition, NODE_ADDED)
+			diff.markDag(new.ConditionalDags[condition], NODE_ADDED, path+"["+condition+"]")
+		}
+		return
+	}
+
+	oldOperations, newOperations := operationsSignature(old), operationsSignature(new)
+	if oldOperations != newOperations {
+		status = NODE_CHANGED
+		diff.change(CHANGE_OPERATIONS, NODE_CHANGED, path, fmt.Sprintf("[%s] to [%s]", oldOperations, newOperations))
+	}
+	if old.DynamicExecOnly != new.DynamicExecOnly {
+		status = NODE_CHANGED
+		diff.change(CHANGE_NODE, NODE_CHANGED, path, "execution only changed")
+	}
+	diff.nodes[new] = status
+
+	new.SubDag = diff.mergeDag(old.SubDag, new.SubDag, path)
+	new.ForeachDag = diff.mergeDag(old.ForeachDag, new.ForeachDag, path+"[foreach]")
+
+	if old.ConditionalDags == nil && new.ConditionalDags == nil {
+		return
+	}
+	if new.ConditionalDags == nil {
+		new.ConditionalDags = make(map[string]*sdk.DagExporter)
+	}
+	conditions := make(map[string]bool)
+	for condition := range new.ConditionalDags {
+		conditions[condition] = true
+	}
+	for condition := range old.ConditionalDags {
+		conditions[condition] = true
+	}
+	sorted := make([]string, 0, len(conditions))
+	for condition := range conditions {
+		sorted = append(sorted, condition)
+	}
+	sort.Strings(sorted)
+
+	for _, condition := range sorted {
+		oldDag, inOld := old.ConditionalDags[condition]
+		newDag, inNew := new.ConditionalDags[condition]
+		branchPath := path + "[" + condition + "]"
+		switch {
+		case !inOld:
+			diff.setBranch(new, condition, NODE_ADDED)
+			diff.change(CHANGE_BRANCH, NODE_ADDED, path, condition)
+		case !inNew:
+			diff.setBranch(new, condition, NODE_REMOVED)
+			diff.change(CHANGE_BRANCH, NODE_REMOVED, path, condition)
+		}
+		new.ConditionalDags[condition] = diff.mergeDag(oldDag, newDag, branchPath)
+	}
+}
+
+// renumberDag set the ids of the sub dags of a merged dag as faas-flow does
+// so the vertices of both versions are unique
+func renumberDag(dag *sdk.DagExporter) {
+	if dag == nil {
+		return
+	}
+	for _, node := range dag.Nodes {
+		prefix := fmt.Sprintf("%d", node.Index)
+		if dag.Id != "0" {
+			prefix = fmt.Sprintf("%s_%d", dag.Id, node.Index)
+		}
+		if node.SubDag != nil {
+			node.SubDag.Id = prefix
+			renumberDag(node.SubDag)
+		}
+		if node.ForeachDag != nil {
+			node.ForeachDag.Id = prefix
+			renumberDag(node.ForeachDag)
+		}
+		for condition, conditionDag := range node.ConditionalDags {
+			conditionDag.Id = prefix + "_" + condition
+			renumberDag(conditionDag)
+		}
+	}
+}
+
+// diffDags merge two versions of a dag and get the changes between them
+func diffDags(old, new *sdk.DagExporter) (*sdk.DagExporter, *DiffOverlay) {
+	diff := &DiffOverlay{
+		nodes:    make(map[*sdk.NodeExporter]string),
+		edges:    make(map[*sdk.NodeExporter]map[string]string),
+		branches: make(map[*sdk.NodeExporter]map[string]string),
+		Changes:  make([]*DagChange, 0),
+	}
+	root := diff.mergeDag(old, new, "")
+	root.Id = "0"
+	renumberDag(root)
+	return root, diff
+}
+
+// nodeStatus get the change of a node
+func (diff *DiffOverlay) nodeStatus(node *sdk.NodeExporter) string {
+	if node == nil {
+		return ""
+	}
+	return diff.nodes[node]
+}
+
+// nodeColor get the color of the operations of a node by its change
+func (diff *DiffOverlay) nodeColor(node *sdk.NodeExporter, color string) string {
+	status := diff.nodeStatus(node)
+	if status == NODE_UNCHANGED || status == "" {
+		return UNCHANGED_COLOR
+	}
+	return statusColor(status)
+}
+
+// operationStatus the operations of a node have the change of the node
+func (diff *DiffOverlay) operationStatus(node *sdk.NodeExporter, opsIndex int) string {
+	return diff.nodeStatus(node)
+}
+
+// operationColor get the color of an operation of a node
+func (diff *DiffOverlay) operationColor(node *sdk.NodeExporter, opsIndex int, color string) string {
+	return diff.nodeColor(node, color)
+}
+
+// nodeLabel annotate a node label with its change
+func (diff *DiffOverlay) nodeLabel(node *sdk.NodeExporter, label string) string {
+	status := diff.nodeStatus(node)
+	if status == NODE_UNCHANGED || status == "" {
+		return label
+	}
+	return label + "\n" + status
+}
+
+// edgeStatus get the change of the edge from a node to a child
+func (diff *DiffOverlay) edgeStatus(node *sdk.NodeExporter, childId string) string {
+	return diff.edges[node][childId]
+}
+
+// branchStatus get the change of a condition branch
+func (diff *DiffOverlay) branchStatus(node *sdk.NodeExporter, condition string) string {
+	return diff.branches[node][condition]
+}
@@ -0,0 +1,209 @@
+package function
+
+import (
+	"encoding/json"
+	sdk "github.com/s8sg/faas-flow/sdk"
+	"sort"
+	"strings"
+)
+
+// Graph a renderer neutral graph of a dag, vertices and clusters are listed
+// in the order of the dag walk, a cluster contains the vertices and clusters
+// which refer to it as parent
+type Graph struct {
+	Vertices []*GraphVertex  `json:"nodes"`
+	Edges    []*GraphEdge    `json:"edges"`
+	Clusters []*GraphCluster `json:"clusters"`
+}
+
+type GraphVertex struct {
+	Id     string `json:"id"`
+	Label  string `json:"label"`
+	Kind   string `json:"kind"`
+	Shape  string `json:"shape"`
+	Style  string `json:"style"`
+	Color  string `json:"color"`
+	Status string `json:"status,omitempty"`
+	Parent string `json:"parent,omitempty"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Label  string `json:"label,omitempty"`
+	Kind   string `json:"kind"`
+	Style  string `json:"style"`
+	Color  string `json:"color"`
+	Status string `json:"status,omitempty"`
+}
+
+type GraphCluster struct {
+	Id     string `json:"id"`
+	Label  string `json:"label"`
+	Kind   string `json:"kind"`
+	Style  string `json:"style"`
+	Color  string `json:"color"`
+	Status string `json:"status,omitempty"`
+	Parent string `json:"parent,omitempty"`
+}
+
+const (
+	VERTEX_OPERATION = "operation"
+	VERTEX_CONDITION = "condition"
+	VERTEX_FOREACH   = "foreach"
+	VERTEX_END       = "end"
+
+	CLUSTER_NODE      = "node"
+	CLUSTER_CONDITION = "condition"
+	CLUSTER_FOREACH   = "foreach"
+
+	EDGE_DATA = "data"
+	EDGE_EXEC = "exec"
+)
+
+// nodeOverlay decorates the nodes and edges of a dag while it is walked, the
+// status of a node, an edge to a child or a condition branch is empty when
+// the overlay has nothing to show for it
+type nodeOverlay interface {
+	nodeStatus(node *sdk.NodeExporter) string
+	nodeColor(node *sdk.NodeExporter, color string) string
+	nodeLabel(node *sdk.NodeExporter, label string) string
+	operationStatus(node *sdk.NodeExporter, opsIndex int) string
+	operationColor(node *sdk.NodeExporter, opsIndex int, color string) string
+	edgeStatus(node *sdk.NodeExporter, childId string) string
+	branchStatus(node *sdk.NodeExporter, condition string) string
+}
+
+// graphBuilder builds a graph while walking a dag
+type graphBuilder struct {
+	graph    *Graph
+	clusters []string
+	overlay  nodeOverlay
+}
+
+// unquote strip the dot quoting of an attribute
+func unquote(value string) string {
+	return strings.Trim(value, "\"")
+}
+
+// parent get the cluster currently open
+func (builder *graphBuilder) parent() string {
+	if len(builder.clusters) == 0 {
+		return ""
+	}
+	return builder.clusters[len(builder.clusters)-1]
+}
+
+// addVertex add a vertex for a node to the open cluster
+func (builder *graphBuilder) addVertex(id, label, kind, shape, style, color string, node *sdk.NodeExporter) {
+	builder.graph.Vertices = append(builder.graph.Vertices, &GraphVertex{
+		Id:     id,
+		Label:  label,
+		Kind:   kind,
+		Shape:  shape,
+		Style:  style,
+		Color:  unquote(color),
+		Status: builder.overlay.nodeStatus(node),
+		Parent: builder.parent(),
+	})
+}
+
+// addOperationVertex add a vertex for an operation of a node, the index is
+// the index of the operation in the node
+func (builder *graphBuilder) addOperationVertex(id, label string, node *sdk.NodeExporter, opsIndex int) {
+	builder.graph.Vertices = append(builder.graph.Vertices, &GraphVertex{
+		Id:     id,
+		Label:  label,
+		Kind:   VERTEX_OPERATION,
+		Shape:  OPERATION_SHAPE,
+		Style:  OPERATION_STYLE,
+		Color:  unquote(builder.overlay.operationColor(node, opsIndex, OPERATION_COLOR)),
+		Status: builder.overlay.operationStatus(node, opsIndex),
+		Parent: builder.parent(),
+	})
+}
+
+// addEdge add an edge between two vertices
+func (builder *graphBuilder) addEdge(source, target, label, style, status string) {
+	kind := EDGE_DATA
+	if style == EXEC_EDGE_STYLE {
+		kind = EDGE_EXEC
+	}
+	builder.graph.Edges = append(builder.graph.Edges, &GraphEdge{
+		Source: source,
+		Target: target,
+		Label:  label,
+		Kind:   kind,
+		Style:  style,
+		Color:  unquote(edgeColor(status)),
+		Status: status,
+	})
+}
+
+// openCluster open a cluster in the open cluster
+func (builder *graphBuilder) openCluster(id, label, kind, style, color string, node *sdk.NodeExporter) {
+	builder.graph.Clusters = append(builder.graph.Clusters, &GraphCluster{
+		Id:     id,
+		Label:  label,
+		Kind:   kind,
+		Style:  style,
+		Color:  unquote(color),
+		Status: builder.overlay.nodeStatus(node),
+		Parent: builder.parent(),
+	})
+	builder.clusters = append(builder.clusters, id)
+}
+
+// closeCluster close the open cluster
+func (builder *graphBuilder) closeCluster() {
+	builder.clusters = builder.clusters[:len(builder.clusters)-1]
+}
+
+// sortedNodes get the nodes of a dag ordered by index so every format is
+// generated in a stable order
+func sortedNodes(dag *sdk.DagExporter) []*sdk.NodeExporter {
+	nodes := make([]*sdk.NodeExporter, 0, len(dag.Nodes))
+	for _, node := range dag.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Index < nodes[j].Index
+	})
+	return nodes
+}
+
+// sortedConditions get the conditions of a conditional node in order
+func sortedConditions(node *sdk.NodeExporter) []string {
+	conditions := make([]string, 0, len(node.ConditionalDags))
+	for condition := range node.ConditionalDags {
+		conditions = append(conditions, condition)
+	}
+	sort.Strings(conditions)
+	return conditions
+}
+
+// children get the clusters and vertices directly in a cluster
+func (graph *Graph) children(parent string) ([]*GraphCluster, []*GraphVertex) {
+	clusters := make([]*GraphCluster, 0)
+	for _, cluster := range graph.Clusters {
+		if cluster.Parent == parent {
+			clusters = append(clusters, cluster)
+		}
+	}
+	vertices := make([]*GraphVertex, 0)
+	for _, vertex := range graph.Vertices {
+		if vertex.Parent == parent {
+			vertices = append(vertices, vertex)
+		}
+	}
+	return clusters, vertices
+}
+
+// makeJsonGraph make the json node and edge list of a graph
+func makeJsonGraph(graph *Graph) (string, error) {
+	data, err := json.MarshalIndent(graph, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -4,11 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	sdk "github.com/s8sg/faas-flow/sdk"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -45,17 +44,50 @@ const (
 	CONDITION_CLUSTER_STYLE        = "rounded"
 )
 
+const (
+	FORMAT_DOT     = "dot"
+	FORMAT_MERMAID = "mermaid"
+	FORMAT_JSON    = "json"
+	FORMAT_SVG     = "svg"
+
+	// the changes of a diff as json
+	FORMAT_CHANGES = "changes"
+)
+
+// formatContentTypes content type of each supported format
+var formatContentTypes = map[string]string{
+	FORMAT_DOT:     "text/vnd.graphviz",
+	FORMAT_MERMAID: "text/plain",
+	FORMAT_JSON:    "application/json",
+	FORMAT_SVG:     "image/svg+xml",
+}
+
+// supportedFormats list the supported formats
+func supportedFormats() string {
+	formats := make([]string, 0, len(formatContentTypes))
+	for format := range formatContentTypes {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return strings.Join(formats, ", ")
+}
+
+// generateOperationName generate the name of an operation by its type
+func generateOperationName(operation *sdk.OperationExporter) string {
+	switch {
+	case operation.Properties["isFunction"][0] == "true":
+		return "func-" + operation.Name
+	case operation.Properties["isHttpRequest"][0] == "true":
+		return "callback-" + operation.Name
+	default:
+		return "modifier"
+	}
+}
+
 // generateOperationKey generate a unique key for an operation
 func generateOperationKey(dagId string, nodeIndex int, opsIndex int, operation *sdk.OperationExporter, operationStr string) string {
 	if operation != nil {
-		switch {
-		case operation.Properties["isFunction"][0] == "true":
-			operationStr = "func-" + operation.Name
-		case operation.Properties["isHttpRequest"][0] == "true":
-			operationStr = "callback-" + operation.Name
-		default:
-			operationStr = "modifier"
-		}
+		operationStr = generateOperationName(operation)
 	}
 	operationKey := ""
 	if dagId != "0" {
@@ -87,19 +119,20 @@ func generateOperationLebel(operation *sdk.OperationExporter) string {
 }
 
 // generateConditionalDag generate dag element of a condition vertex
-func generateConditionalDag(node *sdk.NodeExporter, dag *sdk.DagExporter, sb *strings.Builder, indent string) string {
+func generateConditionalDag(node *sdk.NodeExporter, dag *sdk.DagExporter, builder *graphBuilder) string {
 	// Create a condition vertex
 	conditionKey := generateOperationKey(dag.Id, node.Index, 0, nil, "conditions")
-	sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s style=%s color=%s label=\"condition\"];",
-		indent, conditionKey, CONDITION_SHAPE, CONDITION_STYLE, CONDITION_COLOR))
+	builder.addVertex(conditionKey, builder.overlay.nodeLabel(node, "condition"), VERTEX_CONDITION,
+		CONDITION_SHAPE, CONDITION_STYLE, builder.overlay.nodeColor(node, CONDITION_COLOR), node)
 
 	// Create a end operation vertex
 	conditionEndKey := generateOperationKey(dag.Id, node.Index, 0, nil, "end")
-	sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s style=%s color=%s label=\"end\"];",
-		indent, conditionEndKey, DYNAMIC_END_SHAPE, DYNAMIC_END_STYLE, DYNAMIC_END_COLOR))
+	builder.addVertex(conditionEndKey, "end", VERTEX_END,
+		DYNAMIC_END_SHAPE, DYNAMIC_END_STYLE, DYNAMIC_END_COLOR, nil)
 
 	// Create condition graph
-	for condition, conditionDag := range node.ConditionalDags {
+	for _, condition := range sortedConditions(node) {
+		conditionDag := node.ConditionalDags[condition]
 		nextOperationDag := conditionDag
 		startNodeId := nextOperationDag.StartNode
 		nextOperationNode := nextOperationDag.Nodes[startNodeId]
@@ -129,43 +162,40 @@ func generateConditionalDag(node *sdk.NodeExporter, dag *sdk.DagExporter, sb *st
 			edgeStyle = EXEC_EDGE_STYLE
 		}
 
-		sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [label=%s color=%s style=%s];",
-			indent, conditionKey, operationKey, condition, EDGE_COLOR, edgeStyle))
+		branchStatus := builder.overlay.branchStatus(node, condition)
+		branchColor := CONDITION_CLUSTER_BORDER_COLOR
+		if branchStatus != "" {
+			branchColor = statusColor(branchStatus)
+		}
 
-		sb.WriteString(fmt.Sprintf("\n%ssubgraph cluster_%s_%d_%s {", indent, dag.Id, node.Index, condition))
+		builder.addEdge(conditionKey, operationKey, condition, edgeStyle, branchStatus)
 
-		sb.WriteString(fmt.Sprintf("\n%slabel=\"%s\";", indent+"\t", condition))
-		sb.WriteString(fmt.Sprintf("\n%scolor=%s;", indent+"\t", CONDITION_CLUSTER_BORDER_COLOR))
-		sb.WriteString(fmt.Sprintf("\n%sstyle=%s;\n", indent+"\t", CONDITION_CLUSTER_STYLE))
-		sb.WriteString(fmt.Sprintf("\n%snodesep=%d;", indent+"\t", GRAPH_NODESPEC))
-		sb.WriteString(fmt.Sprintf("\n%sranksep=%d;", indent+"\t", GRAPH_RANKSPEC))
-		sb.WriteString(fmt.Sprintf("\n%spad=%d;", indent+"\t", GRAPH_PAD))
-		sb.WriteString(fmt.Sprintf("\n%spack=%d;", indent+"\t", GRAPH_PACK))
+		builder.openCluster(fmt.Sprintf("cluster_%s_%d_%s", dag.Id, node.Index, condition), condition,
+			CLUSTER_CONDITION, CONDITION_CLUSTER_STYLE, branchColor, nil)
 
-		previousOperation := generateDag(conditionDag, sb, indent+"\t")
+		previousOperation := generateDag(conditionDag, builder)
 
-		sb.WriteString(fmt.Sprintf("\n%s}", indent))
+		builder.closeCluster()
 
-		sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=%s style=%s];",
-			indent, previousOperation, conditionEndKey, EDGE_COLOR, edgeStyle))
+		builder.addEdge(previousOperation, conditionEndKey, "", edgeStyle, branchStatus)
 	}
 
 	return conditionEndKey
 }
 
 // generateForeachDag generate dag element of a foreach vertex
-func generateForeachDag(node *sdk.NodeExporter, dag *sdk.DagExporter, sb *strings.Builder, indent string) string {
+func generateForeachDag(node *sdk.NodeExporter, dag *sdk.DagExporter, builder *graphBuilder) string {
 	subdag := node.ForeachDag
 
 	// Create a foreach operation vertex
 	foreachKey := generateOperationKey(dag.Id, node.Index, 0, nil, "foreach")
-	sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s style=%s color=%s label=\"foreach\"];",
-		indent, foreachKey, FOREACH_SHAPE, FOREACH_STYLE, FOREACH_COLOR))
+	builder.addVertex(foreachKey, builder.overlay.nodeLabel(node, "foreach"), VERTEX_FOREACH,
+		FOREACH_SHAPE, FOREACH_STYLE, builder.overlay.nodeColor(node, FOREACH_COLOR), node)
 
 	// Create a end operation vertex
 	foreachEndKey := generateOperationKey(dag.Id, node.Index, 0, nil, "end")
-	sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s style=%s color=%s label=\"end\"];",
-		indent, foreachEndKey, DYNAMIC_END_SHAPE, DYNAMIC_END_STYLE, DYNAMIC_END_COLOR))
+	builder.addVertex(foreachEndKey, "end", VERTEX_END,
+		DYNAMIC_END_SHAPE, DYNAMIC_END_STYLE, DYNAMIC_END_COLOR, nil)
 
 	// Create Foreach Graph
 	{
@@ -199,81 +229,61 @@ func generateForeachDag(node *sdk.NodeExporter, dag *sdk.DagExporter, sb *string
 			edgeStyle = EXEC_EDGE_STYLE
 		}
 
-		sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=%s style=%s];",
-			indent, foreachKey, operationKey, EDGE_COLOR, edgeStyle))
+		builder.addEdge(foreachKey, operationKey, "", edgeStyle, "")
 
-		sb.WriteString(fmt.Sprintf("\n%ssubgraph cluster_%s_%d {", indent, dag.Id, node.Index))
-
-		sb.WriteString(fmt.Sprintf("\n%slabel=\"foreach\";", indent+"\t"))
-		sb.WriteString(fmt.Sprintf("\n%scolor=%s;", indent+"\t", CONDITION_CLUSTER_BORDER_COLOR))
-		sb.WriteString(fmt.Sprintf("\n%sstyle=%s;\n", indent+"\t", CONDITION_CLUSTER_STYLE))
-		sb.WriteString(fmt.Sprintf("\n%snodesep=%d;", indent+"\t", GRAPH_NODESPEC))
-		sb.WriteString(fmt.Sprintf("\n%sranksep=%d;", indent+"\t", GRAPH_RANKSPEC))
-		sb.WriteString(fmt.Sprintf("\n%spad=%d;", indent+"\t", GRAPH_PAD))
-		sb.WriteString(fmt.Sprintf("\n%spack=%d;", indent+"\t", GRAPH_PACK))
+		builder.openCluster(fmt.Sprintf("cluster_%s_%d", dag.Id, node.Index), "foreach",
+			CLUSTER_FOREACH, CONDITION_CLUSTER_STYLE, CONDITION_CLUSTER_BORDER_COLOR, nil)
 
-		previousOperation := generateDag(subdag, sb, indent+"\t")
+		previousOperation := generateDag(subdag, builder)
 
-		sb.WriteString(fmt.Sprintf("\n%s}", indent))
+		builder.closeCluster()
 
-		sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=%s style=%s];",
-			indent, previousOperation, foreachEndKey, EDGE_COLOR, edgeStyle))
+		builder.addEdge(previousOperation, foreachEndKey, "", edgeStyle, "")
 	}
 
 	return foreachEndKey
 }
 
-// generateDag populate a string buffer for a dag and returns the last operation ID
-func generateDag(dag *sdk.DagExporter, sb *strings.Builder, indent string) string {
+// generateDag populate the graph for a dag and returns the last operation ID,
+// the overlay colors the nodes by their execution or their changes
+func generateDag(dag *sdk.DagExporter, builder *graphBuilder) string {
 	lastOperation := ""
-	// gener/ate nodes
-	for _, node := range dag.Nodes {
+	// generate nodes
+	for _, node := range sortedNodes(dag) {
 
 		previousOperation := ""
 
 		if node.IsDynamic {
 			// Handle dynamic node
 			if node.IsCondition {
-				previousOperation = generateConditionalDag(node, dag, sb, indent)
+				previousOperation = generateConditionalDag(node, dag, builder)
 			}
 			if node.IsForeach {
-				previousOperation = generateForeachDag(node, dag, sb, indent)
+				previousOperation = generateForeachDag(node, dag, builder)
 			}
 		} else {
 			// Handle non dynamic node
-
-			sb.WriteString(fmt.Sprintf("\n%ssubgraph cluster_%s_%d {", indent, dag.Id, node.Index))
-			sb.WriteString(fmt.Sprintf("\n%snodesep=%d;", indent, GRAPH_NODESPEC))
-			sb.WriteString(fmt.Sprintf("\n%sranksep=%d;", indent, GRAPH_RANKSPEC))
-			sb.WriteString(fmt.Sprintf("\n%spad=%d;", indent, GRAPH_PAD))
-			sb.WriteString(fmt.Sprintf("\n%spack=%d;", indent, GRAPH_PACK))
-
-			nodeIndexStr := fmt.Sprintf("%d", node.Index-1)
-
-			if nodeIndexStr != node.Id {
-				sb.WriteString(fmt.Sprintf("\n%slabel=\"%s\";", indent+"\t", node.Id))
-			} else {
-				sb.WriteString(fmt.Sprintf("\n%slabel=\"%s\";", indent+"\t", nodeIndexStr))
+			label := fmt.Sprintf("%d", node.Index-1)
+			if label != node.Id {
+				label = node.Id
 			}
 
-			sb.WriteString(fmt.Sprintf("\n%scolor=%s;", indent+"\t", NODE_CLUSTER_BORDER_COLOR))
-			sb.WriteString(fmt.Sprintf("\n%sstyle=%s;\n", indent+"\t", NODE_CLUSTER_STYLE))
+			builder.openCluster(fmt.Sprintf("cluster_%s_%d", dag.Id, node.Index), builder.overlay.nodeLabel(node, label),
+				CLUSTER_NODE, NODE_CLUSTER_STYLE, NODE_CLUSTER_BORDER_COLOR, node)
 		}
 
 		subdag := node.SubDag
 		if subdag != nil {
-			previousOperation = generateDag(subdag, sb, indent+"\t")
+			previousOperation = generateDag(subdag, builder)
 		} else {
 			for opsindex, operation := range node.Operations {
 				operationKey := generateOperationKey(dag.Id, node.Index, opsindex+1, operation, "")
 				operationLebel := generateOperationLebel(operation)
-				sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s color=%s style=%s label=\"%s\"];",
-					indent+"\t", operationKey, OPERATION_SHAPE, OPERATION_COLOR, OPERATION_STYLE, operationLebel))
+				builder.addOperationVertex(operationKey, operationLebel, node, opsindex)
 
 				// Operations always forwards data
 				if previousOperation != "" {
-					sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=%s style=%s];",
-						indent+"\t", previousOperation, operationKey, EDGE_COLOR, DATA_EDGE_STYLE))
+					builder.addEdge(previousOperation, operationKey, "", DATA_EDGE_STYLE, "")
 				}
 				previousOperation = operationKey
 			}
@@ -281,7 +291,7 @@ func generateDag(dag *sdk.DagExporter, sb *strings.Builder, indent string) strin
 
 		// If noce is not dynamic close the subgraph cluster
 		if !node.IsDynamic {
-			sb.WriteString(fmt.Sprintf("\n%s}\n", indent))
+			builder.closeCluster()
 		}
 
 		// If node has children
@@ -323,21 +333,61 @@ func generateDag(dag *sdk.DagExporter, sb *strings.Builder, indent string) strin
 				}
 
 				if previousOperation != "" {
-					sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=%s style=%s];",
-						indent, previousOperation, childOperationKey, EDGE_COLOR, edgeStyle))
+					builder.addEdge(previousOperation, childOperationKey, "", edgeStyle,
+						builder.overlay.edgeStatus(node, childId))
 				}
 			}
 		} else {
 			lastOperation = previousOperation
 		}
-
-		sb.WriteString("\n")
 	}
 	return lastOperation
 }
 
-// makeDotGraph make dot graph by iterating each node in greedy approach
-func makeDotGraph(root *sdk.DagExporter) string {
+// makeGraph make the graph of a dag by iterating each node in greedy approach
+func makeGraph(root *sdk.DagExporter, overlay nodeOverlay) *Graph {
+	builder := &graphBuilder{
+		graph: &Graph{
+			Vertices: make([]*GraphVertex, 0),
+			Edges:    make([]*GraphEdge, 0),
+			Clusters: make([]*GraphCluster, 0),
+		},
+		overlay: overlay,
+	}
+	generateDag(root, builder)
+	return builder.graph
+}
+
+// escapeDot escape a dot string
+func escapeDot(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
+
+// generateDotCluster write the vertices and clusters directly in a cluster
+func generateDotCluster(graph *Graph, parent string, sb *strings.Builder, indent string) {
+	clusters, vertices := graph.children(parent)
+	for _, vertex := range vertices {
+		sb.WriteString(fmt.Sprintf("\n%s\"%s\" [shape=%s color=\"%s\" style=%s label=\"%s\"];",
+			indent, vertex.Id, vertex.Shape, vertex.Color, vertex.Style, escapeDot(vertex.Label)))
+	}
+	for _, cluster := range clusters {
+		sb.WriteString(fmt.Sprintf("\n%ssubgraph %s {", indent, cluster.Id))
+		sb.WriteString(fmt.Sprintf("\n%slabel=\"%s\";", indent+"\t", escapeDot(cluster.Label)))
+		sb.WriteString(fmt.Sprintf("\n%scolor=\"%s\";", indent+"\t", cluster.Color))
+		sb.WriteString(fmt.Sprintf("\n%sstyle=%s;\n", indent+"\t", cluster.Style))
+		sb.WriteString(fmt.Sprintf("\n%snodesep=%d;", indent+"\t", GRAPH_NODESPEC))
+		sb.WriteString(fmt.Sprintf("\n%sranksep=%d;", indent+"\t", GRAPH_RANKSPEC))
+		sb.WriteString(fmt.Sprintf("\n%spad=%d;", indent+"\t", GRAPH_PAD))
+		sb.WriteString(fmt.Sprintf("\n%spack=%d;", indent+"\t", GRAPH_PACK))
+		generateDotCluster(graph, cluster.Id, sb, indent+"\t")
+		sb.WriteString(fmt.Sprintf("\n%s}\n", indent))
+	}
+}
+
+// makeDotGraph make the dot graph of a graph
+func makeDotGraph(graph *Graph) string {
 	var sb strings.Builder
 
 	indent := "\t"
@@ -353,22 +403,169 @@ func makeDotGraph(root *sdk.DagExporter) string {
 
 	sb.WriteString(fmt.Sprintf("\n%snode [style=filled fontname=\"Courier\" fontcolor=black]\n", indent))
 
-	generateDag(root, &sb, indent)
+	generateDotCluster(graph, "", &sb, indent)
 
-	sb.WriteString("}\n")
+	sb.WriteString("\n")
+	for _, edge := range graph.Edges {
+		if edge.Label != "" {
+			sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [label=\"%s\" color=\"%s\" style=%s];",
+				indent, edge.Source, edge.Target, escapeDot(edge.Label), edge.Color, edge.Style))
+		} else {
+			sb.WriteString(fmt.Sprintf("\n%s\"%s\" -> \"%s\" [color=\"%s\" style=%s];",
+				indent, edge.Source, edge.Target, edge.Color, edge.Style))
+		}
+	}
+
+	sb.WriteString("\n}\n")
 	return sb.String()
 }
 
-// Handle a serverless request
-func Handle(req []byte) string {
-	values, err := url.ParseQuery(os.Getenv("Http_Query"))
+// ErrorResponse error returned to the caller
+type ErrorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// HttpError an error returned to the caller with the status code of the response
+type HttpError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *HttpError) Error() string {
+	return err.Message
+}
+
+// httpErrorf create an error with the status code of the response
+func httpErrorf(statusCode int, format string, args ...interface{}) error {
+	return &HttpError{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError prefix the message of an upstream error keeping its status code
+func wrapError(err error, message string) error {
+	status := http.StatusBadGateway
+	if httpErr, ok := err.(*HttpError); ok {
+		status = httpErr.StatusCode
+	}
+	return httpErrorf(status, "%s, %v", message, err)
+}
+
+// writeError write an error as a json response, errors without a status code
+// are internal errors
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if httpErr, ok := err.(*HttpError); ok {
+		status = httpErr.StatusCode
+	}
+	log.Printf("failed to process, error %v", err)
+
+	data, _ := json.Marshal(&ErrorResponse{Status: status, Error: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// getDagDefinition get the exported dag of a flow function
+func getDagDefinition(gatewayUrl, function string) (*sdk.DagExporter, error) {
+	bodyBytes, err := queryGateway(gatewayUrl, "function/"+function+"?export-dag=true")
+	if err != nil {
+		if httpErr, ok := err.(*HttpError); ok && httpErr.StatusCode == http.StatusNotFound {
+			return nil, httpErrorf(http.StatusNotFound, "flow %s not found", function)
+		}
+		return nil, wrapError(err, "failed to get dag definition")
+	}
+
+	if len(bodyBytes) == 0 {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to get dag definition, empty reply")
+	}
+
+	root := &sdk.DagExporter{}
+	err = json.Unmarshal(bodyBytes, root)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to read dag definition, %v", err)
+	}
+	return root, nil
+}
+
+// renderGraph render a graph in a format
+func renderGraph(graph *Graph, format string) (string, error) {
+	switch format {
+	case FORMAT_MERMAID:
+		return makeMermaidGraph(graph), nil
+	case FORMAT_SVG:
+		return makeSvgGraph(graph), nil
+	case FORMAT_JSON:
+		result, err := makeJsonGraph(graph)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate graph, %v", err)
+		}
+		return result, nil
+	default:
+		return makeDotGraph(graph), nil
+	}
+}
+
+// handleDiff render the changes between two versions of a dag posted as
+// {"old": <dag>, "new": <dag>}
+func handleDiff(w http.ResponseWriter, r *http.Request, format string) {
+	contentType, found := formatContentTypes[format]
+	if format == FORMAT_CHANGES {
+		contentType, found = "application/json", true
+	}
+	if !found {
+		writeError(w, httpErrorf(http.StatusBadRequest, "unsupported format %s, supported formats are %s, %s",
+			format, supportedFormats(), FORMAT_CHANGES))
+		return
+	}
+
+	request := &DagDiffRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		log.Fatal("No function specified")
+		writeError(w, httpErrorf(http.StatusBadRequest, "invalid diff request, %v", err))
+		return
+	}
+	if request.Old == nil || request.New == nil {
+		writeError(w, httpErrorf(http.StatusBadRequest, "invalid diff request, old and new dag are required"))
+		return
+	}
+
+	root, diff := diffDags(request.Old, request.New)
+
+	result := ""
+	if format == FORMAT_CHANGES {
+		data, _ := json.MarshalIndent(diff.Changes, "", "    ")
+		result = string(data)
+	} else {
+		result, err = renderGraph(makeGraph(root, diff), format)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(result))
+}
+
+// Handle a serverless request
+func Handle(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	format := values.Get("format")
+	if format == "" {
+		format = FORMAT_DOT
+	}
+
+	if values.Get("diff") == "true" {
+		handleDiff(w, r, format)
+		return
 	}
 
 	function := values.Get("function")
 	if len(function) <= 0 {
-		log.Fatal("No function specified")
+		writeError(w, httpErrorf(http.StatusBadRequest, "no function specified"))
+		return
 	}
 
 	gateway_url := os.Getenv("gateway_url")
@@ -376,30 +573,44 @@ func Handle(req []byte) string {
 		gateway_url = "http://gateway:8080/"
 	}
 
-	resp, err := http.Get(gateway_url + "function/" + function + "?export-dag=true")
-	if err != nil {
-		log.Fatal("failed to get dag definition, ", err.Error())
+	if values.Get("lint") == "true" {
+		handleLint(w, gateway_url, function)
+		return
 	}
 
-	defer resp.Body.Close()
-	if resp.Body == nil {
-		log.Fatal("failed to get dag definition, status code ", resp.StatusCode)
+	contentType, found := formatContentTypes[format]
+	if !found {
+		writeError(w, httpErrorf(http.StatusBadRequest, "unsupported format %s, supported formats are %s",
+			format, supportedFormats()))
+		return
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	root, err := getDagDefinition(gateway_url, function)
 	if err != nil {
-		log.Fatal("failed to get dag definition, ", err.Error())
+		writeError(w, err)
+		return
 	}
 
-	if len(bodyBytes) == 0 {
-		log.Fatal("failed to get dag definition")
+	// overlay the execution of a request
+	var overlay *ExecutionOverlay
+	requestID := values.Get("request")
+	traceID := values.Get("trace")
+	if requestID != "" || traceID != "" {
+		overlay, err = getExecutionOverlay(gateway_url, function, root, requestID, traceID,
+			values.Get("critical") == "true")
+		if err != nil {
+			writeError(w, wrapError(err, "failed to get request execution"))
+			return
+		}
 	}
 
-	root := &sdk.DagExporter{}
-	err = json.Unmarshal(bodyBytes, root)
+	result, err := renderGraph(makeGraph(root, overlay), format)
 	if err != nil {
-		log.Fatal("failed to read dag definition, ", err.Error())
+		writeError(w, err)
+		return
 	}
 
-	return makeDotGraph(root)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(result))
 }
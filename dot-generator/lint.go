@@ -0,0 +1,242 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	sdk "github.com/s8sg/faas-flow/sdk"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	LINT_ERROR   = "error"
+	LINT_WARNING = "warning"
+
+	LINT_INVALID_DAG        = "invalid-dag"
+	LINT_MISSING_FUNCTION   = "missing-function"
+	LINT_NO_FAILURE_HANDLER = "no-failure-handler"
+	LINT_NO_AGGREGATOR      = "no-aggregator"
+	LINT_UNREACHABLE_NODE   = "unreachable-node"
+)
+
+// LintIssue a problem found in a dag, the path names a node as in the dag diff
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+// LintReport the problems found in the dag of a flow, a flow passes when no
+// error is found
+type LintReport struct {
+	Flow     string       `json:"flow"`
+	Passed   bool         `json:"passed"`
+	Errors   int          `json:"errors"`
+	Warnings int          `json:"warnings"`
+	Issues   []*LintIssue `json:"issues"`
+}
+
+// dagLinter walk a dag and collect its problems, deployed is nil when the
+// deployed functions are unknown
+type dagLinter struct {
+	deployed map[string]bool
+	report   *LintReport
+}
+
+// issue record a problem
+func (linter *dagLinter) issue(severity, check, path, format string, args ...interface{}) {
+	linter.report.Issues = append(linter.report.Issues, &LintIssue{
+		Severity: severity,
+		Check:    check,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == LINT_ERROR {
+		linter.report.Errors++
+	} else {
+		linter.report.Warnings++
+	}
+}
+
+// lintDag check the nodes of a dag and of its sub dags
+func (linter *dagLinter) lintDag(dag *sdk.DagExporter, dagPath string) {
+	if dag == nil || len(dag.Nodes) == 0 {
+		return
+	}
+
+	// nodes which can not be reached from the start node never execute
+	reached := make(map[string]bool)
+	if _, found := dag.Nodes[dag.StartNode]; found {
+		pending := []string{dag.StartNode}
+		for len(pending) > 0 {
+			nodeId := pending[0]
+			pending = pending[1:]
+			if reached[nodeId] {
+				continue
+			}
+			reached[nodeId] = true
+			if node, found := dag.Nodes[nodeId]; found {
+				pending = append(pending, node.Children...)
+			}
+		}
+	}
+
+	for _, node := range sortedNodes(dag) {
+		currentPath := nodePath(dagPath, node)
+		if dag.StartNode != "" && !reached[node.Id] {
+			linter.issue(LINT_WARNING, LINT_UNREACHABLE_NODE, currentPath,
+				"node %s is not reachable from the start node %s", node.Id, dag.StartNode)
+		}
+		linter.lintNode(node, currentPath)
+	}
+}
+
+// lintNode check the operations and the branches of a node
+func (linter *dagLinter) lintNode(node *sdk.NodeExporter, nodePath string) {
+	for _, operation := range node.Operations {
+		switch {
+		case operationProperty(operation, "isFunction"):
+			if linter.deployed != nil && !linter.deployed[operation.Name] {
+				linter.issue(LINT_ERROR, LINT_MISSING_FUNCTION, nodePath,
+					"function %s is not deployed", operation.Name)
+			}
+		case operationProperty(operation, "isHttpRequest"):
+			if !operationProperty(operation, "hasFailureHandler") {
+				linter.issue(LINT_WARNING, LINT_NO_FAILURE_HANDLER, nodePath,
+					"callback %s has no failure handler", operation.Name)
+			}
+		}
+	}
+
+	// the results of the branches need an aggregator unless the branches
+	// only execute
+	if (node.IsForeach || node.IsCondition) && !node.DynamicExecOnly && !node.HasAggregator {
+		linter.issue(LINT_WARNING, LINT_NO_AGGREGATOR, nodePath,
+			"%s node %s has no aggregator for its branch results", nodeKind(node), node.Id)
+	}
+
+	linter.lintDag(node.SubDag, nodePath)
+	linter.lintDag(node.ForeachDag, nodePath+"[foreach]")
+	for _, condition := range sortedConditions(node) {
+		linter.lintDag(node.ConditionalDags[condition], nodePath+"["+condition+"]")
+	}
+}
+
+// operationProperty check if a boolean property of an operation is set
+func operationProperty(operation *sdk.OperationExporter, property string) bool {
+	values := operation.Properties[property]
+	return len(values) > 0 && values[0] == "true"
+}
+
+// lintFlow check the dag of a flow, the deployed functions are not checked
+// when deployed is nil
+func lintFlow(flow string, root *sdk.DagExporter, deployed map[string]bool) *LintReport {
+	linter := &dagLinter{
+		deployed: deployed,
+		report:   &LintReport{Flow: flow, Issues: make([]*LintIssue, 0)},
+	}
+
+	if !root.IsValid {
+		message := "dag is not valid"
+		if root.ValidationError != "" {
+			message = "dag is not valid, " + root.ValidationError
+		}
+		linter.issue(LINT_ERROR, LINT_INVALID_DAG, "", "%s", message)
+	}
+	linter.lintDag(root, "")
+
+	linter.report.Passed = linter.report.Errors == 0
+	return linter.report
+}
+
+// addBasicAuth add the gateway credentials to a request when basic auth is
+// enabled
+func addBasicAuth(req *http.Request) error {
+	if os.Getenv("basic_auth") != "true" {
+		return nil
+	}
+	secretPath := os.Getenv("secret_mount_path")
+	if secretPath == "" {
+		secretPath = "/var/openfaas/secrets/"
+	}
+	user, err := ioutil.ReadFile(path.Join(secretPath, "basic-auth-user"))
+	if err != nil {
+		return fmt.Errorf("unable to read basic auth user, %v", err)
+	}
+	password, err := ioutil.ReadFile(path.Join(secretPath, "basic-auth-password"))
+	if err != nil {
+		return fmt.Errorf("unable to read basic auth password, %v", err)
+	}
+	req.SetBasicAuth(strings.TrimSpace(string(user)), strings.TrimSpace(string(password)))
+	return nil
+}
+
+// getDeployedFunctions get the names of the functions deployed on the gateway
+func getDeployedFunctions(gatewayUrl string) (map[string]bool, error) {
+	c := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, gatewayUrl+"system/functions", nil)
+	if err := addBasicAuth(request); err != nil {
+		return nil, err
+	}
+
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d, %s", response.StatusCode, string(bodyBytes))
+	}
+
+	functions := []struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, &functions); err != nil {
+		return nil, err
+	}
+
+	deployed := make(map[string]bool)
+	for _, function := range functions {
+		deployed[function.Name] = true
+	}
+	return deployed, nil
+}
+
+// handleLint report the problems of the dag of a flow as json
+func handleLint(w http.ResponseWriter, gatewayUrl, function string) {
+	root, err := getDagDefinition(gatewayUrl, function)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	deployed, err := getDeployedFunctions(gatewayUrl)
+	report := lintFlow(function, root, deployed)
+	if err != nil {
+		// the rest of the checks are still useful without the function list
+		report.Issues = append(report.Issues, &LintIssue{
+			Severity: LINT_WARNING,
+			Check:    LINT_MISSING_FUNCTION,
+			Message:  fmt.Sprintf("deployed functions not checked, failed to list functions, %v", err),
+		})
+		report.Warnings++
+	}
+
+	data, _ := json.MarshalIndent(report, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
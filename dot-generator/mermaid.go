@@ -0,0 +1,89 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mermaidIds map the graph ids to mermaid safe ids
+type mermaidIds map[string]string
+
+// get the mermaid id of a graph id
+func (ids mermaidIds) get(id, prefix string) string {
+	mermaidId, found := ids[id]
+	if !found {
+		mermaidId = fmt.Sprintf("%s%d", prefix, len(ids))
+		ids[id] = mermaidId
+	}
+	return mermaidId
+}
+
+// escapeMermaid escape a mermaid label
+func escapeMermaid(value string) string {
+	value = strings.Replace(value, "\"", "#quot;", -1)
+	return strings.Replace(value, "\n", "<br/>", -1)
+}
+
+// mermaidVertex write the mermaid vertex of a shape
+func mermaidVertex(id, shape, label string) string {
+	label = escapeMermaid(label)
+	switch shape {
+	case CONDITION_SHAPE:
+		return fmt.Sprintf("%s{\"%s\"}", id, label)
+	case DYNAMIC_END_SHAPE:
+		return fmt.Sprintf("%s[/\"%s\"\\]", id, label)
+	default:
+		return fmt.Sprintf("%s[\"%s\"]", id, label)
+	}
+}
+
+// generateMermaidCluster write the vertices and subgraphs directly in a cluster
+func generateMermaidCluster(graph *Graph, parent string, ids mermaidIds, sb *strings.Builder, indent string) {
+	clusters, vertices := graph.children(parent)
+	for _, vertex := range vertices {
+		sb.WriteString(fmt.Sprintf("\n%s%s", indent, mermaidVertex(ids.get(vertex.Id, "n"), vertex.Shape, vertex.Label)))
+	}
+	for _, cluster := range clusters {
+		sb.WriteString(fmt.Sprintf("\n%ssubgraph %s[\"%s\"]", indent, ids.get(cluster.Id, "c"), escapeMermaid(cluster.Label)))
+		generateMermaidCluster(graph, cluster.Id, ids, sb, indent+"    ")
+		sb.WriteString(fmt.Sprintf("\n%send", indent))
+	}
+}
+
+// makeMermaidGraph make the mermaid flowchart of a graph
+func makeMermaidGraph(graph *Graph) string {
+	var sb strings.Builder
+	ids := make(mermaidIds)
+
+	indent := "    "
+	sb.WriteString("flowchart TD")
+
+	generateMermaidCluster(graph, "", ids, &sb, indent)
+
+	for _, edge := range graph.Edges {
+		arrow := "-->"
+		if edge.Style == EXEC_EDGE_STYLE {
+			arrow = "-.->"
+		}
+		if edge.Label != "" {
+			arrow = fmt.Sprintf("%s|\"%s\"|", arrow, escapeMermaid(edge.Label))
+		}
+		sb.WriteString(fmt.Sprintf("\n%s%s %s %s", indent, ids.get(edge.Source, "n"), arrow, ids.get(edge.Target, "n")))
+	}
+
+	for _, vertex := range graph.Vertices {
+		sb.WriteString(fmt.Sprintf("\n%sstyle %s fill:%s", indent, ids.get(vertex.Id, "n"), vertex.Color))
+	}
+	// links are styled by their order of declaration
+	for index, edge := range graph.Edges {
+		if edge.Status != "" {
+			sb.WriteString(fmt.Sprintf("\n%slinkStyle %d stroke:%s", indent, index, edge.Color))
+		}
+	}
+	for _, cluster := range graph.Clusters {
+		sb.WriteString(fmt.Sprintf("\n%sstyle %s fill:none,stroke:%s", indent, ids.get(cluster.Id, "c"), cluster.Color))
+	}
+
+	sb.WriteString("\n")
+	return sb.String()
+}
@@ -0,0 +1,498 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	sdk "github.com/s8sg/faas-flow/sdk"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	NODE_NOT_REACHED = "not-reached"
+	NODE_RUNNING     = "running"
+	NODE_COMPLETED   = "completed"
+	NODE_FAILED      = "failed"
+	NODE_CRITICAL    = "critical"
+
+	NOT_REACHED_COLOR = "\"#d1d3e2\""
+	RUNNING_COLOR     = "\"#f6c23e\""
+	COMPLETED_COLOR   = "\"#1cc88a\""
+	FAILED_COLOR      = "\"#e74a3b\""
+	CRITICAL_COLOR    = "\"#4e73df\""
+)
+
+// Objects to retrive the request traces from metrics
+
+// NodeInstance an execution of a node, the status is FAILED when its span is
+// tagged with an error
+type NodeInstance struct {
+	Status string `json:"status"`
+}
+
+// OperationTrace an execution of an operation of a node, by operation id
+type OperationTrace struct {
+	Name     string `json:"name"`
+	Duration int    `json:"duration"`
+	Status   string `json:"status"`
+}
+
+type NodeTrace struct {
+	StartTime  int               `json:"start-time"`
+	Duration   int               `json:"duration"`
+	Instances  []*NodeInstance   `json:"instances"`
+	Operations []*OperationTrace `json:"operations"`
+}
+
+type RequestTrace struct {
+	RequestID  string                `json:"request-id"`
+	NodeTraces map[string]*NodeTrace `json:"traces"`
+	StartTime  int                   `json:"start-time"`
+	Duration   int                   `json:"duration"`
+	// Failed the request or one of its nodes failed, from the span error tags
+	Failed bool `json:"failed"`
+}
+
+type RequestSummary struct {
+	RequestID string `json:"request-id"`
+	TraceID   string `json:"trace-id"`
+}
+
+type RequestList struct {
+	Requests []*RequestSummary `json:"requests"`
+}
+
+// CriticalPath the nodes which determine the duration of a request
+type CriticalPath struct {
+	Nodes []string `json:"nodes"`
+}
+
+// NodeExecution execution of a node in a request, with the status of each
+// operation of the node
+type NodeExecution struct {
+	Status     string
+	Duration   int
+	Operations []string
+}
+
+// ExecutionOverlay execution of the dag nodes in a request, by node unique id,
+// the critical path is marked when it is requested. The state is the status
+// of the request, RUNNING, PAUSED, FINISHED, FAILED or UNKNOWN
+type ExecutionOverlay struct {
+	RequestID string
+	State     string
+	// FailureTraced a node span carries the failure of the request
+	FailureTraced bool
+	Nodes         map[string]*NodeExecution
+	Critical      map[string]bool
+	// CriticalEdges the edges of the critical path by parent unique id and child id
+	CriticalEdges map[string]bool
+}
+
+// statusColor get the fill color of a node status
+func statusColor(status string) string {
+	switch status {
+	case NODE_RUNNING:
+		return RUNNING_COLOR
+	case NODE_COMPLETED:
+		return COMPLETED_COLOR
+	case NODE_FAILED:
+		return FAILED_COLOR
+	case NODE_ADDED:
+		return ADDED_COLOR
+	case NODE_REMOVED:
+		return REMOVED_COLOR
+	case NODE_CHANGED:
+		return CHANGED_COLOR
+	default:
+		return NOT_REACHED_COLOR
+	}
+}
+
+// edgeColor get the color of an edge status
+func edgeColor(status string) string {
+	switch status {
+	case NODE_ADDED, NODE_REMOVED:
+		return statusColor(status)
+	case NODE_CRITICAL:
+		return CRITICAL_COLOR
+	default:
+		return EDGE_COLOR
+	}
+}
+
+// formatDuration format a duration in microseconds
+func formatDuration(duration int) string {
+	switch {
+	case duration < 1000:
+		return fmt.Sprintf("%dµs", duration)
+	case duration < 1000000:
+		return fmt.Sprintf("%.1fms", float64(duration)/1000)
+	default:
+		return fmt.Sprintf("%.2fs", float64(duration)/1000000)
+	}
+}
+
+// execution get the execution of a node, nil when no overlay is applied
+func (overlay *ExecutionOverlay) execution(node *sdk.NodeExporter) *NodeExecution {
+	if overlay == nil {
+		return nil
+	}
+	execution, found := overlay.Nodes[node.UniqueId]
+	if !found {
+		return &NodeExecution{Status: NODE_NOT_REACHED}
+	}
+	return execution
+}
+
+// nodeStatus get the status of a node, empty when no overlay is applied
+func (overlay *ExecutionOverlay) nodeStatus(node *sdk.NodeExporter) string {
+	if overlay == nil || node == nil {
+		return ""
+	}
+	return overlay.execution(node).Status
+}
+
+// nodeColor get the color of the operations of a node
+func (overlay *ExecutionOverlay) nodeColor(node *sdk.NodeExporter, color string) string {
+	execution := overlay.execution(node)
+	if execution == nil {
+		return color
+	}
+	return statusColor(execution.Status)
+}
+
+// operationStatus get the status of an operation of a node, empty when no
+// overlay is applied
+func (overlay *ExecutionOverlay) operationStatus(node *sdk.NodeExporter, opsIndex int) string {
+	execution := overlay.execution(node)
+	if execution == nil {
+		return ""
+	}
+	if opsIndex < len(execution.Operations) {
+		return execution.Operations[opsIndex]
+	}
+	return execution.Status
+}
+
+// operationColor get the color of an operation of a node
+func (overlay *ExecutionOverlay) operationColor(node *sdk.NodeExporter, opsIndex int, color string) string {
+	status := overlay.operationStatus(node, opsIndex)
+	if status == "" {
+		return color
+	}
+	return statusColor(status)
+}
+
+// nodeLabel annotate a node label with the node duration
+func (overlay *ExecutionOverlay) nodeLabel(node *sdk.NodeExporter, label string) string {
+	execution := overlay.execution(node)
+	if execution == nil {
+		return label
+	}
+	if overlay.Critical[node.UniqueId] {
+		label = label + "\n" + NODE_CRITICAL
+	}
+	switch execution.Status {
+	case NODE_COMPLETED:
+		return label + "\n" + formatDuration(execution.Duration)
+	case NODE_RUNNING, NODE_FAILED:
+		return label + "\n" + execution.Status
+	}
+	return label
+}
+
+// edgeStatus an edge between two nodes of the critical path is critical
+func (overlay *ExecutionOverlay) edgeStatus(node *sdk.NodeExporter, childId string) string {
+	if overlay == nil || !overlay.CriticalEdges[node.UniqueId+"->"+childId] {
+		return ""
+	}
+	return NODE_CRITICAL
+}
+
+// branchStatus an execution has no branch status
+func (overlay *ExecutionOverlay) branchStatus(node *sdk.NodeExporter, condition string) string {
+	return ""
+}
+
+// hasTraces check if any node of a dag was traced
+func hasTraces(dag *sdk.DagExporter, traces map[string]*NodeTrace) bool {
+	for _, node := range dag.Nodes {
+		if _, found := traces[node.UniqueId]; found {
+			return true
+		}
+		if node.SubDag != nil && hasTraces(node.SubDag, traces) {
+			return true
+		}
+		if node.ForeachDag != nil && hasTraces(node.ForeachDag, traces) {
+			return true
+		}
+		for _, conditionDag := range node.ConditionalDags {
+			if hasTraces(conditionDag, traces) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// failed check if an execution of a node failed
+func (trace *NodeTrace) failed() bool {
+	for _, instance := range trace.Instances {
+		if instance.Status == "FAILED" {
+			return true
+		}
+	}
+	return false
+}
+
+// untracedStatus get the status of a node which is reached but not traced, a
+// node span is only reported once the node ends so the node is running while
+// the request runs. The node failed when the request failed without a node
+// span carrying the failure, otherwise it was never executed
+func (overlay *ExecutionOverlay) untracedStatus() string {
+	switch overlay.State {
+	case "RUNNING", "PAUSED":
+		return NODE_RUNNING
+	case "FAILED":
+		if !overlay.FailureTraced {
+			return NODE_FAILED
+		}
+	}
+	return NODE_NOT_REACHED
+}
+
+// operationStatuses get the status of the operations of a node, operations
+// are matched to their spans by operation id. Without operation spans the
+// operations have the status of the node, otherwise an operation without
+// span is running if it is the next one of a running node
+func operationStatuses(node *sdk.NodeExporter, trace *NodeTrace, status string) []string {
+	traced := make(map[string]string)
+	if trace != nil {
+		for _, operation := range trace.Operations {
+			switch {
+			case operation.Status == "FAILED":
+				traced[operation.Name] = NODE_FAILED
+			case traced[operation.Name] == "":
+				traced[operation.Name] = NODE_COMPLETED
+			}
+		}
+	}
+
+	statuses := make([]string, len(node.Operations))
+	running := status == NODE_RUNNING
+	for index, operation := range node.Operations {
+		operationStatus, found := traced[operation.Name]
+		switch {
+		case found:
+		case len(traced) == 0:
+			operationStatus = status
+		case running:
+			operationStatus = NODE_RUNNING
+			running = false
+		default:
+			operationStatus = NODE_NOT_REACHED
+		}
+		statuses[index] = operationStatus
+	}
+	return statuses
+}
+
+// markDag mark the execution of the nodes of a dag, a node which is reached
+// but not traced is marked by the status of the request
+func (overlay *ExecutionOverlay) markDag(dag *sdk.DagExporter, traces map[string]*NodeTrace, reached bool) {
+	parents := make(map[string][]string)
+	for nodeId, node := range dag.Nodes {
+		for _, childId := range node.Children {
+			parents[childId] = append(parents[childId], nodeId)
+		}
+	}
+
+	for nodeId, node := range dag.Nodes {
+		nodeReached := false
+		if nodeId == dag.StartNode {
+			nodeReached = reached
+		} else if len(parents[nodeId]) > 0 {
+			nodeReached = true
+			for _, parentId := range parents[nodeId] {
+				if _, found := traces[dag.Nodes[parentId].UniqueId]; !found {
+					nodeReached = false
+					break
+				}
+			}
+		}
+
+		execution := &NodeExecution{Status: NODE_NOT_REACHED}
+		trace, traced := traces[node.UniqueId]
+		if traced {
+			execution.Status = NODE_COMPLETED
+			if trace.failed() {
+				execution.Status = NODE_FAILED
+			}
+			execution.Duration = trace.Duration
+			nodeReached = true
+		} else if nodeReached {
+			execution.Status = overlay.untracedStatus()
+		}
+		execution.Operations = operationStatuses(node, trace, execution.Status)
+		overlay.Nodes[node.UniqueId] = execution
+
+		if overlay.Critical[node.UniqueId] {
+			for _, childId := range node.Children {
+				if child, found := dag.Nodes[childId]; found && overlay.Critical[child.UniqueId] {
+					overlay.CriticalEdges[node.UniqueId+"->"+childId] = true
+				}
+			}
+		}
+
+		if node.SubDag != nil {
+			overlay.markDag(node.SubDag, traces, nodeReached)
+		}
+		if node.ForeachDag != nil {
+			overlay.markDag(node.ForeachDag, traces, nodeReached)
+		}
+		// only the branches taken by the request are reached
+		for _, conditionDag := range node.ConditionalDags {
+			overlay.markDag(conditionDag, traces, nodeReached && hasTraces(conditionDag, traces))
+		}
+	}
+}
+
+// buildExecutionOverlay map the node traces of a request onto the dag nodes,
+// the critical path is nil when it is not marked
+func buildExecutionOverlay(root *sdk.DagExporter, trace *RequestTrace, state string, path *CriticalPath) *ExecutionOverlay {
+	overlay := &ExecutionOverlay{
+		RequestID:     trace.RequestID,
+		State:         state,
+		Nodes:         make(map[string]*NodeExecution),
+		Critical:      make(map[string]bool),
+		CriticalEdges: make(map[string]bool),
+	}
+	if path != nil {
+		for _, node := range path.Nodes {
+			overlay.Critical[node] = true
+		}
+	}
+	traces := trace.NodeTraces
+	if traces == nil {
+		traces = make(map[string]*NodeTrace)
+	}
+	for _, nodeTrace := range traces {
+		if nodeTrace.failed() {
+			overlay.FailureTraced = true
+		}
+	}
+	overlay.markDag(root, traces, true)
+	return overlay
+}
+
+// requestStatus get the status of a request, a failure is taken from the span
+// error tags as the state of a failed request is cleaned up. A request whose
+// state can't be found but whose request span is reported has ended
+func requestStatus(trace *RequestTrace, state string, stateErr error) string {
+	state = strings.TrimSpace(state)
+	switch {
+	case trace.Failed:
+		return "FAILED"
+	case stateErr == nil && state != "":
+		return state
+	case trace.RequestID != "":
+		return "FINISHED"
+	}
+	return "UNKNOWN"
+}
+
+// queryGateway get the response body of a gateway request
+func queryGateway(gatewayUrl string, path string) ([]byte, error) {
+	resp, err := http.Get(gatewayUrl + path)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "%v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "%v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := string(bodyBytes)
+		upstream := &ErrorResponse{}
+		if json.Unmarshal(bodyBytes, upstream) == nil && upstream.Error != "" {
+			message = upstream.Error
+		}
+		// only a missing resource is reported as is, other failures are upstream failures
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, httpErrorf(http.StatusNotFound, "%s", message)
+		}
+		return nil, httpErrorf(http.StatusBadGateway, "status code %d, %s", resp.StatusCode, message)
+	}
+	return bodyBytes, nil
+}
+
+// findTraceID find the trace id of a request from the flow request list
+func findTraceID(gatewayUrl, function, requestID string) (string, error) {
+	bodyBytes, err := queryGateway(gatewayUrl,
+		"function/metrics?method=list&limit=0&function="+url.QueryEscape(function))
+	if err != nil {
+		return "", wrapError(err, "failed to get requests")
+	}
+
+	requests := &RequestList{}
+	err = json.Unmarshal(bodyBytes, requests)
+	if err != nil {
+		return "", httpErrorf(http.StatusBadGateway, "failed to read requests, %v", err)
+	}
+
+	for _, request := range requests.Requests {
+		if request.RequestID == requestID {
+			return request.TraceID, nil
+		}
+	}
+	return "", httpErrorf(http.StatusNotFound, "request %s not found", requestID)
+}
+
+// getExecutionOverlay get the traces and the state of a request and map them
+// onto the dag, along with the critical path when critical is set
+func getExecutionOverlay(gatewayUrl, function string, root *sdk.DagExporter, requestID, traceID string, critical bool) (*ExecutionOverlay, error) {
+	var err error
+	if traceID == "" {
+		traceID, err = findTraceID(gatewayUrl, function, requestID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bodyBytes, err := queryGateway(gatewayUrl, "function/metrics?method=traces&trace="+url.QueryEscape(traceID))
+	if err != nil {
+		return nil, wrapError(err, "failed to get request traces")
+	}
+	trace := &RequestTrace{}
+	err = json.Unmarshal(bodyBytes, trace)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to read request traces, %v", err)
+	}
+	if requestID == "" {
+		requestID = trace.RequestID
+	}
+
+	// the state is best effort, the state of a completed request is removed
+	bodyBytes, stateErr := queryGateway(gatewayUrl, "function/"+function+"?state="+url.QueryEscape(requestID))
+	state := requestStatus(trace, string(bodyBytes), stateErr)
+
+	var path *CriticalPath
+	if critical {
+		bodyBytes, err = queryGateway(gatewayUrl, "function/metrics?method=critical-path&trace="+url.QueryEscape(traceID))
+		if err != nil {
+			return nil, wrapError(err, "failed to get request critical path")
+		}
+		path = &CriticalPath{}
+		err = json.Unmarshal(bodyBytes, path)
+		if err != nil {
+			return nil, httpErrorf(http.StatusBadGateway, "failed to read request critical path, %v", err)
+		}
+	}
+
+	return buildExecutionOverlay(root, trace, state, path), nil
+}
@@ -0,0 +1,368 @@
+package function
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+const (
+	SVG_MARGIN         = 20
+	SVG_RANK_GAP       = 50
+	SVG_VERTEX_GAP     = 30
+	SVG_CLUSTER_PAD    = 12
+	SVG_CHAR_WIDTH     = 7.2
+	SVG_LINE_HEIGHT    = 14
+	SVG_FONT           = "Courier New, monospace"
+	SVG_FONT_SIZE      = 12
+	SVG_TEXT_COLOR     = "#44413b"
+	SVG_MIN_VERTEX_W   = 80
+	SVG_VERTEX_PADDING = 24
+)
+
+// svgBox a laid out vertex or cluster
+type svgBox struct {
+	x, y, w, h float64
+}
+
+// svgLayout a layered layout of a graph, vertices are ranked by the longest
+// path from a source and ordered in a rank by the dag walk order
+type svgLayout struct {
+	graph    *Graph
+	vertices map[string]*svgBox
+	clusters map[string]*svgBox
+	depths   map[string]int
+	width    float64
+	height   float64
+}
+
+// labelSize get the size of a text label
+func labelSize(label string) (float64, float64) {
+	lines := strings.Split(label, "\n")
+	longest := 0
+	for _, line := range lines {
+		if length := len([]rune(line)); length > longest {
+			longest = length
+		}
+	}
+	return float64(longest) * SVG_CHAR_WIDTH, float64(len(lines)) * SVG_LINE_HEIGHT
+}
+
+// vertexSize get the size of a vertex
+func vertexSize(vertex *GraphVertex) (float64, float64) {
+	w, h := labelSize(vertex.Label)
+	w = w + SVG_VERTEX_PADDING
+	if w < SVG_MIN_VERTEX_W {
+		w = SVG_MIN_VERTEX_W
+	}
+	h = h + 20
+	if vertex.Shape == CONDITION_SHAPE {
+		return w * 1.4, h * 1.6
+	}
+	return w, h
+}
+
+// rankVertices rank the vertices by the longest path from a source
+func rankVertices(graph *Graph) map[string]int {
+	incoming := make(map[string]int)
+	outgoing := make(map[string][]string)
+	for _, edge := range graph.Edges {
+		incoming[edge.Target]++
+		outgoing[edge.Source] = append(outgoing[edge.Source], edge.Target)
+	}
+
+	ranks := make(map[string]int)
+	queue := make([]string, 0)
+	for _, vertex := range graph.Vertices {
+		if incoming[vertex.Id] == 0 {
+			queue = append(queue, vertex.Id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, target := range outgoing[id] {
+			if ranks[id]+1 > ranks[target] {
+				ranks[target] = ranks[id] + 1
+			}
+			incoming[target]--
+			if incoming[target] == 0 {
+				queue = append(queue, target)
+			}
+		}
+	}
+	return ranks
+}
+
+// clusterPaths get the clusters enclosing each vertex, outermost first
+func clusterPaths(graph *Graph) map[string][]string {
+	parents := make(map[string]string)
+	for _, cluster := range graph.Clusters {
+		parents[cluster.Id] = cluster.Parent
+	}
+	paths := make(map[string][]string)
+	for _, vertex := range graph.Vertices {
+		path := make([]string, 0)
+		for parent := vertex.Parent; parent != ""; parent = parents[parent] {
+			path = append([]string{parent}, path...)
+		}
+		paths[vertex.Id] = path
+	}
+	return paths
+}
+
+// clusterGap get the horizontal gap between two neighbour vertices, leaving
+// room for the borders of the clusters enclosing only one of them
+func clusterGap(left, right []string) float64 {
+	common := 0
+	for common < len(left) && common < len(right) && left[common] == right[common] {
+		common++
+	}
+	borders := len(left) - common + len(right) - common
+	return SVG_VERTEX_GAP + float64(borders*SVG_CLUSTER_PAD)
+}
+
+// layoutGraph compute the position of the vertices and clusters of a graph
+func layoutGraph(graph *Graph) *svgLayout {
+	layout := &svgLayout{
+		graph:    graph,
+		vertices: make(map[string]*svgBox),
+		clusters: make(map[string]*svgBox),
+		depths:   make(map[string]int),
+	}
+
+	ranks := rankVertices(graph)
+	maxRank := 0
+	for _, rank := range ranks {
+		if rank > maxRank {
+			maxRank = rank
+		}
+	}
+
+	// vertices are listed in walk order, which keeps clusters together
+	rows := make([][]*GraphVertex, maxRank+1)
+	for _, vertex := range graph.Vertices {
+		rank := ranks[vertex.Id]
+		rows[rank] = append(rows[rank], vertex)
+	}
+
+	paths := clusterPaths(graph)
+
+	y := 0.0
+	widest := 0.0
+	previousDepth := 0
+	rowWidths := make([]float64, len(rows))
+	for rank, row := range rows {
+		// leave room for the borders and labels of the clusters in between
+		depth := 0
+		for _, vertex := range row {
+			if len(paths[vertex.Id]) > depth {
+				depth = len(paths[vertex.Id])
+			}
+		}
+		if rank > 0 {
+			y = y + SVG_RANK_GAP + float64(previousDepth*SVG_CLUSTER_PAD+depth*(SVG_CLUSTER_PAD+SVG_LINE_HEIGHT))
+		}
+		previousDepth = depth
+
+		x := 0.0
+		rowHeight := 0.0
+		for index, vertex := range row {
+			if index > 0 {
+				x = x + clusterGap(paths[row[index-1].Id], paths[vertex.Id])
+			}
+			w, h := vertexSize(vertex)
+			layout.vertices[vertex.Id] = &svgBox{x: x, y: y, w: w, h: h}
+			x = x + w
+			if h > rowHeight {
+				rowHeight = h
+			}
+		}
+		rowWidths[rank] = x
+		if rowWidths[rank] > widest {
+			widest = rowWidths[rank]
+		}
+		// center the vertices vertically in the row
+		for _, vertex := range row {
+			box := layout.vertices[vertex.Id]
+			box.y = box.y + (rowHeight-box.h)/2
+		}
+		y = y + rowHeight
+	}
+
+	// center the rows horizontally
+	for rank, row := range rows {
+		shift := (widest - rowWidths[rank]) / 2
+		for _, vertex := range row {
+			layout.vertices[vertex.Id].x += shift
+		}
+	}
+
+	layout.layoutClusters()
+
+	// move everything into the canvas
+	minX, minY, maxX, maxY := 0.0, 0.0, widest, y
+	for _, box := range layout.clusters {
+		if box.x < minX {
+			minX = box.x
+		}
+		if box.y < minY {
+			minY = box.y
+		}
+		if box.x+box.w > maxX {
+			maxX = box.x + box.w
+		}
+		if box.y+box.h > maxY {
+			maxY = box.y + box.h
+		}
+	}
+	for _, boxes := range []map[string]*svgBox{layout.vertices, layout.clusters} {
+		for _, box := range boxes {
+			box.x = box.x - minX + SVG_MARGIN
+			box.y = box.y - minY + SVG_MARGIN
+		}
+	}
+	layout.width = maxX - minX + 2*SVG_MARGIN
+	layout.height = maxY - minY + 2*SVG_MARGIN
+
+	return layout
+}
+
+// layoutClusters compute the bounding box of each cluster around its
+// vertices and nested clusters
+func (layout *svgLayout) layoutClusters() {
+	var nest func(parent string, depth int) (float64, float64, float64, float64, bool)
+	nest = func(parent string, depth int) (float64, float64, float64, float64, bool) {
+		clusters, vertices := layout.graph.children(parent)
+		minX, minY, maxX, maxY := 0.0, 0.0, 0.0, 0.0
+		found := false
+		extend := func(x1, y1, x2, y2 float64) {
+			if !found || x1 < minX {
+				minX = x1
+			}
+			if !found || y1 < minY {
+				minY = y1
+			}
+			if !found || x2 > maxX {
+				maxX = x2
+			}
+			if !found || y2 > maxY {
+				maxY = y2
+			}
+			found = true
+		}
+		for _, vertex := range vertices {
+			box := layout.vertices[vertex.Id]
+			extend(box.x, box.y, box.x+box.w, box.y+box.h)
+		}
+		for _, cluster := range clusters {
+			layout.depths[cluster.Id] = depth
+			x1, y1, x2, y2, ok := nest(cluster.Id, depth+1)
+			if !ok {
+				continue
+			}
+			pad := float64(SVG_CLUSTER_PAD)
+			box := &svgBox{x: x1 - pad, y: y1 - pad - SVG_LINE_HEIGHT, w: x2 - x1 + 2*pad, h: y2 - y1 + 2*pad + SVG_LINE_HEIGHT}
+			layout.clusters[cluster.Id] = box
+			extend(box.x, box.y, box.x+box.w, box.y+box.h)
+		}
+		return minX, minY, maxX, maxY, found
+	}
+	nest("", 0)
+}
+
+// writeSvgText write a possibly multiline text centered on a point
+func writeSvgText(sb *strings.Builder, x, y float64, anchor, text string) {
+	lines := strings.Split(text, "\n")
+	top := y - float64(len(lines)-1)*SVG_LINE_HEIGHT/2
+	sb.WriteString(fmt.Sprintf("\n<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%s\" dominant-baseline=\"middle\">", x, top, anchor))
+	for index, line := range lines {
+		dy := 0
+		if index > 0 {
+			dy = SVG_LINE_HEIGHT
+		}
+		sb.WriteString(fmt.Sprintf("<tspan x=\"%.1f\" dy=\"%d\">%s</tspan>", x, dy, html.EscapeString(line)))
+	}
+	sb.WriteString("</text>")
+}
+
+// writeSvgShape write the shape of a vertex
+func writeSvgShape(sb *strings.Builder, box *svgBox, vertex *GraphVertex) {
+	cx, cy := box.x+box.w/2, box.y+box.h/2
+	fill := html.EscapeString(vertex.Color)
+	switch vertex.Shape {
+	case CONDITION_SHAPE:
+		sb.WriteString(fmt.Sprintf("\n<polygon points=\"%.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f\" fill=\"%s\"/>",
+			cx, box.y, box.x+box.w, cy, cx, box.y+box.h, box.x, cy, fill))
+	case DYNAMIC_END_SHAPE:
+		sb.WriteString(fmt.Sprintf("\n<polygon points=\"%.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f\" fill=\"%s\"/>",
+			box.x, box.y, box.x+box.w, box.y, box.x+box.w, box.y+box.h*2/3, cx, box.y+box.h, box.x, box.y+box.h*2/3, fill))
+	default:
+		sb.WriteString(fmt.Sprintf("\n<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" rx=\"4\" fill=\"%s\"/>",
+			box.x, box.y, box.w, box.h, fill))
+	}
+}
+
+// makeSvgGraph render a graph as svg
+func makeSvgGraph(graph *Graph) string {
+	layout := layoutGraph(graph)
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.0f\" height=\"%.0f\" viewBox=\"0 0 %.0f %.0f\" font-family=\"%s\" font-size=\"%d\" fill=\"%s\">",
+		layout.width, layout.height, layout.width, layout.height, SVG_FONT, SVG_FONT_SIZE, SVG_TEXT_COLOR))
+	sb.WriteString(fmt.Sprintf("\n<defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"10\" refY=\"5\" markerWidth=\"8\" markerHeight=\"8\" orient=\"auto\"><path d=\"M0,0 L10,5 L0,10 z\" fill=\"%s\"/></marker></defs>",
+		unquote(EDGE_COLOR)))
+
+	// outer clusters are drawn first
+	clusters := make([]*GraphCluster, 0, len(graph.Clusters))
+	for _, cluster := range graph.Clusters {
+		if _, found := layout.clusters[cluster.Id]; found {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return layout.depths[clusters[i].Id] < layout.depths[clusters[j].Id]
+	})
+	for _, cluster := range clusters {
+		box := layout.clusters[cluster.Id]
+		sb.WriteString(fmt.Sprintf("\n<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" rx=\"8\" fill=\"none\" stroke=\"%s\"/>",
+			box.x, box.y, box.w, box.h, html.EscapeString(cluster.Color)))
+		label := strings.Replace(cluster.Label, "\n", " ", -1)
+		writeSvgText(&sb, box.x+8, box.y+SVG_LINE_HEIGHT, "start", label)
+	}
+
+	for _, edge := range graph.Edges {
+		source, sourceFound := layout.vertices[edge.Source]
+		target, targetFound := layout.vertices[edge.Target]
+		if !sourceFound || !targetFound {
+			continue
+		}
+		x1, y1 := source.x+source.w/2, source.y+source.h
+		x2, y2 := target.x+target.w/2, target.y
+		middle := (y1 + y2) / 2
+		dash := ""
+		if edge.Style == EXEC_EDGE_STYLE {
+			dash = " stroke-dasharray=\"4,4\""
+		}
+		sb.WriteString(fmt.Sprintf("\n<path d=\"M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f\" fill=\"none\" stroke=\"%s\"%s marker-end=\"url(#arrow)\"/>",
+			x1, y1, x1, middle, x2, middle, x2, y2, html.EscapeString(edge.Color), dash))
+		if edge.Label != "" {
+			writeSvgText(&sb, (x1+x2)/2+4, middle, "start", edge.Label)
+		}
+	}
+
+	for _, vertex := range graph.Vertices {
+		box := layout.vertices[vertex.Id]
+		sb.WriteString(fmt.Sprintf("\n<g id=\"%s\">", html.EscapeString(vertex.Id)))
+		if vertex.Status != "" {
+			sb.WriteString(fmt.Sprintf("<title>%s</title>", html.EscapeString(vertex.Status)))
+		}
+		writeSvgShape(&sb, box, vertex)
+		writeSvgText(&sb, box.x+box.w/2, box.y+box.h/2, "middle", vertex.Label)
+		sb.WriteString("\n</g>")
+	}
+
+	sb.WriteString("\n</svg>\n")
+	return sb.String()
+}
@@ -0,0 +1,214 @@
+// Package eventhandler reports the events of the faas-flow requests to the
+// metrics function of the tower, which serves the requests and their traces
+// from them when no tracing server is deployed
+package eventhandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// events queued at most before they are posted without waiting a flush
+	maxPendingEvents = 1000
+)
+
+var (
+	client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// event an event of the event ingest api, the time is in microseconds
+type event struct {
+	Type      string `json:"type"`
+	Flow      string `json:"flow"`
+	RequestID string `json:"request-id"`
+	Node      string `json:"node,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Time      int64  `json:"time"`
+	Error     string `json:"error,omitempty"`
+}
+
+// TowerEventHandler implements the faas-flow sdk.EventHandler, the events are
+// queued and posted to the tower as ndjson when the executor flushes them.
+// The executor reports a node by its unique id only, so the iterations of a
+// foreach node are shown by their order rather than by their option
+type TowerEventHandler struct {
+	url   string
+	token string
+
+	lock     sync.Mutex
+	flowName string
+	pending  []*event
+}
+
+// GetTowerEventHandler create an event handler posting to the metrics
+// function of the tower, such as http://gateway.openfaas:8080/function/metrics,
+// the token is the event-ingest-token secret of the metrics function
+func GetTowerEventHandler(metricsURL, token string) (*TowerEventHandler, error) {
+	parsed, err := url.Parse(metricsURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid metrics url %s", metricsURL)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no event ingest token, the metrics function refuses events without it")
+	}
+	return &TowerEventHandler{
+		url:   strings.TrimSuffix(metricsURL, "/") + "?method=events",
+		token: token,
+	}, nil
+}
+
+// Configure set the flow of the events
+func (handler *TowerEventHandler) Configure(flowName string, requestId string) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	handler.flowName = flowName
+}
+
+// Init check the event handler is configured for a flow
+func (handler *TowerEventHandler) Init() error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	if handler.flowName == "" {
+		return fmt.Errorf("event handler is not configured for a flow")
+	}
+	return nil
+}
+
+// report queue an event, a failure is reported with its error
+func (handler *TowerEventHandler) report(eventType, requestID, nodeID, operationID string, err error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	reported := &event{
+		Type:      eventType,
+		Flow:      handler.flowName,
+		RequestID: requestID,
+		Node:      nodeID,
+		Operation: operationID,
+		Time:      time.Now().UnixNano() / 1000,
+	}
+	if err != nil {
+		reported.Error = err.Error()
+	}
+	handler.pending = append(handler.pending, reported)
+
+	if len(handler.pending) >= maxPendingEvents {
+		events := handler.pending
+		handler.pending = nil
+		go handler.post(events)
+	}
+}
+
+// ReportRequestStart report a start of request
+func (handler *TowerEventHandler) ReportRequestStart(requestId string) {
+	handler.report("request-start", requestId, "", "", nil)
+}
+
+// ReportRequestEnd reports an end of request, the executor reports it at the
+// end of every execution of a request
+func (handler *TowerEventHandler) ReportRequestEnd(requestId string) {
+	handler.report("request-end", requestId, "", "", nil)
+}
+
+// ReportRequestFailure reports a failure of a request with error
+func (handler *TowerEventHandler) ReportRequestFailure(requestId string, err error) {
+	handler.report("request-failure", requestId, "", "", err)
+}
+
+// ReportExecutionForward report that an execution is forwarded
+func (handler *TowerEventHandler) ReportExecutionForward(nodeId string, requestId string) {
+	handler.report("execution-forward", requestId, nodeId, "", nil)
+}
+
+// ReportExecutionContinuation report that an execution is being continued
+func (handler *TowerEventHandler) ReportExecutionContinuation(requestId string) {
+	handler.report("execution-continuation", requestId, "", "", nil)
+}
+
+// ReportNodeStart report a start of a Node execution
+func (handler *TowerEventHandler) ReportNodeStart(nodeId string, requestId string) {
+	handler.report("node-start", requestId, nodeId, "", nil)
+}
+
+// ReportNodeEnd report an end of a node execution
+func (handler *TowerEventHandler) ReportNodeEnd(nodeId string, requestId string) {
+	handler.report("node-end", requestId, nodeId, "", nil)
+}
+
+// ReportNodeFailure report a Node execution failure with error
+func (handler *TowerEventHandler) ReportNodeFailure(nodeId string, requestId string, err error) {
+	handler.report("node-failure", requestId, nodeId, "", err)
+}
+
+// ReportOperationStart reports start of an operation
+func (handler *TowerEventHandler) ReportOperationStart(operationId string, nodeId string, requestId string) {
+	handler.report("operation-start", requestId, nodeId, operationId, nil)
+}
+
+// ReportOperationEnd reports an end of an operation
+func (handler *TowerEventHandler) ReportOperationEnd(operationId string, nodeId string, requestId string) {
+	handler.report("operation-end", requestId, nodeId, operationId, nil)
+}
+
+// ReportOperationFailure reports failure of an operation with error
+func (handler *TowerEventHandler) ReportOperationFailure(operationId string, nodeId string, requestId string, err error) {
+	handler.report("operation-failure", requestId, nodeId, operationId, err)
+}
+
+// Flush post the queued events, events which can not be posted are dropped
+func (handler *TowerEventHandler) Flush() {
+	handler.lock.Lock()
+	events := handler.pending
+	handler.pending = nil
+	handler.lock.Unlock()
+
+	handler.post(events)
+}
+
+// post send events to the event ingest api
+func (handler *TowerEventHandler) post(events []*event) {
+	if len(events) == 0 {
+		return
+	}
+	if err := handler.send(events); err != nil {
+		log.Printf("failed to post %d events to the tower, error: %v", len(events), err)
+	}
+}
+
+// send encode events as ndjson and post them
+func (handler *TowerEventHandler) send(events []*event) error {
+	body := bytes.Buffer{}
+	encoder := json.NewEncoder(&body)
+	for _, reported := range events {
+		if err := encoder.Encode(reported); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, handler.url, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set("Authorization", "Bearer "+handler.token)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %d", response.StatusCode)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package function
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/openfaas/openfaas-cloud/sdk"
 	"io/ioutil"
 	"log"
@@ -10,8 +11,25 @@ import (
 	"time"
 )
 
+// ErrorResponse error returned to the caller
+type ErrorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeError write an error as a json response
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("failed to list functions, error %s", message)
+
+	data, _ := json.Marshal(&ErrorResponse{Status: status, Error: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
 // Handle a serverless request
-func Handle(req []byte) string {
+func Handle(w http.ResponseWriter, r *http.Request) {
 
 	gatewayURL := os.Getenv("gateway_url")
 
@@ -23,12 +41,14 @@ func Handle(req []byte) string {
 
 	addAuthErr := sdk.AddBasicAuth(httpReq)
 	if addAuthErr != nil {
-		log.Fatalf("Basic auth error %s", addAuthErr)
+		writeError(w, http.StatusInternalServerError, "basic auth error %s", addAuthErr)
+		return
 	}
 
 	response, err := c.Do(httpReq)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, http.StatusBadGateway, "unable to query functions, %v", err)
+		return
 	}
 
 	filtered := []function{}
@@ -36,17 +56,20 @@ func Handle(req []byte) string {
 	defer response.Body.Close()
 	bodyBytes, bErr := ioutil.ReadAll(response.Body)
 	if bErr != nil {
-		log.Fatal(bErr)
+		writeError(w, http.StatusBadGateway, "unable to read functions, %v", bErr)
+		return
 	}
 
 	if response.StatusCode != http.StatusOK {
-		log.Fatalf("unable to query functions, status: %d, message: %s", response.StatusCode, string(bodyBytes))
+		writeError(w, http.StatusBadGateway, "unable to query functions, status: %d, message: %s", response.StatusCode, string(bodyBytes))
+		return
 	}
 
 	functions := []function{}
 	mErr := json.Unmarshal(bodyBytes, &functions)
 	if mErr != nil {
-		log.Fatal(mErr)
+		writeError(w, http.StatusBadGateway, "unable to read functions, %v", mErr)
+		return
 	}
 
 	for _, fn := range functions {
@@ -58,14 +81,17 @@ func Handle(req []byte) string {
 	}
 
 	bytesOut, _ := json.Marshal(filtered)
-	return string(bytesOut)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytesOut)
 }
 
 type function struct {
-	Name            string            `json:"name"`
-	Image           string            `json:"image"`
-	InvocationCount float64           `json:"invocationCount"`
-	Replicas        uint64            `json:"replicas"`
-	Labels          map[string]string `json:"labels"`
-	Annotations     map[string]string `json:"annotations"`
+	Name              string            `json:"name"`
+	Image             string            `json:"image"`
+	InvocationCount   float64           `json:"invocationCount"`
+	Replicas          uint64            `json:"replicas"`
+	AvailableReplicas uint64            `json:"availableReplicas"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
 }
@@ -0,0 +1,165 @@
+// Package logger sends the logs of the faas-flow requests to the tower, where
+// they are shown on the monitor page of each request
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// path of the log ingestion api of the tower
+	ingestPath = "/api/flow/request/logs/ingest"
+	// lines posted in a batch at most
+	defaultBatchSize = 100
+	// delay after which the pending lines are posted
+	defaultFlushInterval = time.Second
+)
+
+var (
+	client = &http.Client{Timeout: 5 * time.Second}
+)
+
+// logLine a line of the log ingestion api, the time is in microseconds
+type logLine struct {
+	Flow      string `json:"flow"`
+	RequestID string `json:"request-id"`
+	Time      int64  `json:"time"`
+	Message   string `json:"message"`
+}
+
+// TowerLogger implements the faas-flow sdk.Logger, the lines are batched and
+// posted to the tower as ndjson. A logger is configured for a single request,
+// GetLogger of the flow must create one per request
+type TowerLogger struct {
+	url      string
+	token    string
+	flowName string
+
+	// BatchSize lines posted in a batch at most
+	BatchSize int
+	// FlushInterval delay after which the pending lines are posted
+	FlushInterval time.Duration
+
+	lock      sync.Mutex
+	requestID string
+	pending   []*logLine
+	timer     *time.Timer
+}
+
+// GetTowerLogger create a logger posting to the dashboard of the tower, such
+// as http://gateway.openfaas:8080/function/faas-flow-dashboard, the token is
+// the log-ingest-token secret of the dashboard and the flow name is required
+func GetTowerLogger(towerURL, token, flowName string) (*TowerLogger, error) {
+	parsed, err := url.Parse(towerURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid tower url %s", towerURL)
+	}
+	if flowName == "" {
+		return nil, fmt.Errorf("flow name is required")
+	}
+	return &TowerLogger{
+		url:           strings.TrimSuffix(towerURL, "/") + ingestPath,
+		token:         token,
+		flowName:      flowName,
+		BatchSize:     defaultBatchSize,
+		FlushInterval: defaultFlushInterval,
+	}, nil
+}
+
+// Configure set the request of the logs, the faas-flow executor passes the
+// request id first. The flow is the one the logger is created for
+func (logger *TowerLogger) Configure(requestId string, flowName string) {
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+
+	logger.requestID = requestId
+}
+
+// Init check the logger is configured for a request
+func (logger *TowerLogger) Init() error {
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+
+	if logger.flowName == "" || logger.requestID == "" {
+		return fmt.Errorf("logger is not configured for a request")
+	}
+	return nil
+}
+
+// Log queue a line, the lines are posted once a batch is full or after the
+// flush interval
+func (logger *TowerLogger) Log(str string) {
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+
+	logger.pending = append(logger.pending, &logLine{
+		Flow:      logger.flowName,
+		RequestID: logger.requestID,
+		Time:      time.Now().UnixNano() / 1000,
+		Message:   strings.TrimRight(str, "\n"),
+	})
+	if len(logger.pending) >= logger.BatchSize {
+		go logger.Flush()
+		return
+	}
+	if logger.timer == nil {
+		logger.timer = time.AfterFunc(logger.FlushInterval, logger.Flush)
+	}
+}
+
+// Flush post the pending lines, lines which can not be posted are dropped
+func (logger *TowerLogger) Flush() {
+	logger.lock.Lock()
+	lines := logger.pending
+	logger.pending = nil
+	if logger.timer != nil {
+		logger.timer.Stop()
+		logger.timer = nil
+	}
+	logger.lock.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+	if err := logger.post(lines); err != nil {
+		log.Printf("failed to post %d log lines to the tower, error: %v", len(lines), err)
+	}
+}
+
+// post send lines to the log ingestion api
+func (logger *TowerLogger) post(lines []*logLine) error {
+	body := bytes.Buffer{}
+	encoder := json.NewEncoder(&body)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, logger.url, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if logger.token != "" {
+		request.Header.Set("Authorization", "Bearer "+logger.token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnalyticsBuckets = 30
+	maxAnalyticsBuckets     = 500
+	// concurrent trace and state lookups of the analytics
+	analyticsWorkers = 8
+)
+
+// ThroughputBucket the requests started in an interval, times are in microseconds
+type ThroughputBucket struct {
+	Time     int `json:"time"`
+	Requests int `json:"requests"`
+	Failed   int `json:"failed"`
+}
+
+// LatencyPercentiles the end to end latency of the requests in microseconds
+type LatencyPercentiles struct {
+	P50 int `json:"p50"`
+	P95 int `json:"p95"`
+	P99 int `json:"p99"`
+}
+
+// NodeStatistics the durations of a node over the requests in microseconds
+type NodeStatistics struct {
+	Node    string `json:"node"`
+	Count   int    `json:"count"`
+	Average int    `json:"average"`
+	Max     int    `json:"max"`
+}
+
+// NodeCount the number of requests a node appears in
+type NodeCount struct {
+	Node  string `json:"node"`
+	Count int    `json:"count"`
+}
+
+// FlowAnalytics the aggregated statistics of the requests of a flow started
+// within a window
+type FlowAnalytics struct {
+	Flow         string              `json:"flow"`
+	Start        int                 `json:"start"`
+	End          int                 `json:"end"`
+	Requests     int                 `json:"requests"`
+	Failed       int                 `json:"failed"`
+	FailureRate  float64             `json:"failure-rate"`
+	Throughput   []*ThroughputBucket `json:"throughput"`
+	Latency      *LatencyPercentiles `json:"latency"`
+	SlowestNodes []*NodeStatistics   `json:"slowest-nodes"`
+	// FailedLastNodes the nodes the failed requests ended in
+	FailedLastNodes []*NodeCount `json:"failed-last-nodes"`
+	// Truncated set when the window holds more requests than analysed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// analysedRequest the trace and the final state of a request
+type analysedRequest struct {
+	summary *RequestSummary
+	trace   *RequestTrace
+	state   string
+}
+
+// percentile get a percentile of sorted values
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+// lastNode get the node of a request which ended last
+func lastNode(trace *RequestTrace) string {
+	last, lastEnd := "", 0
+	for node, nodeTrace := range trace.NodeTraces {
+		end := nodeTrace.StartTime + nodeTrace.Duration
+		if end > lastEnd || (end == lastEnd && node > last) {
+			last, lastEnd = node, end
+		}
+	}
+	return last
+}
+
+// failedNode get the node a failed request ended in, the node which ended
+// last among the nodes whose span is tagged with an error, or the node which
+// ended last when the failure is only reported on the request span
+func failedNode(trace *RequestTrace) string {
+	failed, failedEnd := "", 0
+	for node, nodeTrace := range trace.NodeTraces {
+		for _, instance := range nodeTrace.Instances {
+			if instance.Status != "FAILED" {
+				continue
+			}
+			end := instance.StartTime + instance.Duration
+			if failed == "" || end > failedEnd || (end == failedEnd && node > failed) {
+				failed, failedEnd = node, end
+			}
+		}
+	}
+	if failed == "" {
+		return lastNode(trace)
+	}
+	return failed
+}
+
+// analyseRequests get the trace and the state of the requests, requests whose
+// trace can not be read are skipped
+func analyseRequests(backend TraceBackend, gatewayURL, flow string, summaries []*RequestSummary) []*analysedRequest {
+	results := make([]*analysedRequest, len(summaries))
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < analyticsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				summary := summaries[index]
+				trace, err := buildRequestTrace(backend, summary.TraceID)
+				if err != nil {
+					continue
+				}
+				state, err := requestState(gatewayURL, flow, summary.RequestID)
+				if err != nil {
+					state = strings.ToUpper(unknownStatus)
+				}
+				results[index] = &analysedRequest{summary: summary, trace: trace, state: state}
+			}
+		}()
+	}
+	for index := range summaries {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	analysed := make([]*analysedRequest, 0, len(results))
+	for _, result := range results {
+		if result != nil {
+			analysed = append(analysed, result)
+		}
+	}
+	return analysed
+}
+
+// buildFlowAnalytics aggregate the requests of a flow started within a window
+func buildFlowAnalytics(backend TraceBackend, gatewayURL, flow string, start, end, buckets int) (*FlowAnalytics, error) {
+	if end <= 0 {
+		end = int(time.Now().UnixNano() / 1000)
+	}
+	if start <= 0 {
+		start = end - int(time.Hour/time.Microsecond)
+	}
+	if start >= end {
+		return nil, httpErrorf(400, "invalid window, start %d is not before end %d", start, end)
+	}
+	if buckets <= 0 {
+		buckets = defaultAnalyticsBuckets
+	}
+
+	traces, err := backend.ListRequests(flow, &SearchQuery{Start: start, End: end, Limit: maxSearchLimit})
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*RequestSummary, 0, len(traces))
+	for _, trace := range traces {
+		summary := summarizeRequest(trace)
+		if summary == nil || summary.StartTime < start || summary.StartTime > end {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+
+	analytics := &FlowAnalytics{
+		Flow:            flow,
+		Start:           start,
+		End:             end,
+		Throughput:      make([]*ThroughputBucket, buckets),
+		Latency:         &LatencyPercentiles{},
+		SlowestNodes:    make([]*NodeStatistics, 0),
+		FailedLastNodes: make([]*NodeCount, 0),
+		Truncated:       len(traces) >= maxSearchLimit,
+	}
+	interval := (end - start + buckets - 1) / buckets
+	for i := range analytics.Throughput {
+		analytics.Throughput[i] = &ThroughputBucket{Time: start + i*interval}
+	}
+
+	durations := make([]int, 0, len(summaries))
+	nodes := make(map[string]*NodeStatistics)
+	nodeTotals := make(map[string]int)
+	failedLast := make(map[string]int)
+
+	for _, request := range analyseRequests(backend, gatewayURL, flow, summaries) {
+		// the state of a failed request is cleaned up, the failure is known
+		// from the span error tags
+		failed := request.trace.Failed
+		analytics.Requests++
+		index := (request.summary.StartTime - start) / interval
+		if index >= buckets {
+			index = buckets - 1
+		}
+		bucket := analytics.Throughput[index]
+		bucket.Requests++
+		if failed {
+			analytics.Failed++
+			bucket.Failed++
+			if node := failedNode(request.trace); node != "" {
+				failedLast[node]++
+			}
+		}
+		if !isActiveState(request.state) {
+			durations = append(durations, request.trace.Duration)
+		}
+
+		for node, nodeTrace := range request.trace.NodeTraces {
+			stats, found := nodes[node]
+			if !found {
+				stats = &NodeStatistics{Node: node}
+				nodes[node] = stats
+			}
+			stats.Count++
+			nodeTotals[node] += nodeTrace.Duration
+			if nodeTrace.Duration > stats.Max {
+				stats.Max = nodeTrace.Duration
+			}
+		}
+	}
+
+	if analytics.Requests > 0 {
+		analytics.FailureRate = float64(analytics.Failed) / float64(analytics.Requests)
+	}
+
+	// the latency of the completed requests
+	sort.Ints(durations)
+	analytics.Latency.P50 = percentile(durations, 0.50)
+	analytics.Latency.P95 = percentile(durations, 0.95)
+	analytics.Latency.P99 = percentile(durations, 0.99)
+
+	for node, stats := range nodes {
+		stats.Average = nodeTotals[node] / stats.Count
+		analytics.SlowestNodes = append(analytics.SlowestNodes, stats)
+	}
+	sort.Slice(analytics.SlowestNodes, func(i, j int) bool {
+		if analytics.SlowestNodes[i].Average == analytics.SlowestNodes[j].Average {
+			return analytics.SlowestNodes[i].Node < analytics.SlowestNodes[j].Node
+		}
+		return analytics.SlowestNodes[i].Average > analytics.SlowestNodes[j].Average
+	})
+
+	for node, count := range failedLast {
+		analytics.FailedLastNodes = append(analytics.FailedLastNodes, &NodeCount{Node: node, Count: count})
+	}
+	sort.Slice(analytics.FailedLastNodes, func(i, j int) bool {
+		if analytics.FailedLastNodes[i].Count == analytics.FailedLastNodes[j].Count {
+			return analytics.FailedLastNodes[i].Node < analytics.FailedLastNodes[j].Node
+		}
+		return analytics.FailedLastNodes[i].Count > analytics.FailedLastNodes[j].Count
+	})
+
+	return analytics, nil
+}
+
+// flowAnalytics encode the analytics of a flow
+func flowAnalytics(backend TraceBackend, gatewayURL, flow string, start, end, buckets int) (string, error) {
+	analytics, err := buildFlowAnalytics(backend, gatewayURL, flow, start, end, buckets)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.MarshalIndent(analytics, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode flow analytics, error %v", err)
+	}
+	return string(encoded), nil
+}
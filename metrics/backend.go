@@ -0,0 +1,154 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Span a single span of a request trace, times are in microseconds
+type Span struct {
+	TraceID       string
+	SpanID        string
+	ParentID      string
+	OperationName string
+	StartTime     int
+	Duration      int
+	// Tags the tags of the span as strings
+	Tags map[string]string
+}
+
+// Trace the spans of a request
+type Trace struct {
+	TraceID string
+	Spans   []*Span
+}
+
+// SearchQuery bounds the traces searched in the trace server, times are
+// in microseconds and zero values are unbounded
+type SearchQuery struct {
+	Start int
+	End   int
+	Limit int
+}
+
+// HttpError an error returned to the caller with the status code of the response
+type HttpError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *HttpError) Error() string {
+	return err.Message
+}
+
+// httpErrorf create an error with the status code of the response
+func httpErrorf(statusCode int, format string, args ...interface{}) error {
+	return &HttpError{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
+
+// TraceBackend retrieves the traces of flow requests from a tracing server
+type TraceBackend interface {
+	// ListRequests list the request traces of a flow, the traces must contain
+	// at least the root span of each request
+	ListRequests(function string, query *SearchQuery) ([]*Trace, error)
+	// GetRequestTrace get the complete trace of a request
+	GetRequestTrace(traceID string) (*Trace, error)
+}
+
+// getTraceBackend initialize the trace backend by its name
+func getTraceBackend(name string, traceURL string) (TraceBackend, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	switch name {
+	case "", "jaeger":
+		return &JaegerBackend{url: traceURL, client: client}, nil
+	case "zipkin":
+		return &ZipkinBackend{url: traceURL, client: client}, nil
+	case "tempo":
+		return &TempoBackend{url: traceURL, client: client}, nil
+	case "events":
+		return &EventsBackend{store: events}, nil
+	}
+	return nil, httpErrorf(http.StatusInternalServerError, "unknown trace backend %s", name)
+}
+
+// instanceKeyTags the span tags which identify the foreach iteration or the
+// conditional branch a node is executed for, in order of precedence
+var instanceKeyTags = []string{"option", "foreach-key", "condition"}
+
+// executionIDTag the span tag holding the GetNodeExecutionUniqueId of a node
+// execution, the options of the enclosing foreach or conditional branches
+// followed by the node unique id, joined by "--"
+const executionIDTag = "execution-id"
+
+// instanceKey get the foreach iteration or the condition of a span, from its
+// key tags or else from the options of its execution id
+func instanceKey(span *Span) string {
+	for _, tag := range instanceKeyTags {
+		if key := span.Tags[tag]; key != "" {
+			return key
+		}
+	}
+	executionID := span.Tags[executionIDTag]
+	if strings.HasSuffix(executionID, "--"+span.OperationName) {
+		return strings.TrimSuffix(executionID, "--"+span.OperationName)
+	}
+	return ""
+}
+
+// spanStatus get the status of a node execution from its span
+func spanStatus(span *Span) string {
+	if span.Tags["error"] == "true" {
+		return "FAILED"
+	}
+	return "FINISHED"
+}
+
+// isRootSpan check if a span is the root span of a request
+func isRootSpan(span *Span) bool {
+	return span.ParentID == "" || span.SpanID == span.TraceID
+}
+
+// nodeSpan get the node span of a span, the span descending from the request
+// span. A span whose parent is not in the trace is a node span
+func nodeSpan(spans map[string]*Span, span *Span) *Span {
+	for depth := 0; depth < len(spans); depth++ {
+		parent, found := spans[span.ParentID]
+		if !found || parent == span || isRootSpan(parent) {
+			return span
+		}
+		span = parent
+	}
+	return span
+}
+
+// queryTraceServer request the trace server and return the response body
+func queryTraceServer(client *http.Client, request *http.Request) ([]byte, error) {
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to request trace service, error %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to read trace result, read error %v", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, httpErrorf(http.StatusNotFound, "trace not found, %s", bodyBytes)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to request trace service, status code %d, %s", resp.StatusCode, bodyBytes)
+	}
+
+	if len(bodyBytes) == 0 {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to get request traces, empty result")
+	}
+
+	return bodyBytes, nil
+}
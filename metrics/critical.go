@@ -0,0 +1,236 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// SpanTrace a span of a request trace with its parent, times are in microseconds
+type SpanTrace struct {
+	SpanID    string `json:"span-id"`
+	ParentID  string `json:"parent-id,omitempty"`
+	Node      string `json:"node"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Critical  bool   `json:"critical,omitempty"`
+}
+
+// PathSegment a part of the critical path spent in a span, the request is
+// waiting when no node on the path executes
+type PathSegment struct {
+	Node      string `json:"node"`
+	SpanID    string `json:"span-id"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Waiting   bool   `json:"waiting,omitempty"`
+}
+
+// NodeContribution the time a node executes on the critical path
+type NodeContribution struct {
+	Node     string  `json:"node"`
+	Duration int     `json:"duration"`
+	Share    float64 `json:"share"`
+}
+
+// CriticalPath the chain of spans which determines the duration of a request
+type CriticalPath struct {
+	RequestID string `json:"request-id"`
+	TraceID   string `json:"trace-id"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Executing int    `json:"executing"`
+	Waiting   int    `json:"waiting"`
+	// Nodes the nodes on the critical path in the order they execute
+	Nodes       []string            `json:"nodes"`
+	Segments    []*PathSegment      `json:"segments"`
+	Bottlenecks []*NodeContribution `json:"bottlenecks"`
+	Spans       []*SpanTrace        `json:"spans"`
+}
+
+// spanTree the spans of a trace by parent
+type spanTree struct {
+	root     *Span
+	end      map[*Span]int
+	parent   map[*Span]*Span
+	children map[*Span][]*Span
+}
+
+// buildSpanTree link the spans of a trace to their parent, spans whose parent
+// is not in the trace are attached to the request span. The request span ends
+// with the latest span as it may be reported before the nodes end
+func buildSpanTree(trace *Trace) (*spanTree, error) {
+	tree := &spanTree{
+		end:      make(map[*Span]int),
+		parent:   make(map[*Span]*Span),
+		children: make(map[*Span][]*Span),
+	}
+	spans := make(map[string]*Span)
+	for _, span := range trace.Spans {
+		if tree.root == nil && isRootSpan(span) {
+			tree.root = span
+		}
+		spans[span.SpanID] = span
+		tree.end[span] = span.StartTime + span.Duration
+	}
+	if tree.root == nil {
+		return nil, httpErrorf(http.StatusNotFound, "trace %s has no request span", trace.TraceID)
+	}
+
+	for _, span := range trace.Spans {
+		if span == tree.root {
+			continue
+		}
+		parent, found := spans[span.ParentID]
+		if !found || parent == span {
+			parent = tree.root
+		}
+		tree.parent[span] = parent
+		tree.children[parent] = append(tree.children[parent], span)
+		if tree.end[span] > tree.end[tree.root] {
+			tree.end[tree.root] = tree.end[span]
+		}
+	}
+	return tree, nil
+}
+
+// walk add the segments of the critical path within a span ending at or
+// before end, walking backward from the child which ends last
+func (tree *spanTree) walk(span *Span, end int, path *[]*PathSegment) {
+	cursor := tree.end[span]
+	if end < cursor {
+		cursor = end
+	}
+	for cursor > span.StartTime {
+		var last *Span
+		for _, child := range tree.children[span] {
+			if child.StartTime >= cursor || child.StartTime < span.StartTime {
+				continue
+			}
+			if last == nil || tree.end[child] > tree.end[last] ||
+				(tree.end[child] == tree.end[last] && child.SpanID < last.SpanID) {
+				last = child
+			}
+		}
+		if last == nil {
+			tree.addSegment(span, span.StartTime, cursor, path)
+			return
+		}
+		childEnd := tree.end[last]
+		if childEnd > cursor {
+			childEnd = cursor
+		}
+		if childEnd < cursor {
+			tree.addSegment(span, childEnd, cursor, path)
+		}
+		tree.walk(last, childEnd, path)
+		cursor = last.StartTime
+	}
+}
+
+// addSegment add the time spent in a span to the path, the path is built
+// backward
+func (tree *spanTree) addSegment(span *Span, start, end int, path *[]*PathSegment) {
+	if end <= start {
+		return
+	}
+	*path = append(*path, &PathSegment{
+		Node:      span.OperationName,
+		SpanID:    span.SpanID,
+		StartTime: start,
+		Duration:  end - start,
+		Waiting:   span == tree.root,
+	})
+}
+
+// buildCriticalPath compute the critical path of a request from its span tree
+func buildCriticalPath(backend TraceBackend, traceID string) (*CriticalPath, error) {
+	trace, err := backend.GetRequestTrace(traceID)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := buildSpanTree(trace)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]*PathSegment, 0)
+	tree.walk(tree.root, tree.end[tree.root], &segments)
+	// the path is walked from the end of the request
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+
+	path := &CriticalPath{
+		RequestID:   tree.root.OperationName,
+		TraceID:     traceID,
+		StartTime:   tree.root.StartTime,
+		Duration:    tree.end[tree.root] - tree.root.StartTime,
+		Nodes:       make([]string, 0),
+		Segments:    segments,
+		Bottlenecks: make([]*NodeContribution, 0),
+		Spans:       make([]*SpanTrace, 0, len(trace.Spans)),
+	}
+
+	critical := make(map[string]bool)
+	executing := make(map[string]int)
+	for _, segment := range segments {
+		if segment.Waiting {
+			path.Waiting += segment.Duration
+			continue
+		}
+		path.Executing += segment.Duration
+		executing[segment.Node] += segment.Duration
+		critical[segment.SpanID] = true
+		if len(path.Nodes) == 0 || path.Nodes[len(path.Nodes)-1] != segment.Node {
+			path.Nodes = append(path.Nodes, segment.Node)
+		}
+	}
+
+	for node, duration := range executing {
+		contribution := &NodeContribution{Node: node, Duration: duration}
+		if path.Duration > 0 {
+			contribution.Share = float64(duration) / float64(path.Duration)
+		}
+		path.Bottlenecks = append(path.Bottlenecks, contribution)
+	}
+	sort.Slice(path.Bottlenecks, func(i, j int) bool {
+		if path.Bottlenecks[i].Duration == path.Bottlenecks[j].Duration {
+			return path.Bottlenecks[i].Node < path.Bottlenecks[j].Node
+		}
+		return path.Bottlenecks[i].Duration > path.Bottlenecks[j].Duration
+	})
+
+	for _, span := range trace.Spans {
+		spanTrace := &SpanTrace{
+			SpanID:    span.SpanID,
+			Node:      span.OperationName,
+			StartTime: span.StartTime,
+			Duration:  tree.end[span] - span.StartTime,
+			Critical:  critical[span.SpanID],
+		}
+		if parent, found := tree.parent[span]; found {
+			spanTrace.ParentID = parent.SpanID
+		}
+		path.Spans = append(path.Spans, spanTrace)
+	}
+	sort.SliceStable(path.Spans, func(i, j int) bool {
+		return path.Spans[i].StartTime < path.Spans[j].StartTime
+	})
+
+	return path, nil
+}
+
+// criticalPath encode the critical path of a request
+func criticalPath(backend TraceBackend, traceID string) (string, error) {
+	path, err := buildCriticalPath(backend, traceID)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.MarshalIndent(path, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode critical path, error %v", err)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,384 @@
+package function
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventRequestStart          = "request-start"
+	eventRequestEnd            = "request-end"
+	eventRequestFailure        = "request-failure"
+	eventExecutionForward      = "execution-forward"
+	eventExecutionContinuation = "execution-continuation"
+	eventNodeStart             = "node-start"
+	eventNodeEnd               = "node-end"
+	eventNodeFailure           = "node-failure"
+	eventOperationStart        = "operation-start"
+	eventOperationEnd          = "operation-end"
+	eventOperationFailure      = "operation-failure"
+
+	// span tag holding the error of a failed request or node
+	errorMessageTag = "error.message"
+
+	// maximum size of an ingested batch of events
+	eventBatchLimit = 4 << 20
+	// events kept for a request at most, later events are dropped
+	maxRequestEvents = 10000
+)
+
+var (
+	// the events live as long as the function process
+	events = newEventStore()
+)
+
+// Event an event reported by the faas-flow EventHandler of a flow, the time
+// is in microseconds
+type Event struct {
+	Type      string `json:"type"`
+	Flow      string `json:"flow"`
+	RequestID string `json:"request-id"`
+	Node      string `json:"node,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Time      int    `json:"time"`
+	Error     string `json:"error,omitempty"`
+}
+
+// eventRequest the events reported for a request
+type eventRequest struct {
+	requestID string
+	flow      string
+	events    []*Event
+	updated   time.Time
+	// element the request in the update order of the store
+	element *list.Element
+}
+
+// eventStore keeps the events of the requests in memory, requests expire
+// after the retention and the least recently updated requests are evicted
+// beyond the request limit
+type eventStore struct {
+	lock        sync.Mutex
+	retention   time.Duration
+	maxRequests int
+	requests    map[string]*eventRequest
+	// updates the requests, the most recently updated first
+	updates *list.List
+}
+
+// newEventStore create an empty event store
+func newEventStore() *eventStore {
+	return &eventStore{
+		retention:   24 * time.Hour,
+		maxRequests: 10000,
+		requests:    make(map[string]*eventRequest),
+		updates:     list.New(),
+	}
+}
+
+// configure set the retention and the request limit, zero values are ignored
+func (store *eventStore) configure(retention time.Duration, maxRequests int) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if retention > 0 {
+		store.retention = retention
+	}
+	if maxRequests > 0 {
+		store.maxRequests = maxRequests
+	}
+}
+
+// isEventType check if an event type is reported by the EventHandler
+func isEventType(eventType string) bool {
+	switch eventType {
+	case eventRequestStart, eventRequestEnd, eventRequestFailure,
+		eventExecutionForward, eventExecutionContinuation,
+		eventNodeStart, eventNodeEnd, eventNodeFailure,
+		eventOperationStart, eventOperationEnd, eventOperationFailure:
+		return true
+	}
+	return false
+}
+
+// add record events, returns the number of events dropped as the request
+// has too many events
+func (store *eventStore) add(batch []*Event) int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	dropped := 0
+	for _, event := range batch {
+		request, found := store.requests[event.RequestID]
+		if !found {
+			request = &eventRequest{requestID: event.RequestID, flow: event.Flow}
+			request.element = store.updates.PushFront(request)
+			store.requests[event.RequestID] = request
+		}
+		if len(request.events) >= maxRequestEvents {
+			dropped++
+			continue
+		}
+		request.events = append(request.events, event)
+		request.updated = now
+		store.updates.MoveToFront(request.element)
+	}
+	store.expire(now)
+	return dropped
+}
+
+// expire remove the requests older than the retention and the least recently
+// updated requests beyond the request limit, from the end of the update order
+// so only the removed requests are visited
+func (store *eventStore) expire(now time.Time) {
+	for oldest := store.updates.Back(); oldest != nil; oldest = store.updates.Back() {
+		request := oldest.Value.(*eventRequest)
+		expired := store.retention > 0 && now.Sub(request.updated) > store.retention
+		if !expired && (store.maxRequests <= 0 || len(store.requests) <= store.maxRequests) {
+			return
+		}
+		store.updates.Remove(oldest)
+		delete(store.requests, request.requestID)
+	}
+}
+
+// buildEventTrace build the trace of a request from its events, the request
+// id is the trace id and the id of the root span. A node span is opened by
+// the start of the node and closed by its end or failure, the oldest open
+// span of a node is closed first as the iterations of a foreach node may run
+// concurrently. Operation spans are children of the node span they run in.
+// Nodes and operations still running have no duration
+func buildEventTrace(requestID string, requestEvents []*Event) *Trace {
+	ordered := make([]*Event, len(requestEvents))
+	copy(ordered, requestEvents)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Time < ordered[j].Time
+	})
+
+	root := &Span{
+		TraceID:       requestID,
+		SpanID:        requestID,
+		OperationName: requestID,
+		Tags:          make(map[string]string),
+	}
+	trace := &Trace{TraceID: requestID, Spans: []*Span{root}}
+	if len(ordered) == 0 {
+		return trace
+	}
+	root.StartTime = ordered[0].Time
+	for _, event := range ordered {
+		if event.Type == eventRequestStart {
+			root.StartTime = event.Time
+			break
+		}
+	}
+
+	open := make(map[string][]*Span)
+	openOperations := make(map[string][]*Span)
+	last := make(map[string]*Span)
+	end := root.StartTime
+	for _, event := range ordered {
+		if event.Time > end {
+			end = event.Time
+		}
+		switch event.Type {
+		case eventRequestFailure:
+			root.Tags["error"] = "true"
+			root.Tags[errorMessageTag] = event.Error
+
+		case eventNodeStart:
+			span := &Span{
+				TraceID:       requestID,
+				SpanID:        fmt.Sprintf("%s-%d", requestID, len(trace.Spans)),
+				ParentID:      requestID,
+				OperationName: event.Node,
+				StartTime:     event.Time,
+				Tags:          make(map[string]string),
+			}
+			trace.Spans = append(trace.Spans, span)
+			open[event.Node] = append(open[event.Node], span)
+			last[event.Node] = span
+
+		case eventNodeEnd, eventNodeFailure:
+			spans := open[event.Node]
+			if len(spans) == 0 {
+				continue
+			}
+			span := spans[0]
+			open[event.Node] = spans[1:]
+			span.Duration = event.Time - span.StartTime
+			if event.Type == eventNodeFailure {
+				span.Tags["error"] = "true"
+				span.Tags[errorMessageTag] = event.Error
+			}
+
+		case eventOperationStart:
+			parent := last[event.Node]
+			if spans := open[event.Node]; len(spans) > 0 {
+				parent = spans[0]
+			}
+			if parent == nil {
+				continue
+			}
+			span := &Span{
+				TraceID:       requestID,
+				SpanID:        fmt.Sprintf("%s-%d", requestID, len(trace.Spans)),
+				ParentID:      parent.SpanID,
+				OperationName: event.Operation,
+				StartTime:     event.Time,
+				Tags:          make(map[string]string),
+			}
+			trace.Spans = append(trace.Spans, span)
+			key := event.Node + "/" + event.Operation
+			openOperations[key] = append(openOperations[key], span)
+
+		case eventOperationEnd, eventOperationFailure:
+			key := event.Node + "/" + event.Operation
+			if spans := openOperations[key]; len(spans) > 0 {
+				span := spans[0]
+				openOperations[key] = spans[1:]
+				span.Duration = event.Time - span.StartTime
+				if event.Type == eventOperationFailure {
+					span.Tags["error"] = "true"
+					span.Tags[errorMessageTag] = event.Error
+				}
+			}
+			if event.Type == eventOperationEnd {
+				continue
+			}
+			// the failure of an operation fails its node
+			span := last[event.Node]
+			if spans := open[event.Node]; len(spans) > 0 {
+				span = spans[0]
+			}
+			if span != nil {
+				span.Tags["error"] = "true"
+				span.Tags[errorMessageTag] = fmt.Sprintf("operation %s failed, %s", event.Operation, event.Error)
+			}
+		}
+	}
+	root.Duration = end - root.StartTime
+	return trace
+}
+
+// EventsBackend serves the traces of the requests from the events posted by
+// the flows, no tracing server is required
+type EventsBackend struct {
+	store *eventStore
+}
+
+// ListRequests list the traces of the requests of a flow, latest first
+func (backend *EventsBackend) ListRequests(function string, query *SearchQuery) ([]*Trace, error) {
+	backend.store.lock.Lock()
+	defer backend.store.lock.Unlock()
+
+	traces := make([]*Trace, 0)
+	for requestID, request := range backend.store.requests {
+		if request.flow != function {
+			continue
+		}
+		trace := buildEventTrace(requestID, request.events)
+		root := trace.Spans[0]
+		if (query.Start > 0 && root.StartTime+root.Duration < query.Start) ||
+			(query.End > 0 && root.StartTime > query.End) {
+			continue
+		}
+		traces = append(traces, trace)
+	}
+
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].Spans[0].StartTime > traces[j].Spans[0].StartTime
+	})
+	if query.Limit > 0 && len(traces) > query.Limit {
+		traces = traces[:query.Limit]
+	}
+	return traces, nil
+}
+
+// GetRequestTrace get the trace of a request, the trace id is the request id
+func (backend *EventsBackend) GetRequestTrace(traceID string) (*Trace, error) {
+	backend.store.lock.Lock()
+	defer backend.store.lock.Unlock()
+
+	request, found := backend.store.requests[traceID]
+	if !found {
+		return nil, httpErrorf(http.StatusNotFound, "trace %s not found", traceID)
+	}
+	return buildEventTrace(traceID, request.events), nil
+}
+
+// readSecret read a secret of the function, returns empty if not found
+func readSecret(name string) string {
+	secretPath := os.Getenv("secret_mount_path")
+	if secretPath == "" {
+		secretPath = "/var/openfaas/secrets/"
+	}
+	secret, err := ioutil.ReadFile(path.Join(secretPath, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(secret))
+}
+
+// ingestEvents record the events posted by the flows as ndjson, the events
+// must be posted with the event-ingest-token secret and are refused when the
+// secret is not set as anyone could post them
+func ingestEvents(w http.ResponseWriter, r *http.Request) (string, error) {
+	if r.Method != http.MethodPost {
+		return "", httpErrorf(http.StatusMethodNotAllowed, "events must be posted")
+	}
+	token := readSecret("event-ingest-token")
+	if token == "" {
+		return "", httpErrorf(http.StatusForbidden, "event ingest is disabled, the event-ingest-token secret is not set")
+	}
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		return "", httpErrorf(http.StatusUnauthorized, "invalid event ingest token")
+	}
+
+	batch := make([]*Event, 0)
+	rejected := 0
+	scanner := bufio.NewScanner(http.MaxBytesReader(w, r.Body, eventBatchLimit))
+	scanner.Buffer(make([]byte, 4096), 64*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil ||
+			!isEventType(event.Type) || event.Flow == "" || event.RequestID == "" || event.Time <= 0 {
+			rejected++
+			continue
+		}
+		batch = append(batch, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", httpErrorf(http.StatusBadRequest, "failed to read events, %v", err)
+	}
+
+	dropped := events.add(batch)
+
+	reply := struct {
+		Accepted int `json:"accepted"`
+		Rejected int `json:"rejected"`
+		Dropped  int `json:"dropped,omitempty"`
+	}{len(batch) - dropped, rejected, dropped}
+
+	encoded, err := json.MarshalIndent(reply, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode reply, error %v", err)
+	}
+	return string(encoded), nil
+}
@@ -3,54 +3,46 @@ package function
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Objects to retrive specific trace details
-
-type SpanItem struct {
-	TraceID       string `json:"traceID"`
-	SpanID        string `json:"spanID"`
-	OperationName string `json:"operationName"`
-	StartTime     int    `json:"startTime"`
-	Duration      int    `json:"duration"`
-	// Other can be added based on the needs
-}
-
-type TraceItem struct {
-	TraceID string      `json:"traceID"`
-	Spans   []*SpanItem `json:"spans"`
-}
-
-type Traces struct {
-	Data []*TraceItem `json:"data"`
-}
-
-// Objects to retrive requests lists
-
-type SpanOps struct {
-	TraceID       string `json:"traceID"`
-	SpanID        string `json:"spanID"`
-	OperationName string `json:"operationName"`
-}
-
-type RequestItem struct {
-	TraceID string     `json:"traceID"`
-	Spans   []*SpanOps `json:"spans"`
+// NodeInstance a single execution of a node, a node is executed once for each
+// foreach iteration or conditional branch it belongs to
+type NodeInstance struct {
+	// Key the foreach key or the condition, the execution order when unknown
+	Key       string `json:"key"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
 }
 
-type Requests struct {
-	Data []*RequestItem `json:"data"`
+// OperationTrace an execution of an operation of a node, the name is the
+// operation id
+type OperationTrace struct {
+	Name      string `json:"name"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
 }
 
 // traces of each nodes in a dag
 type NodeTrace struct {
 	StartTime int `json:"start-time"`
 	Duration  int `json:"duration"`
+	// Instances the executions of the node ordered by start time
+	Instances []*NodeInstance `json:"instances"`
+	// Operations the executions of the operations of the node ordered by
+	// start time, when the operations are traced
+	Operations []*OperationTrace `json:"operations,omitempty"`
 	// Other can be added based on the needs
 }
 
@@ -60,90 +52,260 @@ type RequestTrace struct {
 	NodeTraces map[string]*NodeTrace `json:"traces"`
 	StartTime  int                   `json:"start-time"`
 	Duration   int                   `json:"duration"`
+	// Error the failure of the request, when reported
+	Error string `json:"error,omitempty"`
+	// Failed the request or one of its nodes failed, from the span error tags
+	Failed bool `json:"failed,omitempty"`
+}
+
+// RequestSummary summary of a request of a flow
+type RequestSummary struct {
+	RequestID string `json:"request-id"`
+	TraceID   string `json:"trace-id"`
+	StartTime int    `json:"start-time"`
+	Duration  int    `json:"duration"`
+}
+
+// RequestList an ordered page of the requests of a flow
+type RequestList struct {
+	Requests []*RequestSummary `json:"requests"`
+	// Total the requests matching the query, a lower bound when truncated
+	Total      int    `json:"total"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next-cursor,omitempty"`
+	PrevCursor string `json:"prev-cursor,omitempty"`
 }
 
+// RequestQuery query to list the requests of a flow, times are in microseconds
+type RequestQuery struct {
+	Start      int
+	End        int
+	Limit      int
+	Offset     int
+	Cursor     string
+	PrevCursor string
+	Sort       string
+	Order      string
+	// CountOnly only the total of the requests is replied
+	CountOnly bool
+}
+
+const (
+	defaultListLimit = 20
+	sortByStartTime  = "start-time"
+	sortByDuration   = "duration"
+)
+
 var (
 	trace_url = ""
+
+	// maximum traces searched when sorting on other than start time
+	maxSearchLimit = 1000
 )
 
-func listRequest(function string) (string, error) {
-	resp, err := http.Get(trace_url + "api/traces?service=" + function)
-	if err != nil {
-		return "", fmt.Errorf("failed to request trace service, error %v ", err)
-	}
-	defer resp.Body.Close()
-	if resp.Body == nil {
-		return "", fmt.Errorf("failed to request trace service, status code %d", resp.StatusCode)
+// parseRequestQuery parse the request list query parameters
+func parseRequestQuery(values url.Values) (*RequestQuery, error) {
+	query := &RequestQuery{
+		Limit:      defaultListLimit,
+		Cursor:     values.Get("cursor"),
+		PrevCursor: values.Get("prev-cursor"),
+		Sort:       values.Get("sort"),
+		Order:      values.Get("order"),
+		CountOnly:  values.Get("count-only") == "true",
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read trace result, read error %v", err)
+	intParams := map[string]*int{
+		"start":  &query.Start,
+		"end":    &query.End,
+		"limit":  &query.Limit,
+		"offset": &query.Offset,
+	}
+	for param, value := range intParams {
+		raw := values.Get(param)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid %s %s", param, raw)
+		}
+		*value = parsed
 	}
 
-	if len(bodyBytes) == 0 {
-		return "", fmt.Errorf("failed to get request traces, empty result")
+	switch query.Sort {
+	case "":
+		query.Sort = sortByStartTime
+	case sortByStartTime, sortByDuration:
+	default:
+		return nil, fmt.Errorf("invalid sort %s", query.Sort)
 	}
 
-	requests := &Requests{}
-	err = json.Unmarshal(bodyBytes, requests)
-	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal requests lists, error %v", err)
+	switch query.Order {
+	case "":
+		query.Order = "desc"
+	case "asc", "desc":
+	default:
+		return nil, fmt.Errorf("invalid order %s", query.Order)
 	}
 
-	requestMap := make(map[string]string)
-	for _, request := range requests.Data {
-		if request.Spans == nil {
-			continue
+	// the cursor is the start time of the last request of the previous page,
+	// the prev cursor the start time of the first request of the next page
+	if query.Cursor != "" && query.PrevCursor != "" {
+		return nil, fmt.Errorf("cursor and prev-cursor can't be used together")
+	}
+	if query.Cursor != "" || query.PrevCursor != "" {
+		if query.Sort != sortByStartTime {
+			return nil, fmt.Errorf("cursor is only supported when sorting by %s", sortByStartTime)
+		}
+		raw := query.Cursor + query.PrevCursor
+		cursor, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %s", raw)
 		}
-		for _, span := range request.Spans {
-			if span.TraceID == request.TraceID && span.TraceID == span.SpanID {
-				requestMap[span.OperationName] = request.TraceID
-				break
+		if (query.Order == "desc") == (query.Cursor != "") {
+			if query.End == 0 || cursor-1 < query.End {
+				query.End = cursor - 1
 			}
+		} else if cursor+1 > query.Start {
+			query.Start = cursor + 1
 		}
+		query.Offset = 0
 	}
 
-	encoded, err := json.MarshalIndent(requestMap, "", "    ")
-	if err != nil {
-		return "", fmt.Errorf("failed to encode request list, error %v", err)
-	}
+	return query, nil
+}
 
-	return string(encoded), nil
+// summarizeRequest build the request summary from a trace, returns nil if
+// the trace has no request span
+func summarizeRequest(trace *Trace) *RequestSummary {
+	var summary *RequestSummary
+	lastSpanEnd := 0
+	for _, span := range trace.Spans {
+		if summary == nil && isRootSpan(span) {
+			summary = &RequestSummary{
+				RequestID: span.OperationName,
+				TraceID:   trace.TraceID,
+				StartTime: span.StartTime,
+			}
+		}
+		if end := span.StartTime + span.Duration; end > lastSpanEnd {
+			lastSpanEnd = end
+		}
+	}
+	if summary != nil && lastSpanEnd > summary.StartTime {
+		summary.Duration = lastSpanEnd - summary.StartTime
+	}
+	return summary
 }
 
-func listTraces(request string) (string, error) {
-	resp, err := http.Get(trace_url + "api/traces/" + request)
-	if err != nil {
-		return "", fmt.Errorf("failed to request trace service, error %v ", err)
+func listRequest(backend TraceBackend, function string, query *RequestQuery) (string, error) {
+	// the page before a prev cursor is the end of the window in reverse order
+	order := query.Order
+	if query.PrevCursor != "" {
+		order = map[string]string{"asc": "desc", "desc": "asc"}[order]
+	}
+
+	// one more request is searched to know if there is a next page
+	search := &SearchQuery{
+		Start: query.Start,
+		End:   query.End,
+		Limit: query.Offset + query.Limit + 1,
 	}
-	defer resp.Body.Close()
-	if resp.Body == nil {
-		return "", fmt.Errorf("failed to request trace service, status code %d", resp.StatusCode)
+	// other orders and counts need the complete window
+	if query.Sort != sortByStartTime || order != "desc" || query.Limit == 0 || query.CountOnly {
+		search.Limit = maxSearchLimit
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	traces, err := backend.ListRequests(function, search)
 	if err != nil {
-		return "", fmt.Errorf("failed to read trace result, read error %v", err)
+		return "", err
 	}
 
-	if len(bodyBytes) == 0 {
-		return "", fmt.Errorf("failed to get request traces, empty result")
+	requests := make([]*RequestSummary, 0, len(traces))
+	for _, trace := range traces {
+		summary := summarizeRequest(trace)
+		if summary == nil {
+			continue
+		}
+		// trace servers match traces overlapping with the window
+		if (query.Start > 0 && summary.StartTime < query.Start) ||
+			(query.End > 0 && summary.StartTime > query.End) {
+			continue
+		}
+		requests = append(requests, summary)
 	}
 
-	traces := &Traces{}
-	err = json.Unmarshal(bodyBytes, traces)
-	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal requests lists, error %v", err)
+	sort.Slice(requests, func(i, j int) bool {
+		less := requests[i].StartTime < requests[j].StartTime
+		if query.Sort == sortByDuration {
+			less = requests[i].Duration < requests[j].Duration
+		}
+		if order == "desc" {
+			return !less
+		}
+		return less
+	})
+
+	response := &RequestList{
+		Total:     len(requests),
+		Truncated: len(traces) >= search.Limit,
+		Offset:    query.Offset,
 	}
 
-	if traces.Data == nil || len(traces.Data) == 0 {
-		return "", fmt.Errorf("failed to get request traces, empty data")
+	offset := query.Offset
+	if offset > len(requests) {
+		offset = len(requests)
+	}
+	page := requests[offset:]
+	more := query.Limit > 0 && len(page) > query.Limit
+	if more {
+		page = page[:query.Limit]
+	}
+	if query.PrevCursor != "" {
+		for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+			page[i], page[j] = page[j], page[i]
+		}
+	}
+	response.Requests = page
+	if query.CountOnly {
+		page = make([]*RequestSummary, 0)
+		response.Requests = page
 	}
 
-	requestTrace := traces.Data[0]
-	if requestTrace.TraceID != request {
-		return "", fmt.Errorf("invalid request trace %s", requestTrace.TraceID)
+	if query.Sort == sortByStartTime && query.Limit > 0 && len(page) > 0 {
+		first := strconv.Itoa(page[0].StartTime)
+		last := strconv.Itoa(page[len(page)-1].StartTime)
+		switch {
+		case query.PrevCursor != "":
+			// the page was reached backward, the next page follows it
+			response.NextCursor = last
+			if more {
+				response.PrevCursor = first
+			}
+		case query.Cursor != "":
+			response.PrevCursor = first
+			if more {
+				response.NextCursor = last
+			}
+		case more:
+			response.NextCursor = last
+		}
+	}
+
+	encoded, err := json.MarshalIndent(response, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request list, error %v", err)
+	}
+
+	return string(encoded), nil
+}
+
+// buildRequestTrace build the node traces of a request from its trace
+func buildRequestTrace(backend TraceBackend, request string) (*RequestTrace, error) {
+	requestTrace, err := backend.GetRequestTrace(request)
+	if err != nil {
+		return nil, err
 	}
 
 	response := &RequestTrace{}
@@ -151,12 +313,22 @@ func listTraces(request string) (string, error) {
 
 	var lastSpanEnd int
 
+	spans := make(map[string]*Span)
 	for _, span := range requestTrace.Spans {
-		if span.TraceID == request && span.TraceID == span.SpanID {
+		spans[span.SpanID] = span
+	}
+	operations := make(map[string][]*OperationTrace)
+
+	for _, span := range requestTrace.Spans {
+		if isRootSpan(span) {
 			// Set RequestID, StartTime and lastestSpan start time
 			response.RequestID = span.OperationName
 			response.StartTime = span.StartTime
 			response.Duration = span.Duration
+			response.Error = span.Tags[errorMessageTag]
+			if spanStatus(span) == "FAILED" {
+				response.Failed = true
+			}
 			lastSpanEnd = span.StartTime
 		} else {
 			spanEndTime := span.StartTime + span.Duration
@@ -164,6 +336,21 @@ func listTraces(request string) (string, error) {
 				lastSpanEnd = spanEndTime
 			}
 
+			if parent := nodeSpan(spans, span); parent != span {
+				operation := &OperationTrace{
+					Name:      span.OperationName,
+					StartTime: span.StartTime,
+					Duration:  span.Duration,
+					Status:    spanStatus(span),
+					Error:     span.Tags[errorMessageTag],
+				}
+				if operation.Status == "FAILED" {
+					response.Failed = true
+				}
+				operations[parent.OperationName] = append(operations[parent.OperationName], operation)
+				continue
+			}
+
 			node, found := response.NodeTraces[span.OperationName]
 			if found {
 				nodeStartTime := node.StartTime
@@ -182,6 +369,17 @@ func listTraces(request string) (string, error) {
 				node.StartTime = span.StartTime
 				node.Duration = span.Duration
 			}
+			instance := &NodeInstance{
+				Key:       instanceKey(span),
+				StartTime: span.StartTime,
+				Duration:  span.Duration,
+				Status:    spanStatus(span),
+				Error:     span.Tags[errorMessageTag],
+			}
+			if instance.Status == "FAILED" {
+				response.Failed = true
+			}
+			node.Instances = append(node.Instances, instance)
 			response.NodeTraces[span.OperationName] = node
 		}
 	}
@@ -189,6 +387,37 @@ func listTraces(request string) (string, error) {
 		response.Duration = lastSpanEnd - response.StartTime
 	}
 
+	for nodeID, nodeOperations := range operations {
+		node, found := response.NodeTraces[nodeID]
+		if !found {
+			continue
+		}
+		sort.SliceStable(nodeOperations, func(i, j int) bool {
+			return nodeOperations[i].StartTime < nodeOperations[j].StartTime
+		})
+		node.Operations = nodeOperations
+	}
+
+	for _, node := range response.NodeTraces {
+		sort.SliceStable(node.Instances, func(i, j int) bool {
+			return node.Instances[i].StartTime < node.Instances[j].StartTime
+		})
+		// instances without a key are numbered by execution order
+		for index, instance := range node.Instances {
+			if instance.Key == "" {
+				instance.Key = fmt.Sprintf("#%d", index+1)
+			}
+		}
+	}
+	return response, nil
+}
+
+func listTraces(backend TraceBackend, request string) (string, error) {
+	response, err := buildRequestTrace(backend, request)
+	if err != nil {
+		return "", err
+	}
+
 	encoded, err := json.MarshalIndent(response, "", "    ")
 	if err != nil {
 		return "", fmt.Errorf("failed to encode request list, error %v", err)
@@ -197,23 +426,59 @@ func listTraces(request string) (string, error) {
 	return string(encoded), nil
 }
 
-// Handle a serverless request
-func Handle(req []byte) string {
-	values, err := url.ParseQuery(os.Getenv("Http_Query"))
-	if err != nil {
-		log.Fatal("No argument specified")
+// ErrorResponse error returned to the caller
+type ErrorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeError write an error as a json response, errors without a status code
+// are internal errors
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if httpErr, ok := err.(*HttpError); ok {
+		status = httpErr.StatusCode
 	}
+	log.Printf("failed to process, error %v", err)
+
+	data, _ := json.Marshal(&ErrorResponse{Status: status, Error: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Handle a serverless request
+func Handle(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
 
 	method := values.Get("method")
 	if method == "" {
 		method = "list"
 	}
+	// prometheus scrapes the function on /function/metrics/metrics
+	if strings.HasSuffix(r.URL.Path, "/metrics") {
+		method = "prometheus"
+	}
 
 	trace_url = os.Getenv("trace_url")
 	if trace_url == "" {
 		trace_url = "http://jaegertracing:16686/"
 	}
 
+	if limit, lErr := strconv.Atoi(os.Getenv("max_search_limit")); lErr == nil && limit > 0 {
+		maxSearchLimit = limit
+	}
+
+	retention, _ := time.ParseDuration(os.Getenv("events_retention"))
+	maxRequests, _ := strconv.Atoi(os.Getenv("events_max_requests"))
+	events.configure(retention, maxRequests)
+
+	backend, err := getTraceBackend(os.Getenv("trace_backend"), trace_url)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
 	var resp string
 
 	switch method {
@@ -221,21 +486,76 @@ func Handle(req []byte) string {
 	case "list":
 		function := values.Get("function")
 		if function == "" {
-			log.Fatal("No function specified")
+			writeError(w, httpErrorf(http.StatusBadRequest, "no function specified"))
+			return
+		}
+		query, qErr := parseRequestQuery(values)
+		if qErr != nil {
+			writeError(w, httpErrorf(http.StatusBadRequest, "invalid query, %v", qErr))
+			return
+		}
+		resp, err = listRequest(backend, function, query)
+
+	case "prometheus":
+		// the statistics are collected in background from the first scrape
+		collector.start(backend, getGatewayURL())
+
+		w.Header().Set("Content-Type", prometheusType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(collector.cachedExposition()))
+		return
+
+	case "analytics":
+		function := values.Get("function")
+		if function == "" {
+			writeError(w, httpErrorf(http.StatusBadRequest, "no function specified"))
+			return
 		}
-		resp, err = listRequest(function)
+		query, qErr := parseRequestQuery(values)
+		if qErr != nil {
+			writeError(w, httpErrorf(http.StatusBadRequest, "invalid query, %v", qErr))
+			return
+		}
+		buckets := defaultAnalyticsBuckets
+		if raw := values.Get("buckets"); raw != "" {
+			buckets, err = strconv.Atoi(raw)
+			if err != nil || buckets <= 0 || buckets > maxAnalyticsBuckets {
+				writeError(w, httpErrorf(http.StatusBadRequest, "invalid buckets %s", raw))
+				return
+			}
+		}
+		resp, err = flowAnalytics(backend, getGatewayURL(), function, query.Start, query.End, buckets)
+
+	case "critical-path":
+		trace := values.Get("trace")
+		if len(trace) <= 0 {
+			writeError(w, httpErrorf(http.StatusBadRequest, "no trace specified"))
+			return
+		}
+		resp, err = criticalPath(backend, trace)
+
+	case "events":
+		resp, err = ingestEvents(w, r)
 
 	case "traces":
 		trace := values.Get("trace")
 		if len(trace) <= 0 {
-			log.Fatal("No request specified")
+			writeError(w, httpErrorf(http.StatusBadRequest, "no trace specified"))
+			return
 		}
-		resp, err = listTraces(trace)
+		resp, err = listTraces(backend, trace)
+
+	default:
+		writeError(w, httpErrorf(http.StatusBadRequest, "unknown method %s", method))
+		return
 	}
 
 	if err != nil {
-		log.Fatal("Failed to process, error ", err)
+		writeError(w, err)
+		return
 	}
 
-	return resp
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(resp))
 }
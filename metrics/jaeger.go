@@ -0,0 +1,131 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Objects to retrive specific trace details from jaeger
+
+type SpanReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type SpanTag struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type SpanItem struct {
+	TraceID       string           `json:"traceID"`
+	SpanID        string           `json:"spanID"`
+	OperationName string           `json:"operationName"`
+	References    []*SpanReference `json:"references"`
+	StartTime     int              `json:"startTime"`
+	Duration      int              `json:"duration"`
+	Tags          []*SpanTag       `json:"tags"`
+	// Other can be added based on the needs
+}
+
+type TraceItem struct {
+	TraceID string      `json:"traceID"`
+	Spans   []*SpanItem `json:"spans"`
+}
+
+type Traces struct {
+	Data []*TraceItem `json:"data"`
+}
+
+// JaegerBackend retrieves traces from the jaeger query api
+type JaegerBackend struct {
+	url    string
+	client *http.Client
+}
+
+// convertJaegerTrace convert a jaeger trace into a trace
+func convertJaegerTrace(item *TraceItem) *Trace {
+	trace := &Trace{TraceID: item.TraceID}
+	for _, spanItem := range item.Spans {
+		span := &Span{
+			TraceID:       spanItem.TraceID,
+			SpanID:        spanItem.SpanID,
+			OperationName: spanItem.OperationName,
+			StartTime:     spanItem.StartTime,
+			Duration:      spanItem.Duration,
+			Tags:          make(map[string]string, len(spanItem.Tags)),
+		}
+		for _, tag := range spanItem.Tags {
+			span.Tags[tag.Key] = fmt.Sprint(tag.Value)
+		}
+		for _, reference := range spanItem.References {
+			if reference.RefType == "CHILD_OF" && reference.TraceID == spanItem.TraceID {
+				span.ParentID = reference.SpanID
+				break
+			}
+		}
+		trace.Spans = append(trace.Spans, span)
+	}
+	return trace
+}
+
+// queryTraces query jaeger and decode the traces
+func (backend *JaegerBackend) queryTraces(path string) (*Traces, error) {
+	request, _ := http.NewRequest(http.MethodGet, backend.url+path, nil)
+	bodyBytes, err := queryTraceServer(backend.client, request)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := &Traces{}
+	err = json.Unmarshal(bodyBytes, traces)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to unmarshal traces, error %v", err)
+	}
+	return traces, nil
+}
+
+// ListRequests list the request traces of a flow
+func (backend *JaegerBackend) ListRequests(function string, query *SearchQuery) ([]*Trace, error) {
+	params := url.Values{}
+	params.Set("service", function)
+	if query.Start > 0 {
+		params.Set("start", strconv.Itoa(query.Start))
+	}
+	if query.End > 0 {
+		params.Set("end", strconv.Itoa(query.End))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	traces, err := backend.queryTraces("api/traces?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Trace, 0, len(traces.Data))
+	for _, item := range traces.Data {
+		result = append(result, convertJaegerTrace(item))
+	}
+	return result, nil
+}
+
+// GetRequestTrace get the complete trace of a request
+func (backend *JaegerBackend) GetRequestTrace(traceID string) (*Trace, error) {
+	traces, err := backend.queryTraces("api/traces/" + url.PathEscape(traceID))
+	if err != nil {
+		return nil, err
+	}
+
+	if traces.Data == nil || len(traces.Data) == 0 {
+		return nil, httpErrorf(http.StatusNotFound, "trace %s not found", traceID)
+	}
+
+	return convertJaegerTrace(traces.Data[0]), nil
+}
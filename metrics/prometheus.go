@@ -0,0 +1,444 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	prometheusType = "text/plain; version=0.0.4"
+	unknownStatus  = "unknown"
+)
+
+var (
+	// request and node duration buckets in seconds
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+	// requests started before the first collection which are counted
+	metricsLookback = time.Hour
+	// interval between two collections of the flow statistics
+	metricsInterval = 30 * time.Second
+
+	// the collector lives as long as the function process, counters restart
+	// from zero with the process as prometheus expects
+	collector = &metricsCollector{flows: make(map[string]*flowStats)}
+
+	// validFunctionName the function names accepted by the gateway
+	validFunctionName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// histogram a cumulative histogram over durationBuckets
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// flowStats the statistics of the requests of a flow, requests are counted
+// once their state is final
+type flowStats struct {
+	// start time of the latest listed request in microseconds
+	lastStart int
+	pending   map[string]*RequestSummary
+	// start time of the counted requests still within the search window
+	counted  map[string]int
+	requests map[string]uint64
+	duration *histogram
+	nodes    map[string]*histogram
+}
+
+// metricsCollector collect the flow statistics from the traces and the
+// request states in background, a scrape is served the exposition of the
+// latest collection
+type metricsCollector struct {
+	lock   sync.Mutex
+	flows  map[string]*flowStats
+	errors uint64
+
+	once        sync.Once
+	cachedLock  sync.Mutex
+	cached      string
+	collectedAt time.Time
+}
+
+// newHistogram create an empty histogram
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(durationBuckets))}
+}
+
+// observe add a value in seconds
+func (h *histogram) observe(value float64) {
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// newFlowStats create empty flow statistics
+func newFlowStats() *flowStats {
+	return &flowStats{
+		pending:  make(map[string]*RequestSummary),
+		counted:  make(map[string]int),
+		requests: make(map[string]uint64),
+		duration: newHistogram(),
+		nodes:    make(map[string]*histogram),
+	}
+}
+
+// getGatewayURL get the gateway the flows are requested through
+func getGatewayURL() string {
+	gatewayURL := os.Getenv("gateway_url")
+	if gatewayURL == "" {
+		gatewayURL = "http://gateway:8080/"
+	}
+	return gatewayURL
+}
+
+// queryGateway request a function through the gateway
+func queryGateway(gatewayURL, path string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(gatewayURL + path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request gateway, %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gateway reply, %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d, %s", resp.StatusCode, bodyBytes)
+	}
+	return bodyBytes, nil
+}
+
+// listFlows get the names of the flow functions
+func listFlows(gatewayURL string) ([]string, error) {
+	bodyBytes, err := queryGateway(gatewayURL, "function/list-flow-functions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flows, %v", err)
+	}
+	functions := []struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, &functions); err != nil {
+		return nil, fmt.Errorf("failed to list flows, %v", err)
+	}
+	flows := make([]string, 0, len(functions))
+	for _, function := range functions {
+		flows = append(flows, function.Name)
+	}
+	return flows, nil
+}
+
+// requestState get the state of a request from its flow
+func requestState(gatewayURL, flow, requestID string) (string, error) {
+	if !validFunctionName.MatchString(flow) {
+		return "", fmt.Errorf("invalid flow name %q", flow)
+	}
+	bodyBytes, err := queryGateway(gatewayURL, "function/"+flow+"?state="+url.QueryEscape(requestID))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bodyBytes)), nil
+}
+
+// listWindowRequests list every request of a window, the backends reply the
+// latest requests up to the limit so a full page is followed by the page of
+// the requests started before the earliest one
+func listWindowRequests(backend TraceBackend, flow string, search *SearchQuery) ([]*Trace, error) {
+	all := make([]*Trace, 0)
+	page := *search
+	for {
+		traces, err := backend.ListRequests(flow, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, traces...)
+		if len(traces) < page.Limit {
+			return all, nil
+		}
+
+		earliest := 0
+		for _, trace := range traces {
+			summary := summarizeRequest(trace)
+			if summary != nil && (earliest == 0 || summary.StartTime < earliest) {
+				earliest = summary.StartTime
+			}
+		}
+		if earliest == 0 || (page.End > 0 && earliest > page.End) {
+			return nil, fmt.Errorf("failed to list the requests of %s, the window holds more than %d requests",
+				flow, page.Limit)
+		}
+		if earliest-1 < page.Start {
+			return all, nil
+		}
+		page.End = earliest - 1
+	}
+}
+
+// isActiveState check if a request may still change its state
+func isActiveState(state string) bool {
+	return state == "RUNNING" || state == "PAUSED"
+}
+
+// start collect the statistics every metrics_interval in background, the
+// collection is started once for the function process
+func (c *metricsCollector) start(backend TraceBackend, gatewayURL string) {
+	c.once.Do(func() {
+		if lookback, err := time.ParseDuration(os.Getenv("metrics_lookback")); err == nil && lookback > 0 {
+			metricsLookback = lookback
+		}
+		if interval, err := time.ParseDuration(os.Getenv("metrics_interval")); err == nil && interval > 0 {
+			metricsInterval = interval
+		}
+
+		c.lock.Lock()
+		c.cache()
+		c.lock.Unlock()
+		go c.run(backend, gatewayURL, metricsInterval)
+	})
+}
+
+// run collect the statistics every interval
+func (c *metricsCollector) run(backend TraceBackend, gatewayURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.collect(backend, gatewayURL)
+		<-ticker.C
+	}
+}
+
+// collect update the statistics of every flow and cache their exposition
+func (c *metricsCollector) collect(backend TraceBackend, gatewayURL string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer c.cache()
+
+	flows, err := listFlows(gatewayURL)
+	if err != nil {
+		log.Printf("failed to collect metrics, error %v", err)
+		c.errors++
+		return
+	}
+	for _, flow := range flows {
+		stats, found := c.flows[flow]
+		if !found {
+			stats = newFlowStats()
+			c.flows[flow] = stats
+		}
+		if err := c.collectFlow(backend, gatewayURL, flow, stats); err != nil {
+			log.Printf("failed to collect metrics of %s, error %v", flow, err)
+			c.errors++
+		}
+	}
+	c.collectedAt = time.Now()
+}
+
+// cache render the exposition of the statistics for the scrapes, must be
+// called with the lock held
+func (c *metricsCollector) cache() {
+	exposition := c.exposition()
+	c.cachedLock.Lock()
+	c.cached = exposition
+	c.cachedLock.Unlock()
+}
+
+// cachedExposition get the exposition of the latest collection
+func (c *metricsCollector) cachedExposition() string {
+	c.cachedLock.Lock()
+	defer c.cachedLock.Unlock()
+	return c.cached
+}
+
+// collectFlow list the requests started since the last collection and count
+// the requests which reached a final state
+func (c *metricsCollector) collectFlow(backend TraceBackend, gatewayURL, flow string, stats *flowStats) error {
+	lookback := int(metricsLookback / time.Microsecond)
+	now := int(time.Now().UnixNano() / 1000)
+	search := &SearchQuery{Limit: maxSearchLimit}
+	if stats.lastStart > 0 {
+		// requests are listed again from the latest one as traces are
+		// reported with a delay
+		search.Start = stats.lastStart - lookback/10
+	} else {
+		search.Start = now - lookback
+	}
+
+	traces, err := listWindowRequests(backend, flow, search)
+	if err != nil {
+		return err
+	}
+	for _, trace := range traces {
+		summary := summarizeRequest(trace)
+		if summary == nil {
+			continue
+		}
+		if _, counted := stats.counted[summary.RequestID]; counted {
+			continue
+		}
+		stats.pending[summary.RequestID] = summary
+		if summary.StartTime > stats.lastStart {
+			stats.lastStart = summary.StartTime
+		}
+	}
+
+	for requestID, summary := range stats.pending {
+		state, stateErr := requestState(gatewayURL, flow, requestID)
+		if stateErr == nil && isActiveState(state) {
+			continue
+		}
+
+		requestTrace, err := buildRequestTrace(backend, summary.TraceID)
+		if err != nil {
+			log.Printf("failed to get trace of %s request %s, error %v", flow, requestID, err)
+			continue
+		}
+
+		// the state of a request is cleaned up once it ends, a failure is
+		// only known from the span error tags
+		status := "finished"
+		switch {
+		case requestTrace.Failed:
+			status = "failed"
+		case stateErr == nil && state != "":
+			status = strings.ToLower(state)
+		case requestTrace.RequestID == "":
+			// neither the state nor the request span is known, the request
+			// is given up after the lookback
+			if summary.StartTime > now-lookback {
+				continue
+			}
+			status = unknownStatus
+		}
+
+		stats.requests[status]++
+		stats.duration.observe(float64(requestTrace.Duration) / 1e6)
+		for node, nodeTrace := range requestTrace.NodeTraces {
+			nodeHistogram, found := stats.nodes[node]
+			if !found {
+				nodeHistogram = newHistogram()
+				stats.nodes[node] = nodeHistogram
+			}
+			nodeHistogram.observe(float64(nodeTrace.Duration) / 1e6)
+		}
+
+		stats.counted[requestID] = summary.StartTime
+		delete(stats.pending, requestID)
+	}
+
+	// counted requests older than the search window are not listed again
+	for requestID, startTime := range stats.counted {
+		if startTime < stats.lastStart-lookback {
+			delete(stats.counted, requestID)
+		}
+	}
+	return nil
+}
+
+// escapeLabel escape a prometheus label value
+func escapeLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+// formatFloat format a sample value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeHistogram write the samples of a histogram
+func writeHistogram(sb *strings.Builder, name, labels string, h *histogram) {
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(sb, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(sb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(sb, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(sb, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// exposition write the statistics in the prometheus text format, must be
+// called with the lock held
+func (c *metricsCollector) exposition() string {
+	flows := make([]string, 0, len(c.flows))
+	for flow := range c.flows {
+		flows = append(flows, flow)
+	}
+	sort.Strings(flows)
+
+	sb := &strings.Builder{}
+
+	sb.WriteString("# HELP faasflow_requests_total Requests of a flow completed by final status.\n")
+	sb.WriteString("# TYPE faasflow_requests_total counter\n")
+	for _, flow := range flows {
+		stats := c.flows[flow]
+		statuses := make([]string, 0, len(stats.requests))
+		for status := range stats.requests {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(sb, "faasflow_requests_total{flow=\"%s\",status=\"%s\"} %d\n",
+				escapeLabel(flow), escapeLabel(status), stats.requests[status])
+		}
+	}
+
+	sb.WriteString("# HELP faasflow_requests_pending Requests of a flow not completed yet.\n")
+	sb.WriteString("# TYPE faasflow_requests_pending gauge\n")
+	for _, flow := range flows {
+		fmt.Fprintf(sb, "faasflow_requests_pending{flow=\"%s\"} %d\n", escapeLabel(flow), len(c.flows[flow].pending))
+	}
+
+	sb.WriteString("# HELP faasflow_request_duration_seconds Duration of the completed requests of a flow.\n")
+	sb.WriteString("# TYPE faasflow_request_duration_seconds histogram\n")
+	for _, flow := range flows {
+		writeHistogram(sb, "faasflow_request_duration_seconds", fmt.Sprintf("flow=\"%s\"", escapeLabel(flow)),
+			c.flows[flow].duration)
+	}
+
+	sb.WriteString("# HELP faasflow_node_duration_seconds Duration of the nodes of the completed requests of a flow.\n")
+	sb.WriteString("# TYPE faasflow_node_duration_seconds histogram\n")
+	for _, flow := range flows {
+		stats := c.flows[flow]
+		nodes := make([]string, 0, len(stats.nodes))
+		for node := range stats.nodes {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			writeHistogram(sb, "faasflow_node_duration_seconds",
+				fmt.Sprintf("flow=\"%s\",node=\"%s\"", escapeLabel(flow), escapeLabel(node)), stats.nodes[node])
+		}
+	}
+
+	sb.WriteString("# HELP faasflow_metrics_errors_total Failed collections of the flow metrics.\n")
+	sb.WriteString("# TYPE faasflow_metrics_errors_total counter\n")
+	fmt.Fprintf(sb, "faasflow_metrics_errors_total %d\n", c.errors)
+
+	sb.WriteString("# HELP faasflow_metrics_last_collection_timestamp_seconds Time of the latest collection of the flow metrics.\n")
+	sb.WriteString("# TYPE faasflow_metrics_last_collection_timestamp_seconds gauge\n")
+	collectedAt := 0.0
+	if !c.collectedAt.IsZero() {
+		collectedAt = float64(c.collectedAt.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(sb, "faasflow_metrics_last_collection_timestamp_seconds %s\n", formatFloat(collectedAt))
+
+	return sb.String()
+}
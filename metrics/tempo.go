@@ -0,0 +1,219 @@
+package function
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Objects to retrive traces from the tempo search and trace api
+
+type TempoTraceSummary struct {
+	TraceID           string `json:"traceID"`
+	RootServiceName   string `json:"rootServiceName"`
+	RootTraceName     string `json:"rootTraceName"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	DurationMs        int    `json:"durationMs"`
+}
+
+type TempoSearch struct {
+	Traces []*TempoTraceSummary `json:"traces"`
+}
+
+type TempoAnyValue struct {
+	StringValue *string         `json:"stringValue"`
+	BoolValue   *bool           `json:"boolValue"`
+	IntValue    json.RawMessage `json:"intValue"`
+	DoubleValue *float64        `json:"doubleValue"`
+}
+
+type TempoAttribute struct {
+	Key   string         `json:"key"`
+	Value *TempoAnyValue `json:"value"`
+}
+
+type TempoStatus struct {
+	// Code is a number or an enum name in OTLP json
+	Code json.RawMessage `json:"code"`
+}
+
+type TempoSpan struct {
+	TraceID           string            `json:"traceId"`
+	SpanID            string            `json:"spanId"`
+	ParentSpanID      string            `json:"parentSpanId"`
+	Name              string            `json:"name"`
+	StartTimeUnixNano json.RawMessage   `json:"startTimeUnixNano"`
+	EndTimeUnixNano   json.RawMessage   `json:"endTimeUnixNano"`
+	Attributes        []*TempoAttribute `json:"attributes"`
+	Status            *TempoStatus      `json:"status"`
+}
+
+type TempoScopeSpans struct {
+	Spans []*TempoSpan `json:"spans"`
+}
+
+type TempoBatch struct {
+	ScopeSpans []*TempoScopeSpans `json:"scopeSpans"`
+	// older tempo versions
+	InstrumentationLibrarySpans []*TempoScopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+type TempoTrace struct {
+	Batches []*TempoBatch `json:"batches"`
+}
+
+// TempoBackend retrieves traces from the tempo http api
+type TempoBackend struct {
+	url    string
+	client *http.Client
+}
+
+// parseUnixNano parse an OTLP timestamp in nanoseconds into microseconds,
+// OTLP json encodes 64 bit integers either as a number or as a string
+func parseUnixNano(raw json.RawMessage) int {
+	value := strings.Trim(string(raw), "\"")
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int(nanos / 1000)
+}
+
+// normalizeId convert an OTLP id into a hex string, OTLP json may encode ids
+// either in hex or in base64
+func normalizeId(id string) string {
+	if id == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(id); err == nil && (len(id) == 16 || len(id) == 32) {
+		return strings.ToLower(id)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return id
+	}
+	return hex.EncodeToString(decoded)
+}
+
+// tempoTags convert the attributes and the status of an OTLP span into tags
+func tempoTags(tempoSpan *TempoSpan) map[string]string {
+	tags := make(map[string]string, len(tempoSpan.Attributes))
+	for _, attribute := range tempoSpan.Attributes {
+		if attribute.Value == nil {
+			continue
+		}
+		value := attribute.Value
+		switch {
+		case value.StringValue != nil:
+			tags[attribute.Key] = *value.StringValue
+		case value.BoolValue != nil:
+			tags[attribute.Key] = strconv.FormatBool(*value.BoolValue)
+		case len(value.IntValue) > 0:
+			tags[attribute.Key] = strings.Trim(string(value.IntValue), "\"")
+		case value.DoubleValue != nil:
+			tags[attribute.Key] = strconv.FormatFloat(*value.DoubleValue, 'f', -1, 64)
+		}
+	}
+	if tempoSpan.Status != nil {
+		code := strings.Trim(string(tempoSpan.Status.Code), "\"")
+		if code == "2" || code == "STATUS_CODE_ERROR" {
+			tags["error"] = "true"
+		}
+	}
+	return tags
+}
+
+// ListRequests list the request traces of a flow, tempo search provides the
+// root span name and timing for each trace
+func (backend *TempoBackend) ListRequests(function string, query *SearchQuery) ([]*Trace, error) {
+	params := url.Values{}
+	params.Set("tags", "service.name="+function)
+	// tempo search is bounded in seconds
+	if query.Start > 0 {
+		params.Set("start", strconv.Itoa(query.Start/1000000))
+	}
+	if query.End > 0 {
+		params.Set("end", strconv.Itoa((query.End+999999)/1000000))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	request, _ := http.NewRequest(http.MethodGet,
+		backend.url+"api/search?"+params.Encode(), nil)
+	bodyBytes, err := queryTraceServer(backend.client, request)
+	if err != nil {
+		return nil, err
+	}
+
+	search := &TempoSearch{}
+	err = json.Unmarshal(bodyBytes, search)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to unmarshal search result, error %v", err)
+	}
+
+	result := make([]*Trace, 0, len(search.Traces))
+	for _, summary := range search.Traces {
+		traceID := normalizeId(summary.TraceID)
+		result = append(result, &Trace{
+			TraceID: traceID,
+			Spans: []*Span{
+				{
+					TraceID:       traceID,
+					SpanID:        traceID,
+					OperationName: summary.RootTraceName,
+					StartTime:     parseUnixNano(json.RawMessage(summary.StartTimeUnixNano)),
+					Duration:      summary.DurationMs * 1000,
+				},
+			},
+		})
+	}
+	return result, nil
+}
+
+// GetRequestTrace get the complete trace of a request
+func (backend *TempoBackend) GetRequestTrace(traceID string) (*Trace, error) {
+	request, _ := http.NewRequest(http.MethodGet,
+		backend.url+"api/traces/"+url.PathEscape(traceID), nil)
+	request.Header.Set("Accept", "application/json")
+	bodyBytes, err := queryTraceServer(backend.client, request)
+	if err != nil {
+		return nil, err
+	}
+
+	tempoTrace := &TempoTrace{}
+	err = json.Unmarshal(bodyBytes, tempoTrace)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to unmarshal trace, error %v", err)
+	}
+
+	trace := &Trace{TraceID: traceID}
+	for _, batch := range tempoTrace.Batches {
+		scopes := append(batch.ScopeSpans, batch.InstrumentationLibrarySpans...)
+		for _, scope := range scopes {
+			for _, tempoSpan := range scope.Spans {
+				startTime := parseUnixNano(tempoSpan.StartTimeUnixNano)
+				endTime := parseUnixNano(tempoSpan.EndTimeUnixNano)
+				trace.Spans = append(trace.Spans, &Span{
+					TraceID:       traceID,
+					SpanID:        normalizeId(tempoSpan.SpanID),
+					ParentID:      normalizeId(tempoSpan.ParentSpanID),
+					OperationName: tempoSpan.Name,
+					StartTime:     startTime,
+					Duration:      endTime - startTime,
+					Tags:          tempoTags(tempoSpan),
+				})
+			}
+		}
+	}
+
+	if len(trace.Spans) == 0 {
+		return nil, httpErrorf(http.StatusNotFound, "trace %s not found", traceID)
+	}
+
+	return trace, nil
+}
@@ -0,0 +1,109 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Objects to retrive traces from the zipkin v2 api
+
+type ZipkinSpan struct {
+	TraceID   string            `json:"traceId"`
+	ID        string            `json:"id"`
+	ParentID  string            `json:"parentId"`
+	Name      string            `json:"name"`
+	Timestamp int               `json:"timestamp"`
+	Duration  int               `json:"duration"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// ZipkinBackend retrieves traces from the zipkin v2 api
+type ZipkinBackend struct {
+	url    string
+	client *http.Client
+}
+
+// convertZipkinTrace convert the spans of a zipkin trace into a trace
+func convertZipkinTrace(spans []*ZipkinSpan) *Trace {
+	trace := &Trace{}
+	for _, zipkinSpan := range spans {
+		trace.TraceID = zipkinSpan.TraceID
+		trace.Spans = append(trace.Spans, &Span{
+			TraceID:       zipkinSpan.TraceID,
+			SpanID:        zipkinSpan.ID,
+			ParentID:      zipkinSpan.ParentID,
+			OperationName: zipkinSpan.Name,
+			StartTime:     zipkinSpan.Timestamp,
+			Duration:      zipkinSpan.Duration,
+			Tags:          zipkinSpan.Tags,
+		})
+	}
+	return trace
+}
+
+// ListRequests list the request traces of a flow
+func (backend *ZipkinBackend) ListRequests(function string, query *SearchQuery) ([]*Trace, error) {
+	params := url.Values{}
+	params.Set("serviceName", function)
+	// zipkin searches a lookback window in milliseconds before endTs
+	if query.End > 0 {
+		params.Set("endTs", strconv.Itoa(query.End/1000))
+	}
+	if query.Start > 0 {
+		end := query.End
+		if end == 0 {
+			end = int(time.Now().UnixNano() / 1000)
+		}
+		params.Set("lookback", strconv.Itoa((end-query.Start)/1000))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	request, _ := http.NewRequest(http.MethodGet,
+		backend.url+"api/v2/traces?"+params.Encode(), nil)
+	bodyBytes, err := queryTraceServer(backend.client, request)
+	if err != nil {
+		return nil, err
+	}
+
+	zipkinTraces := [][]*ZipkinSpan{}
+	err = json.Unmarshal(bodyBytes, &zipkinTraces)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to unmarshal traces, error %v", err)
+	}
+
+	result := make([]*Trace, 0, len(zipkinTraces))
+	for _, spans := range zipkinTraces {
+		if len(spans) == 0 {
+			continue
+		}
+		result = append(result, convertZipkinTrace(spans))
+	}
+	return result, nil
+}
+
+// GetRequestTrace get the complete trace of a request
+func (backend *ZipkinBackend) GetRequestTrace(traceID string) (*Trace, error) {
+	request, _ := http.NewRequest(http.MethodGet,
+		backend.url+"api/v2/trace/"+url.PathEscape(traceID), nil)
+	bodyBytes, err := queryTraceServer(backend.client, request)
+	if err != nil {
+		return nil, err
+	}
+
+	spans := []*ZipkinSpan{}
+	err = json.Unmarshal(bodyBytes, &spans)
+	if err != nil {
+		return nil, httpErrorf(http.StatusBadGateway, "failed to unmarshal trace, error %v", err)
+	}
+
+	if len(spans) == 0 {
+		return nil, httpErrorf(http.StatusNotFound, "trace %s not found", traceID)
+	}
+
+	return convertZipkinTrace(spans), nil
+}